@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/kataras/requestid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	jsonAccessLogFormat = "json"
+	textAccessLogFormat = "text"
+)
+
+type AccessLogConfiguration struct {
+	Enabled bool   `yaml:"enabled"`
+	Format  string `yaml:"format"`
+}
+
+func validateAccessLog(config AccessLogConfiguration) []string {
+	switch config.Format {
+	case "", jsonAccessLogFormat, textAccessLogFormat:
+		return nil
+	}
+	return []string{fmt.Sprintf("unknown accessLog.format %q, expected %q or %q", config.Format, jsonAccessLogFormat, textAccessLogFormat)}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.written += int64(n)
+	return n, err
+}
+
+// Flush lets streamed responses, like server-sent events, reach the client as they are written
+func (rec *statusRecorder) Flush() {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Hijack lets websocket sessions take over the connection through the recorder
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection does not support hijacking")
+	}
+	if rec.status == 0 {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The server read and write timeouts do not apply to a websocket session
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rw, nil
+}
+
+type accessLogEntryKey struct{}
+
+type accessLogEntry struct {
+	rateLimited bool
+}
+
+// markRateLimited flags the access log entry of the request, if any
+func markRateLimited(r *http.Request) {
+	if entry, ok := r.Context().Value(accessLogEntryKey{}).(*accessLogEntry); ok {
+		entry.rateLimited = true
+	}
+}
+
+func NewAccessLogger(config AccessLogConfiguration) *logrus.Logger {
+	if !config.Enabled {
+		return nil
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(logOutput)
+	if config.Format == textAccessLogFormat {
+		logger.SetFormatter(&logrus.TextFormatter{})
+	} else {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+	return logger
+}
+
+func AccessLogHandler(logger *logrus.Logger, label string, next http.Handler) http.Handler {
+	if logger == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessLogEntry{}
+		rec := &statusRecorder{ResponseWriter: w}
+
+		// Aborted responses are logged as well
+		defer func() {
+			logger.WithFields(logrus.Fields{
+				"label":        label,
+				"method":       r.Method,
+				"path":         r.URL.Path,
+				"status":       rec.status,
+				"duration":     time.Since(start).String(),
+				"ip":           clientIP(r),
+				"rate-limited": entry.rateLimited,
+				"requestid":    requestid.Get(r),
+			}).Info("Access")
+		}()
+
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessLogEntryKey{}, entry)))
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// accessLogLines decodes the JSON lines written by the access logger
+func accessLogLines(t *testing.T, output *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		line := map[string]interface{}{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("access log line %q is not JSON: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestAccessLog(t *testing.T) {
+	var output bytes.Buffer
+	logger := NewAccessLogger(AccessLogConfiguration{Enabled: true})
+	logger.SetOutput(&output)
+
+	handler := AccessLogHandler(logger, "tweets", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tweets/limited" {
+			DeniedHandler(nil, nil).ServeHTTP(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "created")
+	}))
+
+	for _, path := range []string{"/tweets/1", "/tweets/limited"} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	lines := accessLogLines(t, &output)
+	if len(lines) != 2 {
+		t.Fatalf("got %d access log lines, want 2", len(lines))
+	}
+	want := []map[string]interface{}{
+		{"msg": "Access", "label": "tweets", "method": "POST", "path": "/tweets/1", "status": float64(201), "ip": "10.0.0.1", "rate-limited": false},
+		{"msg": "Access", "label": "tweets", "method": "POST", "path": "/tweets/limited", "status": float64(429), "ip": "10.0.0.1", "rate-limited": true},
+	}
+	for index, fields := range want {
+		for name, value := range fields {
+			if lines[index][name] != value {
+				t.Errorf("line %d: %s = %v, want %v", index, name, lines[index][name], value)
+			}
+		}
+		if _, ok := lines[index]["duration"]; !ok {
+			t.Errorf("line %d has no duration", index)
+		}
+	}
+}
+
+func TestAccessLogTextFormat(t *testing.T) {
+	logger := NewAccessLogger(AccessLogConfiguration{Enabled: true, Format: "text"})
+	if _, ok := logger.Formatter.(*logrus.TextFormatter); !ok {
+		t.Errorf("formatter = %T, want the text formatter", logger.Formatter)
+	}
+}
+
+func TestAccessLogDisabled(t *testing.T) {
+	next := http.NotFoundHandler()
+	if logger := NewAccessLogger(AccessLogConfiguration{}); logger != nil {
+		t.Fatal("a disabled access log returned a logger")
+	}
+	if handler := AccessLogHandler(nil, "tweets", next); handler == nil {
+		t.Fatal("no handler without access log")
+	}
+}
+
+func TestAccessLogValidation(t *testing.T) {
+	for _, format := range []string{"", "json", "text"} {
+		if problems := validateAccessLog(AccessLogConfiguration{Enabled: true, Format: format}); len(problems) > 0 {
+			t.Errorf("format %q: got %v, want no problem", format, problems)
+		}
+	}
+
+	err := validateTestConfig(t, `
+accessLog:
+  enabled: true
+  format: txt
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`)
+	assertProblems(t, err, `unknown accessLog.format "txt", expected "json" or "text"`)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const defaultBasicAuthRealm = "Restricted"
+
+var (
+	unknownUserHashOnce  sync.Once
+	unknownUserHashValue []byte
+)
+
+// unknownUserHash is compared when the username is unknown, so that unknown and known users take the same time.
+// It is computed on the first unknown user, the gateways without basic auth never pay for it.
+func unknownUserHash() []byte {
+	unknownUserHashOnce.Do(func() {
+		unknownUserHashValue, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+	})
+	return unknownUserHashValue
+}
+
+type BasicAuthUser struct {
+	Username string `yaml:"username"`
+	// bcrypt hash of the password
+	Password string `yaml:"password"`
+}
+
+type BasicAuthConfiguration struct {
+	Realm string          `yaml:"realm"`
+	Users []BasicAuthUser `yaml:"users"`
+}
+
+func validateBasicAuth(config *BasicAuthConfiguration) []string {
+	var problems []string
+	if config == nil {
+		return problems
+	}
+	if len(config.Users) == 0 {
+		problems = append(problems, "basicAuth.users is required")
+	}
+	for _, user := range config.Users {
+		if user.Username == "" {
+			problems = append(problems, "basicAuth.users entries require a username")
+		}
+		if _, err := bcrypt.Cost([]byte(user.Password)); err != nil {
+			problems = append(problems, fmt.Sprintf("basicAuth password of %q must be a bcrypt hash: %v", user.Username, err))
+		}
+	}
+	return problems
+}
+
+func (config BasicAuthConfiguration) authorized(username string, password string) bool {
+	var hash []byte
+	found := 0
+	for _, user := range config.Users {
+		if subtle.ConstantTimeCompare([]byte(user.Username), []byte(username)) == 1 {
+			hash = []byte(user.Password)
+			found = 1
+		}
+	}
+	if found == 0 {
+		hash = unknownUserHash()
+	}
+	valid := bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+	return found == 1 && valid
+}
+
+// BasicAuthHandler challenges the requests without valid credentials with 401.
+// The credentials are not forwarded to the backend.
+func BasicAuthHandler(config *BasicAuthConfiguration, next http.Handler) http.Handler {
+	if config == nil {
+		return next
+	}
+
+	realm := config.Realm
+	if realm == "" {
+		realm = defaultBasicAuthRealm
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || !config.authorized(username, password) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm))
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		r.Header.Del("Authorization")
+		next.ServeHTTP(w, r)
+	})
+}
+
+const defaultAPIKeyHeader = "X-API-Key"
+
+type APIKeyConfiguration struct {
+	Header string   `yaml:"header"`
+	Keys   []string `yaml:"keys"`
+}
+
+func (config APIKeyConfiguration) header() string {
+	if config.Header == "" {
+		return defaultAPIKeyHeader
+	}
+	return config.Header
+}
+
+func validateAPIKey(config *APIKeyConfiguration) []string {
+	var problems []string
+	if config == nil {
+		return problems
+	}
+	if len(config.Keys) == 0 {
+		problems = append(problems, "apiKey.keys is required")
+	}
+	for _, key := range config.Keys {
+		if key == "" {
+			problems = append(problems, "apiKey.keys cannot contain an empty key")
+			break
+		}
+	}
+	return problems
+}
+
+// authorized compares the key with every allowed key, so the time taken does not tell which key matched
+func (config APIKeyConfiguration) authorized(key string) bool {
+	found := 0
+	for _, allowed := range config.Keys {
+		found |= subtle.ConstantTimeCompare([]byte(allowed), []byte(key))
+	}
+	return key != "" && found == 1
+}
+
+// APIKeyHandler answers 401 to the requests without an allowed API key.
+// The key is not forwarded to the backend.
+func APIKeyHandler(config *APIKeyConfiguration, next http.Handler) http.Handler {
+	if config == nil {
+		return next
+	}
+
+	header := config.header()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.authorized(r.Header.Get(header)) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		r.Header.Del(header)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/admin"
+    backend: "%s"
+    basicAuth:
+      realm: "Admin"
+      users:
+        - username: "alice"
+          password: "%s"
+`, echoBackend(t), hash))
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		status   int
+	}{
+		{name: "valid", username: "alice", password: "s3cret", status: http.StatusOK},
+		{name: "wrong password", username: "alice", password: "guess", status: http.StatusUnauthorized},
+		{name: "unknown user", username: "bob", password: "s3cret", status: http.StatusUnauthorized},
+		{name: "missing", status: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, gateway.URL+"/admin", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.username != "" {
+				req.SetBasicAuth(test.username, test.password)
+			}
+			resp, body := do(t, req)
+			if resp.StatusCode != test.status {
+				t.Fatalf("got %d, want %d", resp.StatusCode, test.status)
+			}
+			if test.status == http.StatusUnauthorized {
+				if challenge := resp.Header.Get("WWW-Authenticate"); challenge != `Basic realm="Admin", charset="UTF-8"` {
+					t.Errorf("WWW-Authenticate = %q", challenge)
+				}
+				return
+			}
+			var echoed echoedRequest
+			if err := json.Unmarshal([]byte(body), &echoed); err != nil {
+				t.Fatal(err)
+			}
+			if credentials := echoed.Header.Get("Authorization"); credentials != "" {
+				t.Errorf("the credentials were forwarded to the backend: %q", credentials)
+			}
+		})
+	}
+}
+
+func TestUnknownUserHash(t *testing.T) {
+	hash := unknownUserHash()
+	if cost, err := bcrypt.Cost(hash); err != nil || cost != bcrypt.DefaultCost {
+		t.Fatalf("got the cost %d, %v, want a hash of the default cost", cost, err)
+	}
+	if again := unknownUserHash(); &again[0] != &hash[0] {
+		t.Error("the hash was computed again")
+	}
+}
+
+func TestBasicAuthValidation(t *testing.T) {
+	assertProblems(t, validateTestConfig(t, `
+routes:
+  - frontend: "/admin"
+    backend: "http://localhost:8888"
+    basicAuth:
+      users:
+        - username: "alice"
+          password: "plain"
+`), `basicAuth password of "alice" must be a bcrypt hash`)
+}
+
+func TestAPIKey(t *testing.T) {
+	t.Setenv("ICE_TEST_API_KEY", "env-key")
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    apiKey:
+      header: "X-Tweets-Key"
+      keys:
+        - "static-key"
+        - "${ICE_TEST_API_KEY}"
+`, echoBackend(t)))
+
+	tests := []struct {
+		name   string
+		key    string
+		status int
+	}{
+		{name: "accepted", key: "static-key", status: http.StatusOK},
+		{name: "accepted from env", key: "env-key", status: http.StatusOK},
+		{name: "rejected", key: "stolen-key", status: http.StatusUnauthorized},
+		{name: "missing", status: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			if test.key != "" {
+				header.Set("X-Tweets-Key", test.key)
+			}
+			resp, body := get(t, gateway.URL+"/tweets", header)
+			if resp.StatusCode != test.status {
+				t.Fatalf("got %d, want %d", resp.StatusCode, test.status)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			var echoed echoedRequest
+			if err := json.Unmarshal([]byte(body), &echoed); err != nil {
+				t.Fatal(err)
+			}
+			if key := echoed.Header.Get("X-Tweets-Key"); key != "" {
+				t.Errorf("the key was forwarded to the backend: %q", key)
+			}
+		})
+	}
+}
+
+func TestAPIKeyValidation(t *testing.T) {
+	assertProblems(t, validateTestConfig(t, `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    apiKey:
+      keys: []
+  - frontend: "/users"
+    backend: "http://localhost:8888"
+    apiKey:
+      keys: [""]
+`), "apiKey.keys is required", "apiKey.keys cannot contain an empty key")
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+// Backend is an upstream instance of a route. It can be written in the
+// configuration either as a plain URL or as a mapping.
+type Backend struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight"`
+}
+
+func (b *Backend) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&b.URL)
+	}
+	type plain Backend
+	return value.Decode((*plain)(b))
+}
+
+const (
+	roundRobinBalancing = "roundRobin"
+	leastConnBalancing  = "leastConn"
+	leastTimeBalancing  = "leastTime"
+)
+
+type upstream struct {
+	url    *url.URL
+	weight int
+	down   atomic.Bool
+	// Requests sent to the upstream whose response is not fully read yet
+	active  atomic.Int64
+	breaker *circuitBreaker
+	// Moving average of the response times of the upstream
+	latency      ewma
+	latencyGauge prometheus.Gauge
+}
+
+func (u *upstream) available() bool {
+	return !u.down.Load() && u.breaker.ready()
+}
+
+type Balancer interface {
+	// Next returns the upstream to use for the request, or nil when none is available
+	Next(r *http.Request) *upstream
+}
+
+type roundRobinBalancer struct {
+	upstreams []*upstream
+	counter   atomic.Uint64
+}
+
+func (b *roundRobinBalancer) Next(r *http.Request) *upstream {
+	count := uint64(len(b.upstreams))
+	start := b.counter.Add(1) - 1
+	for i := uint64(0); i < count; i++ {
+		if u := b.upstreams[(start+i)%count]; u.available() {
+			return u
+		}
+	}
+	return nil
+}
+
+// weightedBalancer implements the smooth weighted round-robin of nginx: the upstreams
+// are interleaved in proportion to their weight, instead of being picked in bursts.
+type weightedBalancer struct {
+	upstreams []*upstream
+	mu        sync.Mutex
+	current   []int
+}
+
+func (b *weightedBalancer) Next(r *http.Request) *upstream {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	best := -1
+	total := 0
+	for i, u := range b.upstreams {
+		if !u.available() {
+			continue
+		}
+		b.current[i] += u.weight
+		total += u.weight
+		if best == -1 || b.current[i] > b.current[best] {
+			best = i
+		}
+	}
+	if best == -1 {
+		return nil
+	}
+	b.current[best] -= total
+	return b.upstreams[best]
+}
+
+// leastConnBalancer picks the upstream with the fewest active requests,
+// ties are broken in turn so idle upstreams share the traffic evenly.
+type leastConnBalancer struct {
+	upstreams []*upstream
+	counter   atomic.Uint64
+}
+
+func (b *leastConnBalancer) Next(r *http.Request) *upstream {
+	count := uint64(len(b.upstreams))
+	start := b.counter.Add(1) - 1
+	var best *upstream
+	for i := uint64(0); i < count; i++ {
+		u := b.upstreams[(start+i)%count]
+		if u.available() && (best == nil || u.active.Load() < best.active.Load()) {
+			best = u
+		}
+	}
+	return best
+}
+
+// leastTimeBalancer picks the upstream with the lowest latency average, weighted by its active requests
+// so that a fast upstream is not flooded. Upstreams never tried yet are tried first, once at a time.
+type leastTimeBalancer struct {
+	upstreams []*upstream
+	counter   atomic.Uint64
+}
+
+func (b *leastTimeBalancer) Next(r *http.Request) *upstream {
+	count := uint64(len(b.upstreams))
+	start := b.counter.Add(1) - 1
+	var best *upstream
+	var bestScore float64
+	for i := uint64(0); i < count; i++ {
+		u := b.upstreams[(start+i)%count]
+		if !u.available() {
+			continue
+		}
+		score := u.latency.value() * float64(u.active.Load()+1)
+		// Without a latency yet, the upstream waits for its first attempt before it gets more requests
+		if score == 0 && u.active.Load() > 0 {
+			score = math.Inf(1)
+		}
+		if best == nil || score < bestScore {
+			best, bestScore = u, score
+		}
+	}
+	return best
+}
+
+func validateBalancing(item GatewayItem) []string {
+	var problems []string
+	switch item.Balancing {
+	case "", roundRobinBalancing:
+	case leastConnBalancing, leastTimeBalancing:
+		for _, backend := range item.Backends {
+			if backend.Weight != 0 {
+				problems = append(problems, fmt.Sprintf("backend weights cannot be used with the %s balancing", item.Balancing))
+				break
+			}
+		}
+	case stickyBalancing:
+		if item.Sticky != nil && item.Sticky.Cookie != "" && item.Sticky.Header != "" {
+			problems = append(problems, "sticky.cookie and sticky.header cannot be used at the same time")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown balancing %q, expected %q, %q, %q or %q", item.Balancing, roundRobinBalancing, leastConnBalancing, leastTimeBalancing, stickyBalancing))
+	}
+	if item.Sticky != nil && item.Balancing != stickyBalancing {
+		problems = append(problems, fmt.Sprintf("sticky requires the %s balancing", stickyBalancing))
+	}
+	return problems
+}
+
+func newUpstreams(item GatewayItem, routeMetrics *RouteMetrics) ([]*upstream, error) {
+	var upstreams []*upstream
+	for _, backend := range item.backends() {
+		backendUrl, err := url.Parse(backend.URL)
+		if err != nil {
+			return nil, err
+		}
+		weight := backend.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		u := &upstream{url: backendUrl, weight: weight, latencyGauge: routeMetrics.backendLatency(backend.URL)}
+		if item.CircuitBreaker != nil {
+			u.breaker = newCircuitBreaker(item.Label, backend.URL, *item.CircuitBreaker)
+		}
+		upstreams = append(upstreams, u)
+	}
+	return upstreams, nil
+}
+
+func NewBalancer(item GatewayItem, upstreams []*upstream) Balancer {
+	switch item.Balancing {
+	case leastConnBalancing:
+		return &leastConnBalancer{upstreams: upstreams}
+	case leastTimeBalancing:
+		return &leastTimeBalancer{upstreams: upstreams}
+	case stickyBalancing:
+		return &stickyBalancer{upstreams: upstreams, config: item.Sticky}
+	}
+	for _, backend := range item.Backends {
+		if backend.Weight != 0 {
+			return &weightedBalancer{upstreams: upstreams, current: make([]int, len(upstreams))}
+		}
+	}
+	return &roundRobinBalancer{upstreams: upstreams}
+}
+
+// trackedBody releases the active request of the upstream once the response body is closed
+type trackedBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *trackedBody) Close() error {
+	b.release()
+	return b.ReadCloser.Close()
+}
+
+// trackedConn is the trackedBody of a protocol switch, whose body is also writable
+type trackedConn struct {
+	io.ReadWriteCloser
+	release func()
+}
+
+func (c *trackedConn) Close() error {
+	c.release()
+	return c.ReadWriteCloser.Close()
+}
+
+// track counts a request as active on the upstream until the returned body is closed
+func (u *upstream) track(body io.ReadCloser) io.ReadCloser {
+	var once sync.Once
+	release := func() {
+		once.Do(func() { u.active.Add(-1) })
+	}
+	if conn, ok := body.(io.ReadWriteCloser); ok {
+		return &trackedConn{ReadWriteCloser: conn, release: release}
+	}
+	return &trackedBody{ReadCloser: body, release: release}
+}
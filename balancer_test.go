@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRoundRobin(t *testing.T) {
+	var urls []interface{}
+	hits := map[string]int{}
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		urls = append(urls, newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}).URL)
+	}
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backends: ["%s", "%s", "%s"]
+`, urls...))
+
+	for i := 0; i < 30; i++ {
+		_, body := get(t, gateway.URL+"/tweets", nil)
+		hits[body]++
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if hits[name] != 10 {
+			t.Errorf("backend %s served %d of the 30 requests, want 10: %v", name, hits[name], hits)
+		}
+	}
+}
+
+func TestRoundRobinSkipsDownUpstreams(t *testing.T) {
+	upstreams := []*upstream{{weight: 1}, {weight: 1}, {weight: 1}}
+	upstreams[1].down.Store(true)
+	balancer := &roundRobinBalancer{upstreams: upstreams}
+
+	for i := 0; i < 6; i++ {
+		if u := balancer.Next(nil); u == upstreams[1] {
+			t.Fatalf("request %d was sent to the down upstream", i)
+		}
+	}
+	for _, u := range upstreams {
+		u.down.Store(true)
+	}
+	if u := balancer.Next(nil); u != nil {
+		t.Errorf("got an upstream while all are down")
+	}
+}
+
+func TestWeightedDistribution(t *testing.T) {
+	var urls []interface{}
+	hits := map[string]int{}
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		urls = append(urls, newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}).URL)
+	}
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backends:
+      - url: "%s"
+        weight: 5
+      - url: "%s"
+        weight: 3
+      - url: "%s"
+        weight: 2
+`, urls...))
+
+	for i := 0; i < 100; i++ {
+		_, body := get(t, gateway.URL+"/tweets", nil)
+		hits[body]++
+	}
+	for name, want := range map[string]int{"a": 50, "b": 30, "c": 20} {
+		if hits[name] < want-2 || hits[name] > want+2 {
+			t.Errorf("backend %s served %d of the 100 requests, want about %d: %v", name, hits[name], want, hits)
+		}
+	}
+}
+
+func TestWeightedIsSmooth(t *testing.T) {
+	a, b, c := &upstream{weight: 5}, &upstream{weight: 1}, &upstream{weight: 1}
+	balancer := &weightedBalancer{upstreams: []*upstream{a, b, c}, current: make([]int, 3)}
+	names := map[*upstream]string{a: "a", b: "b", c: "c"}
+
+	var sequence string
+	for i := 0; i < 7; i++ {
+		sequence += names[balancer.Next(nil)]
+	}
+	// The upstream of weight 5 is interleaved with the others instead of being picked 5 times in a row
+	if sequence != "aabacaa" {
+		t.Errorf("got the sequence %s, want aabacaa", sequence)
+	}
+}
+
+func TestLeastConnAvoidsBusyBackend(t *testing.T) {
+	held := make(chan string, 1)
+	release := make(chan struct{})
+	var urls []interface{}
+	for _, name := range []string{"a", "b"} {
+		name := name
+		urls = append(urls, newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/tweets/hold" {
+				held <- name
+				<-release
+			}
+			w.Write([]byte(name))
+		}).URL)
+	}
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets/"
+    backends: ["%s/tweets/", "%s/tweets/"]
+    balancing: "leastConn"
+`, urls...))
+
+	done := make(chan struct{})
+	go func() {
+		if resp, err := http.Get(gateway.URL + "/tweets/hold"); err == nil {
+			resp.Body.Close()
+		}
+		close(done)
+	}()
+	busy := <-held
+	defer func() {
+		close(release)
+		<-done
+	}()
+
+	for i := 0; i < 4; i++ {
+		if _, body := get(t, gateway.URL+"/tweets/1", nil); body == busy {
+			t.Fatalf("request %d was sent to the busy backend %s", i, busy)
+		}
+	}
+}
+
+func TestLeastTimePrefersFastBackend(t *testing.T) {
+	hits := map[string]int{}
+	slow := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("slow"))
+	})
+	fast := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backends: ["%s", "%s"]
+    balancing: "leastTime"
+`, slow.URL, fast.URL))
+
+	for i := 0; i < 20; i++ {
+		_, body := get(t, gateway.URL+"/tweets", nil)
+		hits[body]++
+	}
+	// Each backend is tried once before their latencies are compared
+	if hits["slow"] > 2 {
+		t.Errorf("the slow backend served %d of the 20 requests: %v", hits["slow"], hits)
+	}
+}
+
+func TestLeastTimeWeighsActiveRequests(t *testing.T) {
+	fast, slow, unknown := &upstream{weight: 1}, &upstream{weight: 1}, &upstream{weight: 1}
+	fast.latency.observe(10 * time.Millisecond)
+	slow.latency.observe(50 * time.Millisecond)
+	balancer := &leastTimeBalancer{upstreams: []*upstream{fast, slow}}
+
+	if u := balancer.Next(nil); u != fast {
+		t.Errorf("picked the slow upstream while both are idle")
+	}
+	fast.active.Store(9)
+	if u := balancer.Next(nil); u != slow {
+		t.Errorf("picked the fast upstream with 9 active requests over the idle slow one")
+	}
+
+	balancer.upstreams = append(balancer.upstreams, unknown)
+	if u := balancer.Next(nil); u != unknown {
+		t.Errorf("the upstream without any response yet was not tried first")
+	}
+	unknown.active.Store(1)
+	if u := balancer.Next(nil); u == unknown {
+		t.Errorf("the upstream got another request before its first attempt completed")
+	}
+}
+
+func TestLeastTimeAvoidsFailingBackend(t *testing.T) {
+	for _, retry := range []int{0, 1} {
+		t.Run(fmt.Sprintf("%d retries", retry), func(t *testing.T) {
+			gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backends: ["%s", "%s"]
+    balancing: "leastTime"
+    retry:
+      attempts: %d
+      backoff: 1ms
+`, closedAddress(t), okBackend(t), retry))
+
+			failed := 0
+			for i := 0; i < 20; i++ {
+				if resp, _ := get(t, gateway.URL+"/tweets", nil); resp.StatusCode != http.StatusOK {
+					failed++
+				}
+			}
+			// The refused backend is only tried by the first request, which is retried on the healthy one
+			if want := 1 - retry; failed > want {
+				t.Errorf("%d of the 20 requests failed, want at most %d", failed, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// RateLimitBypassConfiguration lists the trusted clients of a route that are never rate limited,
+// identified by their IP or by a key sent in a header
+type RateLimitBypassConfiguration struct {
+	IPs    []string `yaml:"ips"`
+	Header string   `yaml:"header"`
+	Keys   []string `yaml:"keys"`
+}
+
+func validateRateLimitBypass(item GatewayItem) []string {
+	var problems []string
+	config := item.RateLimitBypass
+	if config == nil {
+		return problems
+	}
+	if !item.rateLimited() {
+		problems = append(problems, "rateLimitBypass requires a rate limit")
+	}
+	if len(config.IPs) == 0 && len(config.Keys) == 0 {
+		problems = append(problems, "rateLimitBypass requires ips or keys")
+	}
+	if _, err := parseNetworks(config.IPs); err != nil {
+		problems = append(problems, fmt.Sprintf("rateLimitBypass.ips: %v", err))
+	}
+	for _, key := range config.Keys {
+		if key == "" {
+			problems = append(problems, "rateLimitBypass.keys cannot contain an empty key")
+			break
+		}
+	}
+	return problems
+}
+
+type rateLimitBypassKey struct{}
+
+// RateLimitBypassHandler flags the requests of the trusted clients. It runs before the authentication,
+// which removes the API key header, the rate limiter is skipped later by RateLimitSwitch.
+func RateLimitBypassHandler(config *RateLimitBypassConfiguration, next http.Handler) http.Handler {
+	if config == nil {
+		return next
+	}
+	networks, _ := parseNetworks(config.IPs)
+	keys := APIKeyConfiguration{Header: config.Header, Keys: config.Keys}
+	header := keys.header()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(clientIP(r))
+		if (ip != nil && containsIP(networks, ip)) || (len(keys.Keys) > 0 && keys.authorized(r.Header.Get(header))) {
+			r = r.WithContext(context.WithValue(r.Context(), rateLimitBypassKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimitSwitch sends the requests flagged by RateLimitBypassHandler to the unlimited handler
+func RateLimitSwitch(limited http.Handler, unlimited http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if bypass, _ := r.Context().Value(rateLimitBypassKey{}).(bool); bypass {
+			unlimited.ServeHTTP(w, r)
+			return
+		}
+		limited.ServeHTTP(w, r)
+	})
+}
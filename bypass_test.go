@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitBypass(t *testing.T) {
+	handler, registry := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+metrics: true
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+    reqsPerSec: 1
+    burst: 0
+    varyBy:
+      remoteAddr: true
+    rateLimitBypass:
+      ips: ["10.0.0.0/8"]
+      header: "X-Internal-Key"
+      keys: ["internal-key"]
+`, okBackend(t))))
+
+	send := func(remoteAddr string, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/tweets", nil)
+		req.RemoteAddr = remoteAddr
+		if key != "" {
+			req.Header.Set("X-Internal-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		key        string
+		throttled  bool
+	}{
+		{"allowlisted IP", "10.1.2.3:1234", "", false},
+		{"allowlisted key", "192.0.2.1:1234", "internal-key", false},
+		{"unknown key", "192.0.2.2:1234", "other-key", true},
+		{"other client", "192.0.2.3:1234", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var statuses []int
+			for i := 0; i < 5; i++ {
+				rec := send(test.remoteAddr, test.key)
+				statuses = append(statuses, rec.Code)
+				if !test.throttled && rec.Header().Get("X-RateLimit-Limit") != "" {
+					t.Errorf("the bypassed request has the rate limit headers")
+				}
+			}
+			want := []int{200, 200, 200, 200, 200}
+			if test.throttled {
+				want = []int{200, 429, 429, 429, 429}
+			}
+			if fmt.Sprint(statuses) != fmt.Sprint(want) {
+				t.Errorf("got %v, want %v", statuses, want)
+			}
+		})
+	}
+
+	// The bypassed requests are still proxied and recorded
+	if got := metricValue(t, registry, "tweets_requests_total", nil); got != 12 {
+		t.Errorf("tweets_requests_total = %v, want the 10 bypassed and 2 allowed requests", got)
+	}
+}
+
+func TestRateLimitBypassValidation(t *testing.T) {
+	err := validateTestConfig(t, `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    rateLimitBypass:
+      ips: ["10.0.0.0/33"]
+`)
+	assertProblems(t, err, "rateLimitBypass requires a rate limit", "rateLimitBypass.ips:")
+
+	err = validateTestConfig(t, `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    reqsPerSec: 1
+    rateLimitBypass:
+      keys: [""]
+`)
+	assertProblems(t, err, "rateLimitBypass.keys cannot contain an empty key")
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/requestid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCacheMaxEntries    = 1000
+	defaultCacheMaxEntryBytes = 1 << 20
+	defaultCacheMaxBytes      = 64 << 20
+)
+
+// CacheConfiguration keeps the successful GET responses of a route in memory for the ttl
+type CacheConfiguration struct {
+	TTL           time.Duration `yaml:"ttl"`
+	MaxEntries    int           `yaml:"maxEntries"`
+	MaxEntryBytes int64         `yaml:"maxEntryBytes"`
+	// The total size of the entries of the route, the least recently used are evicted beyond
+	MaxBytes int64 `yaml:"maxBytes"`
+}
+
+func validateCache(item GatewayItem) []string {
+	var problems []string
+	config := item.Cache
+	if config == nil {
+		return problems
+	}
+	if config.TTL <= 0 {
+		problems = append(problems, fmt.Sprintf("cache.ttl must be positive, got %v", config.TTL))
+	}
+	if config.MaxEntries < 0 {
+		problems = append(problems, fmt.Sprintf("cache.maxEntries must be positive, got %d", config.MaxEntries))
+	}
+	if config.MaxEntryBytes < 0 {
+		problems = append(problems, fmt.Sprintf("cache.maxEntryBytes must be positive, got %d", config.MaxEntryBytes))
+	}
+	if config.MaxBytes < 0 {
+		problems = append(problems, fmt.Sprintf("cache.maxBytes must be positive, got %d", config.MaxBytes))
+	} else if config.MaxBytes > 0 && config.MaxEntryBytes > config.MaxBytes {
+		problems = append(problems, fmt.Sprintf("cache.maxEntryBytes %d is larger than maxBytes %d", config.MaxEntryBytes, config.MaxBytes))
+	}
+	if item.Streaming || item.Protocol == grpcProtocol {
+		problems = append(problems, "cache cannot be used with streaming or grpc routes")
+	}
+	return problems
+}
+
+type cacheEntry struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+// size approximates the memory used by the entry with the length of its body and headers
+func (entry *cacheEntry) size() int64 {
+	size := int64(len(entry.key) + len(entry.body))
+	for name, values := range entry.header {
+		for _, value := range values {
+			size += int64(len(name) + len(value))
+		}
+	}
+	return size
+}
+
+// ResponseCache is a least recently used cache of the responses of a route
+type ResponseCache struct {
+	ttl           time.Duration
+	maxEntries    int
+	maxEntryBytes int64
+	maxBytes      int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	bytes   int64
+}
+
+// NewResponseCache returns the cache of the route, or nil when the route has no cache
+func NewResponseCache(config *CacheConfiguration) *ResponseCache {
+	if config == nil {
+		return nil
+	}
+	cache := &ResponseCache{
+		ttl:           config.TTL,
+		maxEntries:    valueOrDefault(config.MaxEntries, defaultCacheMaxEntries),
+		maxEntryBytes: int64(valueOrDefault(int(config.MaxEntryBytes), defaultCacheMaxEntryBytes)),
+		maxBytes:      int64(valueOrDefault(int(config.MaxBytes), defaultCacheMaxBytes)),
+		entries:       make(map[string]*list.Element),
+		lru:           list.New(),
+	}
+	// An entry cannot be larger than the whole cache
+	if cache.maxEntryBytes > cache.maxBytes {
+		cache.maxEntryBytes = cache.maxBytes
+	}
+	return cache
+}
+
+func (c *ResponseCache) get(key string, now time.Time) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		c.remove(element)
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return entry
+}
+
+// set keeps the entry, and evicts the least recently used ones beyond the max entries and bytes
+func (c *ResponseCache) set(entry *cacheEntry) {
+	size := entry.size()
+	if size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[entry.key]; ok {
+		c.remove(element)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.bytes += size
+	for c.lru.Len() > c.maxEntries || c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *ResponseCache) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	c.lru.Remove(element)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size()
+}
+
+// cacheKey identifies the response of a request, the encoding of the body depends on the client
+func cacheKey(r *http.Request) string {
+	return r.Method + " " + r.URL.RequestURI() + "\n" + r.Header.Get("Accept-Encoding")
+}
+
+func hasCacheDirective(header http.Header, directives ...string) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			for _, expected := range directives {
+				if directive == expected {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// cacheable tells whether the response can be shared with the other clients of the route
+func cacheable(status int, header http.Header) bool {
+	if status < http.StatusOK || status >= http.StatusMultipleChoices {
+		return false
+	}
+	if hasCacheDirective(header, "no-store", "private", "no-cache") || header.Get("Set-Cookie") != "" || header.Get("Trailer") != "" {
+		return false
+	}
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" && !strings.EqualFold(name, "Accept-Encoding") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// cachingWriter keeps the headers and the body of the response written by the route. The headers set
+// before by the gateway, like the request ID or the rate limit headers, are not part of the response kept.
+type cachingWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	limit       int64
+	noStore     bool
+	overflow    bool
+	wroteHeader bool
+}
+
+func (w *cachingWriter) Header() http.Header {
+	if w.wroteHeader {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *cachingWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	// The informational responses are sent with the headers set so far, the final response follows
+	if status >= 100 && status < http.StatusOK {
+		for name, values := range w.header {
+			w.ResponseWriter.Header()[name] = append([]string(nil), values...)
+		}
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	w.header = w.header.Clone()
+	for name, values := range w.header {
+		w.ResponseWriter.Header()[name] = append([]string(nil), values...)
+	}
+	if w.storable() {
+		w.ResponseWriter.Header().Set("X-Cache", "MISS")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// storable tells whether the response is kept once written, as far as known from its headers
+func (w *cachingWriter) storable() bool {
+	if w.noStore || !cacheable(w.status, w.header) {
+		return false
+	}
+	length, err := strconv.ParseInt(w.header.Get("Content-Length"), 10, 64)
+	return err != nil || length <= w.limit
+}
+
+func (w *cachingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.overflow {
+		if int64(w.body.Len()+len(b)) > w.limit {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cachingWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *cachingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// CacheHandler answers the GET requests of the route from the cache, and keeps the successful responses
+// of the backend. The clients sending Cache-Control: no-store or no-cache always reach the backend.
+// The cache hits are logged and counted in the route metrics like the proxied requests.
+func CacheHandler(cache *ResponseCache, label string, routeMetrics *RouteMetrics, next http.Handler) http.Handler {
+	if cache == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("Authorization") != "" || isWebSocketRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		noStore := hasCacheDirective(r.Header, "no-store")
+		key := cacheKey(r)
+		now := time.Now()
+
+		if !noStore && !hasCacheDirective(r.Header, "no-cache") {
+			if entry := cache.get(key, now); entry != nil {
+				serveCached(w, r, entry, label, routeMetrics, now)
+				return
+			}
+		}
+
+		writer := &cachingWriter{ResponseWriter: w, header: make(http.Header), limit: cache.maxEntryBytes, noStore: noStore}
+		next.ServeHTTP(writer, r)
+		if !writer.wroteHeader || writer.overflow || !writer.storable() || r.Context().Err() != nil {
+			return
+		}
+		// The cost was charged when the backend answered, the hits do not charge it again
+		writer.header.Del(rateLimitCostHeader)
+		cache.set(&cacheEntry{
+			key:     key,
+			status:  writer.status,
+			header:  writer.header,
+			body:    append([]byte(nil), writer.body.Bytes()...),
+			stored:  now,
+			expires: now.Add(cache.ttl),
+		})
+	})
+}
+
+// serveCached answers the entry, the request is logged and counted like a proxied one
+func serveCached(w http.ResponseWriter, r *http.Request, entry *cacheEntry, label string, routeMetrics *RouteMetrics, start time.Time) {
+	id := requestid.Get(r)
+	logrus.WithFields(logrus.Fields{
+		"label":      label,
+		"method":     r.Method,
+		"uri":        r.RequestURI,
+		"user-agent": r.UserAgent(),
+		"requestid":  id,
+	}).Info("Incoming call")
+	defer routeMetrics.started()()
+
+	rec := &statusRecorder{ResponseWriter: w}
+	for name, values := range entry.header {
+		rec.Header()[name] = append([]string(nil), values...)
+	}
+	rec.Header().Set("Age", strconv.Itoa(int(start.Sub(entry.stored).Seconds())))
+	rec.Header().Set("X-Cache", "HIT")
+	rec.WriteHeader(entry.status)
+	rec.Write(entry.body)
+	routeMetrics.transferred(nil, rec)
+
+	execTime := time.Since(start)
+	logrus.WithFields(logrus.Fields{
+		"label":      label,
+		"method":     r.Method,
+		"uri":        r.RequestURI,
+		"user-agent": r.UserAgent(),
+		"requestid":  id,
+	}).Info(fmt.Sprintf("Served from the cache in %v", execTime))
+	routeMetrics.completed(r, entry.status, execTime)
+}
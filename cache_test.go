@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cachedBackend counts its calls, the status and the Cache-Control of its responses are set by the query
+func cachedBackend(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		call := calls.Add(1)
+		if cacheControl := r.URL.Query().Get("cacheControl"); cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		if r.URL.Query().Get("cost") != "" {
+			w.Header().Set(rateLimitCostHeader, r.URL.Query().Get("cost"))
+		}
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprintf(w, "call %d", call)
+	})
+	return backend.URL, &calls
+}
+
+func TestResponseCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		header http.Header
+		cached bool
+		// The X-Cache header of the second response, MISS only when the response is kept
+		xCache string
+	}{
+		{"GET", "/tweets?page=1", nil, true, "HIT"},
+		{"other query", "/tweets?page=2", nil, true, "HIT"},
+		{"client no-store", "/tweets?page=3", http.Header{"Cache-Control": {"no-store"}}, false, ""},
+		{"client no-cache", "/tweets?page=4", http.Header{"Cache-Control": {"no-cache"}}, false, "MISS"},
+		{"backend no-store", "/tweets?cacheControl=no-store", nil, false, ""},
+		{"backend private", "/tweets?cacheControl=private", nil, false, ""},
+		{"error", "/tweets?fail=1", nil, false, ""},
+		{"authenticated", "/tweets?page=5", http.Header{"Authorization": {"Bearer token"}}, false, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend, calls := cachedBackend(t)
+			gateway, registry := startTestGateway(t, loadTestConfig(t, fmt.Sprintf(`
+metrics: true
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+    cache:
+      ttl: 1m
+`, backend)))
+
+			first, firstBody := get(t, gateway.URL+test.path, test.header)
+			second, secondBody := get(t, gateway.URL+test.path, test.header)
+			if test.cached {
+				if calls.Load() != 1 || secondBody != firstBody || second.Header.Get("X-Cache") != "HIT" || first.Header.Get("X-Cache") != "MISS" {
+					t.Errorf("got %q then %q from %d backend calls, want the second response from the cache", firstBody, secondBody, calls.Load())
+				}
+			} else if calls.Load() != 2 {
+				t.Errorf("the backend got %d calls, want the response not cached", calls.Load())
+			}
+			if got := second.Header.Get("X-Cache"); got != test.xCache {
+				t.Errorf("got X-Cache %q, want %q", got, test.xCache)
+			}
+			// The cache hits are counted like the proxied requests
+			if got := metricValue(t, registry, "tweets_requests_total", nil); got != 2 {
+				t.Errorf("tweets_requests_total = %v, want 2", got)
+			}
+			if got := metricValue(t, registry, "tweets_http_request_duration_ms", nil); got != 2 {
+				t.Errorf("got %v response time observations, want 2", got)
+			}
+		})
+	}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	backend, calls := cachedBackend(t)
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    cache:
+      ttl: 100ms
+`, backend))
+
+	get(t, gateway.URL+"/tweets", nil)
+	if _, body := get(t, gateway.URL+"/tweets", nil); body != "call 1" {
+		t.Fatalf("got %q before the ttl, want the cached response", body)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, body := get(t, gateway.URL+"/tweets", nil); body != "call 2" || calls.Load() != 2 {
+		t.Errorf("got %q after the ttl, want the response refetched", body)
+	}
+}
+
+func TestResponseCacheDoesNotReplayCost(t *testing.T) {
+	backend, calls := cachedBackend(t)
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    reqsPerSec: 1
+    burst: 5
+    rateLimitCost: true
+    cache:
+      ttl: 1m
+`, backend))
+
+	// The miss costs 3 of the 6 tokens, each hit then takes a single token
+	var statuses []int
+	for i := 0; i < 5; i++ {
+		resp, _ := get(t, gateway.URL+"/tweets?cost=3", nil)
+		if resp.Header.Get(rateLimitCostHeader) != "" {
+			t.Errorf("request %d: the cost header was sent to the client", i)
+		}
+		statuses = append(statuses, resp.StatusCode)
+	}
+	if want := []int{200, 200, 200, 200, 429}; fmt.Sprint(statuses) != fmt.Sprint(want) || calls.Load() != 1 {
+		t.Errorf("got %v with %d backend calls, want %v with one call", statuses, calls.Load(), want)
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	cache := NewResponseCache(&CacheConfiguration{TTL: time.Minute, MaxEntries: 2})
+	now := time.Now()
+	for _, key := range []string{"a", "b"} {
+		cache.set(&cacheEntry{key: key, stored: now, expires: now.Add(time.Minute)})
+	}
+	cache.get("a", now)
+	cache.set(&cacheEntry{key: "c", stored: now, expires: now.Add(time.Minute)})
+
+	if cache.get("b", now) != nil {
+		t.Errorf("the least recently used entry was kept")
+	}
+	if cache.get("a", now) == nil || cache.get("c", now) == nil {
+		t.Errorf("the recently used entries were evicted")
+	}
+	if cache.get("a", now.Add(time.Minute)) != nil {
+		t.Errorf("got an expired entry")
+	}
+}
+
+func TestResponseCacheMaxBytes(t *testing.T) {
+	cache := NewResponseCache(&CacheConfiguration{TTL: time.Minute, MaxBytes: 250})
+	if cache.maxEntryBytes != 250 {
+		t.Errorf("got maxEntryBytes %d, want it bounded by maxBytes", cache.maxEntryBytes)
+	}
+	now := time.Now()
+	for _, key := range []string{"a", "b", "c"} {
+		cache.set(&cacheEntry{key: key, body: make([]byte, 99), stored: now, expires: now.Add(time.Minute)})
+	}
+	if cache.get("a", now) != nil || cache.get("b", now) == nil || cache.get("c", now) == nil {
+		t.Errorf("want the least recently used entry evicted beyond maxBytes")
+	}
+	if cache.bytes != 200 {
+		t.Errorf("got %d bytes, want 200", cache.bytes)
+	}
+	cache.set(&cacheEntry{key: "d", body: make([]byte, 250), stored: now, expires: now.Add(time.Minute)})
+	if cache.get("d", now) != nil || cache.get("b", now) == nil {
+		t.Errorf("an entry larger than maxBytes was stored")
+	}
+	cache.get("b", now.Add(time.Minute))
+	if cache.bytes != 100 {
+		t.Errorf("got %d bytes after the expiry, want 100", cache.bytes)
+	}
+}
+
+func TestResponseCacheInformational(t *testing.T) {
+	var calls atomic.Int32
+	cache := NewResponseCache(&CacheConfiguration{TTL: time.Minute})
+	server := httptest.NewServer(CacheHandler(cache, "tweets", nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Link", "</style.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Write([]byte("ok"))
+	})))
+	t.Cleanup(server.Close)
+
+	var hints atomic.Int32
+	trace := &httptrace.ClientTrace{Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+		if code == http.StatusEarlyHints && header.Get("Link") != "" {
+			hints.Add(1)
+		}
+		return nil
+	}}
+	for _, want := range []string{"MISS", "HIT"} {
+		req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, server.URL, nil)
+		resp, body := do(t, req)
+		if resp.StatusCode != http.StatusOK || body != "ok" || resp.Header.Get("X-Cache") != want {
+			t.Errorf("got %d %q with X-Cache %q, want 200 with %s", resp.StatusCode, body, resp.Header.Get("X-Cache"), want)
+		}
+	}
+	if calls.Load() != 1 || hints.Load() != 1 {
+		t.Errorf("got %d calls and %d early hints, want the early hints of the backend then the cached response", calls.Load(), hints.Load())
+	}
+}
+
+func TestCacheValidation(t *testing.T) {
+	err := validateTestConfig(t, `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    streaming: true
+    cache:
+      ttl: 0s
+      maxEntries: -1
+      maxEntryBytes: 2048
+      maxBytes: 1024
+`)
+	assertProblems(t, err, "cache.ttl must be positive, got 0s", "cache.maxEntries must be positive, got -1",
+		"cache.maxEntryBytes 2048 is larger than maxBytes 1024", "cache cannot be used with streaming or grpc routes")
+}
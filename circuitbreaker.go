@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCircuitBreakerFailureRatio = 0.5
+	defaultCircuitBreakerMinRequests  = 10
+	defaultCircuitBreakerWindow       = 10 * time.Second
+	defaultCircuitBreakerCooldown     = 30 * time.Second
+)
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+type CircuitBreakerConfiguration struct {
+	FailureRatio float64       `yaml:"failureRatio"`
+	MinRequests  int           `yaml:"minRequests"`
+	Window       time.Duration `yaml:"window"`
+	Cooldown     time.Duration `yaml:"cooldown"`
+}
+
+func validateCircuitBreaker(config *CircuitBreakerConfiguration) []string {
+	var problems []string
+	if config == nil {
+		return problems
+	}
+	if config.FailureRatio < 0 || config.FailureRatio > 1 {
+		problems = append(problems, fmt.Sprintf("circuitBreaker.failureRatio must be between 0 and 1, got %v", config.FailureRatio))
+	}
+	if config.MinRequests < 0 {
+		problems = append(problems, fmt.Sprintf("circuitBreaker.minRequests must be positive, got %d", config.MinRequests))
+	}
+	if config.Window < 0 {
+		problems = append(problems, fmt.Sprintf("circuitBreaker.window must be positive, got %v", config.Window))
+	}
+	if config.Cooldown < 0 {
+		problems = append(problems, fmt.Sprintf("circuitBreaker.cooldown must be positive, got %v", config.Cooldown))
+	}
+	return problems
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker stops sending requests to a backend whose failure ratio over the window
+// is too high. Once the cooldown is elapsed, a single probe request is let through:
+// the circuit closes again when it succeeds, and opens for another cooldown otherwise.
+type circuitBreaker struct {
+	label        string
+	backend      string
+	failureRatio float64
+	minRequests  int
+	window       time.Duration
+	cooldown     time.Duration
+
+	mu          sync.Mutex
+	state       circuitState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+}
+
+func newCircuitBreaker(label string, backend string, config CircuitBreakerConfiguration) *circuitBreaker {
+	return &circuitBreaker{
+		label:        label,
+		backend:      backend,
+		failureRatio: valueOrDefault(config.FailureRatio, defaultCircuitBreakerFailureRatio),
+		minRequests:  valueOrDefault(config.MinRequests, defaultCircuitBreakerMinRequests),
+		window:       valueOrDefault(config.Window, defaultCircuitBreakerWindow),
+		cooldown:     valueOrDefault(config.Cooldown, defaultCircuitBreakerCooldown),
+		windowStart:  time.Now(),
+	}
+}
+
+// ready tells whether a request could be sent, without reserving the half-open probe
+func (b *circuitBreaker) ready() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		return time.Since(b.openedAt) >= b.cooldown
+	case circuitHalfOpen:
+		return !b.probing
+	}
+	return true
+}
+
+// acquire reserves the right to send a request, it must be followed by a call to record
+func (b *circuitBreaker) acquire() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitOpen && time.Since(b.openedAt) >= b.cooldown {
+		b.state = circuitHalfOpen
+		b.probing = false
+	}
+	switch b.state {
+	case circuitOpen:
+		return false
+	case circuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+func (b *circuitBreaker) record(success bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitHalfOpen:
+		b.probing = false
+		if success {
+			b.state = circuitClosed
+			b.windowStart = time.Now()
+			b.requests, b.failures = 0, 0
+			b.logger().Info("Circuit breaker closed, backend recovered")
+		} else {
+			b.open()
+		}
+	case circuitClosed:
+		if time.Since(b.windowStart) >= b.window {
+			b.windowStart = time.Now()
+			b.requests, b.failures = 0, 0
+		}
+		b.requests++
+		if !success {
+			b.failures++
+		}
+		if b.requests >= b.minRequests && float64(b.failures)/float64(b.requests) >= b.failureRatio {
+			b.open()
+		}
+	}
+}
+
+// abort releases a request whose outcome says nothing about the backend
+func (b *circuitBreaker) abort() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *circuitBreaker) open() {
+	b.state = circuitOpen
+	b.openedAt = time.Now()
+	b.logger().Warnf("Circuit breaker opened for %v", b.cooldown)
+}
+
+func (b *circuitBreaker) logger() *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"label":   b.label,
+		"backend": b.backend,
+	})
+}
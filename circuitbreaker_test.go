@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	breaker := newCircuitBreaker("tweets", "http://localhost:8888", CircuitBreakerConfiguration{
+		FailureRatio: 0.5,
+		MinRequests:  4,
+		Window:       time.Minute,
+		Cooldown:     20 * time.Millisecond,
+	})
+	send := func(success bool) {
+		t.Helper()
+		if !breaker.acquire() {
+			t.Fatalf("a request was refused in the state %v", breaker.state)
+		}
+		breaker.record(success)
+	}
+
+	// Closed: the failures below the minimum of requests do not open it
+	send(false)
+	send(true)
+	send(false)
+	if breaker.state != circuitClosed {
+		t.Fatalf("state = %v after 3 requests, want closed", breaker.state)
+	}
+	send(false)
+	if breaker.state != circuitOpen {
+		t.Fatalf("state = %v with 3 failures out of 4, want open", breaker.state)
+	}
+
+	// Open: the requests are refused until the cooldown
+	if breaker.acquire() || breaker.ready() {
+		t.Fatal("the open circuit let a request through")
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// Half-open: a single probe, its failure opens the circuit again
+	if !breaker.acquire() {
+		t.Fatal("the probe was refused after the cooldown")
+	}
+	if breaker.state != circuitHalfOpen || breaker.acquire() {
+		t.Fatalf("state = %v, want half-open with a single probe", breaker.state)
+	}
+	breaker.record(false)
+	if breaker.state != circuitOpen {
+		t.Fatalf("state = %v after a failed probe, want open", breaker.state)
+	}
+
+	// A successful probe closes it
+	time.Sleep(30 * time.Millisecond)
+	send(true)
+	if breaker.state != circuitClosed {
+		t.Fatalf("state = %v after a successful probe, want closed", breaker.state)
+	}
+	send(false)
+	if breaker.state != circuitClosed {
+		t.Fatalf("state = %v, the counts of the previous window were kept", breaker.state)
+	}
+}
+
+func TestCircuitBreakerShortCircuits(t *testing.T) {
+	var calls atomic.Int32
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    circuitBreaker:
+      minRequests: 2
+      failureRatio: 0.5
+      cooldown: 1h
+`, backend.URL))
+
+	var statuses []int
+	for i := 0; i < 4; i++ {
+		resp, _ := get(t, gateway.URL+"/tweets", nil)
+		statuses = append(statuses, resp.StatusCode)
+	}
+	if want := []int{500, 500, 503, 503}; fmt.Sprint(statuses) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", statuses, want)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("the backend got %d requests, want 2 before the circuit opened", got)
+	}
+}
+
+func TestHealthCheckWithOpenCircuit(t *testing.T) {
+	backendUrl, healthy := healthBackend(t)
+	breaker := newCircuitBreaker("tweets", backendUrl.String(), CircuitBreakerConfiguration{Cooldown: time.Hour})
+	breaker.open()
+	u := &upstream{url: backendUrl, weight: 1, breaker: breaker}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The health checks track the backend state whatever the state of the circuit
+	healthy.Store(false)
+	StartHealthChecks(ctx, GatewayItem{HealthCheck: &HealthCheckConfiguration{Path: "/health", Interval: 10 * time.Millisecond, UnhealthyThreshold: 2, HealthyThreshold: 2}}, []*upstream{u}, http.DefaultTransport)
+	waitDown(t, u, true)
+	healthy.Store(true)
+	waitDown(t, u, false)
+	if u.available() {
+		t.Error("the upstream is available while its circuit is open")
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const defaultCompressionMinBytes = 1024
+
+// Content types already compressed, they are sent as is
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/zstd",
+}
+
+type CompressionConfiguration struct {
+	MinBytes int `yaml:"minBytes"`
+	Level    int `yaml:"level"`
+}
+
+func (config CompressionConfiguration) minBytes() int {
+	return valueOrDefault(config.MinBytes, defaultCompressionMinBytes)
+}
+
+func (config CompressionConfiguration) level() int {
+	if config.Level == 0 {
+		return gzip.DefaultCompression
+	}
+	return config.Level
+}
+
+func validateCompression(config *CompressionConfiguration) []string {
+	var problems []string
+	if config == nil {
+		return problems
+	}
+	if config.MinBytes < 0 {
+		problems = append(problems, fmt.Sprintf("compression.minBytes must be positive, got %d", config.MinBytes))
+	}
+	if config.Level < gzip.HuffmanOnly || config.Level > gzip.BestCompression {
+		problems = append(problems, fmt.Sprintf("compression.level must be between %d and %d, got %d", gzip.HuffmanOnly, gzip.BestCompression, config.Level))
+	}
+	return problems
+}
+
+// acceptsGzip tells whether the client accepts gzip encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "gzip" && name != "*" {
+				continue
+			}
+			// A zero quality refuses the coding
+			_, q, found := strings.Cut(params, "q=")
+			if weight, err := strconv.ParseFloat(strings.TrimSpace(q), 64); !found || err != nil || weight > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func compressible(header http.Header, status int) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusPartialContent || status == http.StatusNotModified {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" || strings.Contains(header.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType == "image/svg+xml" {
+		return true
+	}
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// gzipResponseWriter compresses the response once it reaches the minimum size.
+// Responses without Content-Length are buffered up to the minimum size to decide.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	config CompressionConfiguration
+
+	status  int
+	decided bool
+	buffer  []byte
+	gzip    *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	if status < http.StatusOK {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+
+	if !compressible(w.Header(), status) {
+		w.decide(false)
+		return
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if length, err := strconv.Atoi(w.Header().Get("Content-Length")); err == nil {
+		w.decide(length >= w.config.minBytes())
+	}
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.buffer = append(w.buffer, b...)
+		if len(w.buffer) >= w.config.minBytes() {
+			if err := w.decide(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if w.gzip != nil {
+		return w.gzip.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide writes the headers, compressed or not, and the buffered body
+func (w *gzipResponseWriter) decide(compress bool) error {
+	w.decided = true
+	if compress {
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Encoding", "gzip")
+		// The level is validated with the configuration
+		w.gzip, _ = gzip.NewWriterLevel(w.ResponseWriter, w.config.level())
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buffer := w.buffer
+	w.buffer = nil
+	if len(buffer) == 0 {
+		return nil
+	}
+	if w.gzip != nil {
+		_, err := w.gzip.Write(buffer)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buffer)
+	return err
+}
+
+// Flush sends the response received so far, a streamed response is compressed whatever its size
+func (w *gzipResponseWriter) Flush() {
+	if w.status == 0 {
+		return
+	}
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.gzip != nil {
+		w.gzip.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close writes the end of the response
+func (w *gzipResponseWriter) close() {
+	if w.status == 0 {
+		return
+	}
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.gzip != nil {
+		w.gzip.Close()
+	}
+}
+
+// CompressionHandler compresses the responses of the route with gzip, for the clients accepting it
+func CompressionHandler(config *CompressionConfiguration, next http.Handler) http.Handler {
+	if config == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || isWebSocketRequest(r) || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writer := &gzipResponseWriter{ResponseWriter: w, config: *config}
+		next.ServeHTTP(writer, r)
+		writer.close()
+	})
+}
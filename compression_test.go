@@ -0,0 +1,71 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	large := strings.Repeat("tweet ", 500)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "short")
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, large)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, large)
+		}
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/api/"
+    backend: "%s/"
+    compression:
+      minBytes: 1024
+`, backend.URL))
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		compressed     bool
+	}{
+		{name: "accepted and above the threshold", path: "/api/large", acceptEncoding: "gzip, deflate", compressed: true},
+		{name: "not accepted", path: "/api/large", acceptEncoding: "identity"},
+		{name: "refused with a zero quality", path: "/api/large", acceptEncoding: "gzip;q=0"},
+		{name: "below the threshold", path: "/api/small", acceptEncoding: "gzip"},
+		{name: "already compressed type", path: "/api/image", acceptEncoding: "gzip"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Setting Accept-Encoding keeps the client from decompressing the body itself
+			resp, body := get(t, gateway.URL+test.path, http.Header{"Accept-Encoding": {test.acceptEncoding}})
+			compressed := resp.Header.Get("Content-Encoding") == "gzip"
+			if compressed != test.compressed {
+				t.Fatalf("Content-Encoding = %q, want compressed %t", resp.Header.Get("Content-Encoding"), test.compressed)
+			}
+			if !compressed {
+				return
+			}
+			if !strings.Contains(resp.Header.Get("Vary"), "Accept-Encoding") {
+				t.Errorf("Vary = %q, want Accept-Encoding", resp.Header.Get("Vary"))
+			}
+			reader, err := gzip.NewReader(strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decompressed, err := io.ReadAll(reader)
+			if err != nil || string(decompressed) != large {
+				t.Errorf("got %d decompressed bytes, %v, want the %d bytes of the backend", len(decompressed), err, len(large))
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errConcurrencyLimit = errors.New("concurrency limit reached")
+
+// concurrencyLimiter bounds the number of requests of a route served at once.
+// A nil concurrencyLimiter accepts every request.
+type concurrencyLimiter struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+}
+
+func newConcurrencyLimiter(item GatewayItem) *concurrencyLimiter {
+	if item.MaxConcurrent <= 0 {
+		return nil
+	}
+	return &concurrencyLimiter{
+		slots:        make(chan struct{}, item.MaxConcurrent),
+		queueTimeout: item.QueueTimeout,
+	}
+}
+
+// acquire takes a slot, waiting up to the queue timeout when they are all taken.
+// The slot must be given back with release.
+func (l *concurrencyLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if l.queueTimeout <= 0 {
+		return errConcurrencyLimit
+	}
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errConcurrencyLimit
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *concurrencyLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+func validateConcurrency(item GatewayItem) []string {
+	var problems []string
+	if item.MaxConcurrent < 0 {
+		problems = append(problems, fmt.Sprintf("maxConcurrent must be positive, got %d", item.MaxConcurrent))
+	}
+	if item.QueueTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("queueTimeout must be positive, got %v", item.QueueTimeout))
+	} else if item.QueueTimeout > 0 && item.MaxConcurrent == 0 {
+		problems = append(problems, "queueTimeout requires maxConcurrent")
+	}
+	return problems
+}
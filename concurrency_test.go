@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// holdingBackend holds its /hold requests until release is closed
+func holdingBackend(t *testing.T) (string, <-chan struct{}, chan struct{}) {
+	t.Helper()
+	held := make(chan struct{}, 10)
+	release := make(chan struct{})
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/hold" {
+			held <- struct{}{}
+			select {
+			case <-release:
+			case <-time.After(5 * time.Second):
+			}
+		}
+		w.Write([]byte("ok"))
+	})
+	return backend.URL, held, release
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	tests := []struct {
+		name         string
+		queueTimeout string
+		releaseAfter time.Duration
+		status       int
+	}{
+		{name: "rejected without queue", releaseAfter: time.Second, status: http.StatusServiceUnavailable},
+		{name: "queued until a slot is free", queueTimeout: "2s", releaseAfter: 50 * time.Millisecond, status: http.StatusOK},
+		{name: "rejected once the queue timeout expires", queueTimeout: "50ms", releaseAfter: time.Second, status: http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend, held, release := holdingBackend(t)
+			config := fmt.Sprintf(`
+routes:
+  - frontend: "/api/"
+    backend: "%s/api/"
+    maxConcurrent: 2
+`, backend)
+			if test.queueTimeout != "" {
+				config += fmt.Sprintf("    queueTimeout: %s\n", test.queueTimeout)
+			}
+			gateway := newTestGateway(t, config)
+
+			done := make(chan struct{})
+			for i := 0; i < 2; i++ {
+				go func() {
+					if resp, err := http.Get(gateway.URL + "/api/hold"); err == nil {
+						resp.Body.Close()
+					}
+					done <- struct{}{}
+				}()
+				<-held
+			}
+			timer := time.AfterFunc(test.releaseAfter, func() { close(release) })
+			defer func() {
+				if timer.Stop() {
+					close(release)
+				}
+				<-done
+				<-done
+			}()
+
+			if resp, _ := get(t, gateway.URL+"/api/other", nil); resp.StatusCode != test.status {
+				t.Errorf("request over the limit: got %d, want %d", resp.StatusCode, test.status)
+			}
+		})
+	}
+}
+
+func TestConcurrencyValidation(t *testing.T) {
+	assertProblems(t, validateTestConfig(t, `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    queueTimeout: 1s
+  - frontend: "/users"
+    backend: "http://localhost:8888"
+    maxConcurrent: -1
+`), "routes[0] (/tweets): queueTimeout requires maxConcurrent", "maxConcurrent must be positive, got -1")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var configExtensions = []string{".yaml", ".yml", ".json", ".toml"}
+
+// decodeConfigDir merges the configuration files of the directory, in the order of their names.
+// The routes of every file are kept, the other parameters of a file replace those of the previous files.
+func decodeConfigDir(dir string, config *Configuration) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("readdir err: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && isConfigFile(entry.Name()) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("readdir err: no configuration file in %s", dir)
+	}
+	sort.Strings(files)
+
+	var problems []string
+	definedIn := make(map[string]string)
+	for _, file := range files {
+		routes := config.Routes
+		config.Routes = nil
+		if err := decodeConfigFile(file, config); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+
+		for _, item := range config.Routes {
+			if previous, ok := definedIn[item.Frontend]; ok {
+				problems = append(problems, fmt.Sprintf("frontend %q of %s is already defined in %s", item.Frontend, file, previous))
+				continue
+			}
+			definedIn[item.Frontend] = file
+		}
+		config.Routes = append(routes, config.Routes...)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("merge err: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+func isConfigFile(name string) bool {
+	for _, extension := range configExtensions {
+		if strings.EqualFold(filepath.Ext(name), extension) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfigDir writes the files in a new directory and returns its path
+func writeConfigDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestConfigDirMerge(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{
+		"00-global.yaml": `
+port: "9000"
+`,
+		"10-tweets.yaml": `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`,
+		"20-users.json": `{"routes": [{"frontend": "/users", "backend": "http://localhost:8889"}]}`,
+		"README.md":     "not a configuration",
+	})
+
+	config, err := loadConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Port != "9000" {
+		t.Errorf("port = %q, want the 9000 of 00-global.yaml", config.Port)
+	}
+	var frontends []string
+	for _, item := range config.Routes {
+		frontends = append(frontends, item.Frontend)
+	}
+	if strings.Join(frontends, ",") != "/tweets,/users" {
+		t.Errorf("got the routes %v, want those of both files", frontends)
+	}
+}
+
+func TestConfigDirConflictingFrontend(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{
+		"a.yaml": `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`,
+		"b.yaml": `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8889"
+`,
+	})
+
+	_, err := loadConfig(dir)
+	want := `frontend "/tweets" of ` + filepath.Join(dir, "b.yaml") + " is already defined in " + filepath.Join(dir, "a.yaml")
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("got the error %v, want %q", err, want)
+	}
+}
+
+func TestEmptyConfigDir(t *testing.T) {
+	if _, err := loadConfig(writeConfigDir(t, map[string]string{"notes.txt": ""})); err == nil || !strings.Contains(err.Error(), "no configuration file") {
+		t.Fatalf("got the error %v, want no configuration file", err)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultConfigEndpointPath = "/config"
+
+	redactedValue = "[redacted]"
+)
+
+type ConfigEndpointConfiguration struct {
+	Path      string                  `yaml:"path"`
+	BasicAuth *BasicAuthConfiguration `yaml:"basicAuth"`
+	APIKey    *APIKeyConfiguration    `yaml:"apiKey"`
+}
+
+// configEndpointPath returns the path of the configuration endpoint, or an empty string when it is disabled
+func (config Configuration) configEndpointPath() string {
+	if config.ConfigEndpoint == nil {
+		return ""
+	}
+	if config.ConfigEndpoint.Path == "" {
+		return defaultConfigEndpointPath
+	}
+	return config.ConfigEndpoint.Path
+}
+
+func validateConfigEndpoint(config Configuration) []string {
+	var problems []string
+	endpoint := config.ConfigEndpoint
+	if endpoint == nil {
+		return problems
+	}
+	path := config.configEndpointPath()
+	if !strings.HasPrefix(path, "/") {
+		problems = append(problems, fmt.Sprintf("configEndpoint.path %q must start with /", endpoint.Path))
+	}
+	if path == livenessPath || path == readinessPath || (config.metricsOnGateway() && path == config.metricsPath()) {
+		problems = append(problems, fmt.Sprintf("configEndpoint.path %q is reserved by the gateway", endpoint.Path))
+	}
+	// The configuration tells a lot about the backends, it is never served anonymously
+	if endpoint.BasicAuth == nil && endpoint.APIKey == nil {
+		problems = append(problems, "configEndpoint requires basicAuth or apiKey")
+	}
+	for _, problem := range append(validateBasicAuth(endpoint.BasicAuth), validateAPIKey(endpoint.APIKey)...) {
+		problems = append(problems, "configEndpoint: "+problem)
+	}
+	return problems
+}
+
+func redactBasicAuth(config *BasicAuthConfiguration) *BasicAuthConfiguration {
+	if config == nil {
+		return nil
+	}
+	redacted := *config
+	redacted.Users = make([]BasicAuthUser, len(config.Users))
+	for index, user := range config.Users {
+		redacted.Users[index] = BasicAuthUser{Username: user.Username, Password: redactedValue}
+	}
+	return &redacted
+}
+
+func redactAPIKey(config *APIKeyConfiguration) *APIKeyConfiguration {
+	if config == nil {
+		return nil
+	}
+	redacted := *config
+	redacted.Keys = make([]string, len(config.Keys))
+	for index := range config.Keys {
+		redacted.Keys[index] = redactedValue
+	}
+	return &redacted
+}
+
+// redactBackendURL hides the password of the backend URL, it is sent to the backend
+func redactBackendURL(backend string) string {
+	target, err := url.Parse(backend)
+	if err != nil || target.User == nil {
+		return backend
+	}
+	if _, ok := target.User.Password(); !ok {
+		return backend
+	}
+	target.User = url.UserPassword(target.User.Username(), redactedValue)
+	return target.String()
+}
+
+func redactRoute(item GatewayItem) GatewayItem {
+	item.Backend = redactBackendURL(item.Backend)
+	if len(item.Backends) > 0 {
+		backends := make([]Backend, len(item.Backends))
+		for index, backend := range item.Backends {
+			backend.URL = redactBackendURL(backend.URL)
+			backends[index] = backend
+		}
+		item.Backends = backends
+	}
+	item.BasicAuth = redactBasicAuth(item.BasicAuth)
+	item.APIKey = redactAPIKey(item.APIKey)
+	if item.RateLimitBypass != nil {
+		bypass := *item.RateLimitBypass
+		bypass.Keys = make([]string, len(item.RateLimitBypass.Keys))
+		for index := range item.RateLimitBypass.Keys {
+			bypass.Keys[index] = redactedValue
+		}
+		item.RateLimitBypass = &bypass
+	}
+	if item.VaryBy != nil && item.VaryBy.JWT != nil && item.VaryBy.JWT.Secret != "" {
+		varyBy, jwtConfig := *item.VaryBy, *item.VaryBy.JWT
+		jwtConfig.Secret = redactedValue
+		varyBy.JWT = &jwtConfig
+		item.VaryBy = &varyBy
+	}
+	return item
+}
+
+// redactConfig returns a copy of the configuration without the passwords and keys
+func redactConfig(config Configuration) Configuration {
+	if config.Store.Redis.Password != "" {
+		config.Store.Redis.Password = redactedValue
+	}
+
+	routes := make([]GatewayItem, len(config.Routes))
+	for index, item := range config.Routes {
+		routes[index] = redactRoute(item)
+	}
+	config.Routes = routes
+
+	if config.DefaultRoute != nil {
+		item := redactRoute(*config.DefaultRoute)
+		config.DefaultRoute = &item
+	}
+	if config.ConfigEndpoint != nil {
+		endpoint := *config.ConfigEndpoint
+		endpoint.BasicAuth = redactBasicAuth(endpoint.BasicAuth)
+		endpoint.APIKey = redactAPIKey(endpoint.APIKey)
+		config.ConfigEndpoint = &endpoint
+	}
+	if config.Stats != nil {
+		stats := *config.Stats
+		stats.BasicAuth = redactBasicAuth(stats.BasicAuth)
+		stats.APIKey = redactAPIKey(stats.APIKey)
+		config.Stats = &stats
+	}
+	if config.MaintenanceEndpoint != nil {
+		endpoint := *config.MaintenanceEndpoint
+		endpoint.BasicAuth = redactBasicAuth(endpoint.BasicAuth)
+		endpoint.APIKey = redactAPIKey(endpoint.APIKey)
+		config.MaintenanceEndpoint = &endpoint
+	}
+	return config
+}
+
+// marshalConfigJSON encodes the configuration as JSON, with the same parameter names as the configuration file
+func marshalConfigJSON(config Configuration) ([]byte, error) {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(document, "", "  ")
+}
+
+// ConfigEndpointHandler serves the running configuration as JSON, once the client is authenticated
+func ConfigEndpointHandler(config Configuration) http.Handler {
+	body, err := marshalConfigJSON(redactConfig(config))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			logrus.Errorf("Failed to encode the configuration: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+	return BasicAuthHandler(config.ConfigEndpoint.BasicAuth, APIKeyHandler(config.ConfigEndpoint.APIKey, handler))
+}
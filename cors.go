@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+// The rate limit headers are readable by browser clients
+var corsExposedHeaders = []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"}
+
+type CORSConfiguration struct {
+	AllowedOrigins   []string      `yaml:"allowedOrigins"`
+	AllowedMethods   []string      `yaml:"allowedMethods"`
+	AllowedHeaders   []string      `yaml:"allowedHeaders"`
+	AllowCredentials bool          `yaml:"allowCredentials"`
+	MaxAge           time.Duration `yaml:"maxAge"`
+}
+
+func (config CORSConfiguration) methods() []string {
+	if len(config.AllowedMethods) == 0 {
+		return defaultCORSMethods
+	}
+	return config.AllowedMethods
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if item == "*" || strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (config CORSConfiguration) allowsHeaders(requested string) bool {
+	for _, name := range strings.Split(requested, ",") {
+		if name = strings.TrimSpace(name); name != "" && !containsFold(config.AllowedHeaders, name) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateCORS(config *CORSConfiguration) []string {
+	var problems []string
+	if config == nil {
+		return problems
+	}
+	if len(config.AllowedOrigins) == 0 {
+		problems = append(problems, "cors.allowedOrigins is required")
+	}
+	if config.AllowCredentials && containsFold(config.AllowedOrigins, "*") {
+		problems = append(problems, "cors.allowCredentials cannot be used with the * origin")
+	}
+	if config.MaxAge < 0 {
+		problems = append(problems, fmt.Sprintf("cors.maxAge must be positive, got %v", config.MaxAge))
+	}
+	return problems
+}
+
+// CORSHandler answers preflight requests without calling the next handler,
+// and sets the Access-Control-* headers on the responses of allowed origins.
+func CORSHandler(config *CORSConfiguration, next http.Handler) http.Handler {
+	if config == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !containsFold(config.AllowedOrigins, origin) {
+			if preflight {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			// The browser blocks the response without the Access-Control-* headers
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if config.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if containsFold(config.AllowedOrigins, "*") && !config.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		method := r.Header.Get("Access-Control-Request-Method")
+		requestedHeaders := r.Header.Get("Access-Control-Request-Headers")
+		if !containsFold(config.methods(), method) || !config.allowsHeaders(requestedHeaders) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(config.methods(), ", "))
+		if requestedHeaders != "" {
+			w.Header().Set("Access-Control-Allow-Headers", requestedHeaders)
+		}
+		if config.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	config := &CORSConfiguration{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{http.MethodGet, http.MethodPut},
+		AllowedHeaders: []string{"Authorization"},
+		MaxAge:         10 * time.Minute,
+	}
+	tests := []struct {
+		name        string
+		method      string
+		header      http.Header
+		status      int
+		forwarded   bool
+		allowOrigin string
+		want        map[string]string
+	}{
+		{
+			name:   "preflight",
+			method: http.MethodOptions,
+			header: http.Header{
+				"Origin":                         {"https://app.example.com"},
+				"Access-Control-Request-Method":  {"PUT"},
+				"Access-Control-Request-Headers": {"authorization"},
+			},
+			status: http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Methods": "GET, PUT",
+				"Access-Control-Allow-Headers": "authorization",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:   "preflight of a blocked origin",
+			method: http.MethodOptions,
+			header: http.Header{
+				"Origin":                        {"https://evil.example.com"},
+				"Access-Control-Request-Method": {"GET"},
+			},
+			status: http.StatusForbidden,
+			want:   map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:   "preflight of a method not allowed",
+			method: http.MethodOptions,
+			header: http.Header{
+				"Origin":                        {"https://app.example.com"},
+				"Access-Control-Request-Method": {"DELETE"},
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name:      "request of an allowed origin",
+			method:    http.MethodGet,
+			header:    http.Header{"Origin": {"https://app.example.com"}},
+			status:    http.StatusOK,
+			forwarded: true,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":   "https://app.example.com",
+				"Access-Control-Expose-Headers": "X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After",
+			},
+		},
+		{
+			name:      "request of a blocked origin",
+			method:    http.MethodGet,
+			header:    http.Header{"Origin": {"https://evil.example.com"}},
+			status:    http.StatusOK,
+			forwarded: true,
+			want:      map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			forwarded := false
+			handler := CORSHandler(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = true
+			}))
+			req := httptest.NewRequest(test.method, "/tweets", nil)
+			req.Header = test.header
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != test.status {
+				t.Errorf("got %d, want %d", rec.Code, test.status)
+			}
+			if forwarded != test.forwarded {
+				t.Errorf("forwarded to the backend: %t, want %t", forwarded, test.forwarded)
+			}
+			for name, value := range test.want {
+				if got := rec.Header().Get(name); got != value {
+					t.Errorf("%s = %q, want %q", name, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateCORS(t *testing.T) {
+	assertProblems(t, validateTestConfig(t, `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    cors:
+      allowedOrigins: ["*"]
+      allowCredentials: true
+  - frontend: "/users"
+    backend: "http://localhost:8888"
+    cors:
+      allowedMethods: ["GET"]
+`), "cors.allowCredentials cannot be used with the * origin", "cors.allowedOrigins is required")
+}
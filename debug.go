@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const maxDebugBodyBytes = 64 << 10
+
+// bodyCapture keeps the beginning of a body as it is read or written
+type bodyCapture struct {
+	limit int
+	data  []byte
+	size  int64
+}
+
+func (c *bodyCapture) Write(b []byte) (int, error) {
+	n := len(b)
+	c.size += int64(n)
+	if room := c.limit - len(c.data); room > 0 {
+		if len(b) > room {
+			b = b[:room]
+		}
+		c.data = append(c.data, b...)
+	}
+	return n, nil
+}
+
+func (c *bodyCapture) String() string {
+	if c.size > int64(len(c.data)) {
+		return fmt.Sprintf("%s... (%d bytes)", c.data, c.size)
+	}
+	return string(c.data)
+}
+
+// capturingWriter keeps the beginning of the response body sent to the client
+type capturingWriter struct {
+	http.ResponseWriter
+	capture *bodyCapture
+}
+
+func (w *capturingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.capture.Write(b[:n])
+	return n, err
+}
+
+func (w *capturingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *capturingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func validateDebugBodyBytes(value int) []string {
+	var problems []string
+	if value < 0 || value > maxDebugBodyBytes {
+		problems = append(problems, fmt.Sprintf("debugBodyBytes must be between 0 and %d, got %d", maxDebugBodyBytes, value))
+	}
+	return problems
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestDebugBodiesForwardedIntact(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte("echo:"))
+		w.Write(body)
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    debugBodyBytes: 16
+`, backend.URL))
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(logrus.LevelHooks{})
+
+	payload := strings.Repeat("0123456789", 100)
+	req, err := http.NewRequest(http.MethodPost, gateway.URL+"/tweets", strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, body := do(t, req)
+	if body != "echo:"+payload {
+		t.Fatalf("got %d bytes, want the %d bytes echoed by the backend", len(body), len("echo:"+payload))
+	}
+
+	var entry *logrus.Entry
+	for _, logged := range hook.AllEntries() {
+		if logged.Message == "Request and response bodies" {
+			entry = logged
+		}
+	}
+	if entry == nil {
+		t.Fatal("the bodies were not logged")
+	}
+	if got := entry.Data["request-body"]; got != "0123456789012345... (1000 bytes)" {
+		t.Errorf("request-body = %q, want the first 16 bytes", got)
+	}
+	if got := entry.Data["response-body"]; got != "echo:01234567890... (1005 bytes)" {
+		t.Errorf("response-body = %q, want the first 16 bytes", got)
+	}
+}
+
+func TestDebugBodiesOffByDefault(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+`, okBackend(t)))
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(logrus.LevelHooks{})
+
+	get(t, gateway.URL+"/tweets", nil)
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Request and response bodies" {
+			t.Fatal("the bodies were logged without debugBodyBytes")
+		}
+	}
+}
+
+func TestBodyCaptureWrite(t *testing.T) {
+	capture := &bodyCapture{limit: 4}
+	if n, err := capture.Write([]byte("0123456789")); n != 10 || err != nil {
+		t.Errorf("Write = %d, %v, want the 10 bytes written", n, err)
+	}
+	if got := capture.String(); got != "0123... (10 bytes)" {
+		t.Errorf("String() = %q", got)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// drainState is shared by the successive handlers of the server, so that the readiness probe
+// fails as soon as the shutdown starts, whatever the configuration reloads
+type drainState struct {
+	draining atomic.Bool
+	// Requests being served by the gateway, probes included
+	inFlight atomic.Int64
+}
+
+// track counts the requests in flight
+func (d *drainState) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.inFlight.Add(1)
+		defer d.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Matches ${VAR} and ${VAR:-default}
+var envVariablePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces the environment variables referenced in the scalar values of the document.
+// A variable without default value must be set.
+func expandEnv(node *yaml.Node) error {
+	var missing []string
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "${") {
+			node.Value = envVariablePattern.ReplaceAllStringFunc(node.Value, func(reference string) string {
+				match := envVariablePattern.FindStringSubmatch(reference)
+				value, ok := os.LookupEnv(match[1])
+				if match[2] != "" && value == "" {
+					return match[3]
+				}
+				if !ok {
+					if node.Line > 0 {
+						missing = append(missing, fmt.Sprintf("line %d: environment variable %s is not set", node.Line, match[1]))
+					} else {
+						missing = append(missing, fmt.Sprintf("environment variable %s is not set", match[1]))
+					}
+				}
+				return value
+			})
+			// Unquoted values are resolved again, so that numbers and booleans can come from the environment
+			if node.Style == 0 {
+				node.Tag = ""
+			}
+		}
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	walk(node)
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("ICE_TEST_BACKEND", "http://users:9000")
+	t.Setenv("ICE_TEST_BURST", "7")
+	t.Setenv("ICE_TEST_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		backend string
+		burst   string
+		want    string
+		err     string
+	}{
+		{name: "expansion", backend: "${ICE_TEST_BACKEND}/users", want: "http://users:9000/users"},
+		{name: "default of an unset variable", backend: "${ICE_TEST_UNSET:-http://fallback:9000}", want: "http://fallback:9000"},
+		{name: "default of an empty variable", backend: "${ICE_TEST_EMPTY:-http://fallback:9000}", want: "http://fallback:9000"},
+		{name: "set variable over its default", backend: "${ICE_TEST_BACKEND:-http://fallback:9000}", want: "http://users:9000"},
+		{name: "missing variable", backend: "${ICE_TEST_UNSET}", err: "line 4: environment variable ICE_TEST_UNSET is not set"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := `
+routes:
+  - frontend: "/users"
+    backend: "` + test.backend + `"
+    burst: ${ICE_TEST_BURST}
+`
+			config, err := loadConfig(writeTestConfig(t, "config.yaml", content))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got the error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := config.Routes[0].Backend; got != test.want {
+				t.Errorf("backend = %q, want %q", got, test.want)
+			}
+			// Unquoted values keep their type
+			if got := config.Routes[0].burst(); got != 7 {
+				t.Errorf("burst = %d, want 7", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/kataras/requestid"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultErrorPageContentType = "application/json"
+
+// ErrorPageConfiguration is the template answered instead of the error responses of a route with its status
+type ErrorPageConfiguration struct {
+	Body        string `yaml:"body"`
+	ContentType string `yaml:"contentType"`
+}
+
+func (config ErrorPageConfiguration) contentType() string {
+	if config.ContentType == "" {
+		return defaultErrorPageContentType
+	}
+	return config.ContentType
+}
+
+type errorTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// template parses the body of the page. HTML pages escape the interpolated values, the other
+// pages can quote them with the json function.
+func (config ErrorPageConfiguration) template(status int) (errorTemplate, error) {
+	name := strconv.Itoa(status)
+	if strings.Contains(config.contentType(), "html") {
+		return htmltemplate.New(name).Parse(config.Body)
+	}
+	return texttemplate.New(name).Funcs(texttemplate.FuncMap{"json": jsonValue}).Parse(config.Body)
+}
+
+func jsonValue(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+// errorPageStatus parses the key of an error page, the keys are strings so that the JSON and TOML
+// configurations can use them
+func errorPageStatus(key string) (int, error) {
+	status, err := strconv.Atoi(key)
+	if err != nil || status < http.StatusBadRequest || status > 599 {
+		return 0, fmt.Errorf("errorPages status %q is not an error status, expected 400 to 599", key)
+	}
+	return status, nil
+}
+
+func validateErrorPages(pages map[string]ErrorPageConfiguration) []string {
+	var problems []string
+	keys := make([]string, 0, len(pages))
+	for key := range pages {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		page := pages[key]
+		status, err := errorPageStatus(key)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		if page.Body == "" {
+			problems = append(problems, fmt.Sprintf("errorPages.%s.body is required", key))
+		} else if _, err := page.template(status); err != nil {
+			problems = append(problems, fmt.Sprintf("errorPages.%s.body is not a valid template: %v", key, err))
+		}
+	}
+	return problems
+}
+
+// errorPageData holds the values the templates can interpolate
+type errorPageData struct {
+	Status     int
+	StatusText string
+	RequestID  string
+	Route      string
+	Label      string
+}
+
+type errorPage struct {
+	contentType string
+	template    errorTemplate
+}
+
+// errorPageWriter answers the error page of the status written by the route, the body of the route is discarded
+type errorPageWriter struct {
+	http.ResponseWriter
+	pages       map[int]*errorPage
+	request     *http.Request
+	item        GatewayItem
+	replaced    bool
+	wroteHeader bool
+}
+
+func (w *errorPageWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	page, ok := w.pages[status]
+	if status >= http.StatusOK {
+		w.wroteHeader = true
+	}
+	if !ok {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	var body bytes.Buffer
+	err := page.template.Execute(&body, errorPageData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		RequestID:  requestid.Get(w.request),
+		Route:      w.item.Frontend,
+		Label:      w.item.Label,
+	})
+	if err != nil {
+		logrus.WithField("requestid", requestid.Get(w.request)).Errorf("Failed to render the error page %d: %v", status, err)
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	w.replaced = true
+	header := w.ResponseWriter.Header()
+	for _, name := range []string{"Content-Encoding", "ETag", "Last-Modified", "Trailer"} {
+		header.Del(name)
+	}
+	header.Set("Content-Type", page.contentType)
+	header.Set("Content-Length", strconv.Itoa(body.Len()))
+	header.Set("X-Content-Type-Options", "nosniff")
+	w.ResponseWriter.WriteHeader(status)
+	if w.request.Method != http.MethodHead {
+		w.ResponseWriter.Write(body.Bytes())
+	}
+}
+
+func (w *errorPageWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorPageWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack lets websocket sessions take over the connection through the writer
+func (w *errorPageWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// ErrorPageHandler answers the configured error pages of the route, for the errors of the gateway
+// and of the backend alike
+func ErrorPageHandler(item GatewayItem, next http.Handler) (http.Handler, error) {
+	if len(item.ErrorPages) == 0 {
+		return next, nil
+	}
+	pages := make(map[int]*errorPage, len(item.ErrorPages))
+	for key, config := range item.ErrorPages {
+		status, err := errorPageStatus(key)
+		if err != nil {
+			return nil, err
+		}
+		template, err := config.template(status)
+		if err != nil {
+			return nil, fmt.Errorf("error page %d: %w", status, err)
+		}
+		pages[status] = &errorPage{contentType: config.contentType(), template: template}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&errorPageWriter{ResponseWriter: w, pages: pages, request: r, item: item}, r)
+	}), nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const errorPagesTestConfig = `
+routes:
+  - frontend: "/tweets"
+    backend: "%[1]s"
+    label: "tweets"
+    errorPages: &pages
+      429:
+        body: '{"status":{{.Status}},"text":{{json .StatusText}},"requestId":{{json .RequestID}},"route":{{json .Route}},"label":{{json .Label}}}'
+      502:
+        contentType: "text/html; charset=utf-8"
+        body: "<h1>{{.Status}} {{.StatusText}}</h1><p>Request {{.RequestID}}</p>"
+      503:
+        contentType: "text/plain"
+        body: "{{.Label}} is unavailable"
+  - frontend: "/limited"
+    backend: "%[1]s"
+    label: "limited"
+    reqsPerSec: 1
+    burst: 0
+    errorPages: *pages
+`
+
+func TestErrorPages(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("status") {
+		case "503":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("backend body"))
+		case "500":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("backend error"))
+		default:
+			w.Write([]byte("ok"))
+		}
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(errorPagesTestConfig, backend.URL))
+
+	t.Run("gateway error", func(t *testing.T) {
+		get(t, gateway.URL+"/limited", nil)
+		resp, body := get(t, gateway.URL+"/limited", http.Header{"X-Request-Id": {"abc"}})
+		if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Content-Type") != defaultErrorPageContentType {
+			t.Fatalf("got %d %q, want 429 with the JSON page", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		var page map[string]interface{}
+		if err := json.Unmarshal([]byte(body), &page); err != nil {
+			t.Fatalf("the page %q is not JSON: %v", body, err)
+		}
+		want := map[string]interface{}{"status": 429.0, "text": "Too Many Requests", "requestId": "abc", "route": "/limited", "label": "limited"}
+		if fmt.Sprint(page) != fmt.Sprint(want) {
+			t.Errorf("got %v, want %v", page, want)
+		}
+		if resp.Header.Get("X-Content-Type-Options") != "nosniff" || resp.Header.Get("Retry-After") == "" {
+			t.Errorf("got headers %v, want nosniff and the Retry-After of the limiter kept", resp.Header)
+		}
+	})
+
+	t.Run("backend error", func(t *testing.T) {
+		resp, body := get(t, gateway.URL+"/tweets?status=503", nil)
+		if resp.StatusCode != http.StatusServiceUnavailable || body != "tweets is unavailable" {
+			t.Errorf("got %d %q, want the page instead of the backend body", resp.StatusCode, body)
+		}
+		if resp.Header.Get("Content-Type") != "text/plain" || resp.Uncompressed || resp.Header.Get("Content-Encoding") != "" {
+			t.Errorf("got headers %v, want the content type of the page without the backend encoding", resp.Header)
+		}
+	})
+
+	t.Run("status without page", func(t *testing.T) {
+		resp, body := get(t, gateway.URL+"/tweets?status=500", nil)
+		if resp.StatusCode != http.StatusInternalServerError || body != "backend error" {
+			t.Errorf("got %d %q, want the backend response", resp.StatusCode, body)
+		}
+	})
+}
+
+func TestErrorPageEscapesHTML(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(errorPagesTestConfig, closedAddress(t)))
+
+	resp, body := get(t, gateway.URL+"/tweets", http.Header{"X-Request-Id": {"<script>alert(1)</script>"}})
+	if resp.StatusCode != http.StatusBadGateway || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("got %d %q, want 502 with the HTML page", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if want := "<h1>502 Bad Gateway</h1><p>Request &lt;script&gt;alert(1)&lt;/script&gt;</p>"; body != want {
+		t.Errorf("got %q, want %q", body, want)
+	}
+}
+
+func TestErrorPageHead(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(errorPagesTestConfig, closedAddress(t)))
+
+	req, _ := http.NewRequest(http.MethodHead, gateway.URL+"/tweets", nil)
+	resp, body := do(t, req)
+	if resp.StatusCode != http.StatusBadGateway || body != "" {
+		t.Errorf("got %d %q, want 502 without a body", resp.StatusCode, body)
+	}
+}
+
+func TestErrorPagesConfigFormats(t *testing.T) {
+	for name, content := range map[string]string{
+		"config.json": `{"routes": [{"frontend": "/tweets", "backend": "http://localhost:8888", "errorPages": {"502": {"body": "down"}}}]}`,
+		"config.toml": `
+[[routes]]
+frontend = "/tweets"
+backend = "http://localhost:8888"
+
+[routes.errorPages.502]
+body = "down"
+`,
+	} {
+		config, err := loadConfig(writeTestConfig(t, name, content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if page := config.Routes[0].ErrorPages["502"]; page.Body != "down" {
+			t.Errorf("%s: got the error pages %v", name, config.Routes[0].ErrorPages)
+		}
+	}
+}
+
+func TestErrorPagesValidation(t *testing.T) {
+	err := validateTestConfig(t, `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    errorPages:
+      200:
+        body: "ok"
+      abc:
+        body: "ok"
+      502:
+        contentType: "text/plain"
+      503:
+        body: "{{.Status"
+`)
+	assertProblems(t, err,
+		`errorPages status "200" is not an error status, expected 400 to 599`,
+		`errorPages status "abc" is not an error status, expected 400 to 599`,
+		"errorPages.502.body is required",
+		"errorPages.503.body is not a valid template")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Weight of the last latency in the moving average, the previous latencies fade out in a few tens of requests
+const ewmaWeight = 0.1
+
+// Added to the latency of a failed attempt to the backend
+const failedAttemptLatencyPenalty = time.Second
+
+// ewma is the exponentially weighted moving average of the latencies of an upstream, in seconds.
+// The first latency observed initializes the average.
+type ewma struct {
+	bits atomic.Uint64
+}
+
+func (e *ewma) observe(latency time.Duration) float64 {
+	sample := latency.Seconds()
+	for {
+		old := e.bits.Load()
+		value := sample
+		if old != 0 {
+			value = ewmaWeight*sample + (1-ewmaWeight)*math.Float64frombits(old)
+		}
+		if e.bits.CompareAndSwap(old, math.Float64bits(value)) {
+			return value
+		}
+	}
+}
+
+// value returns the average, 0 until a latency is observed
+func (e *ewma) value() float64 {
+	return math.Float64frombits(e.bits.Load())
+}
+
+// observeLatency records the time the upstream took to answer the response headers
+func (u *upstream) observeLatency(latency time.Duration) {
+	value := u.latency.observe(latency)
+	if u.latencyGauge != nil {
+		u.latencyGauge.Set(value)
+	}
+}
+
+// backendLatency returns the gauge of the latency average of a backend, or nil without metrics
+func (m *RouteMetrics) backendLatency(backend string) prometheus.Gauge {
+	if m == nil {
+		return nil
+	}
+	return m.backendsLatency.WithLabelValues(backend)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEWMAConverges(t *testing.T) {
+	var average ewma
+	if got := average.value(); got != 0 {
+		t.Fatalf("value before any latency = %v, want 0", got)
+	}
+	if got := average.observe(100 * time.Millisecond); got != 0.1 {
+		t.Fatalf("the first latency gave %v, want it to initialize the average to 0.1", got)
+	}
+	if got := average.observe(200 * time.Millisecond); math.Abs(got-0.11) > 1e-9 {
+		t.Errorf("second latency gave %v, want 0.11", got)
+	}
+
+	for i := 0; i < 100; i++ {
+		average.observe(20 * time.Millisecond)
+	}
+	if got := average.value(); math.Abs(got-0.02) > 0.001 {
+		t.Errorf("average after 100 latencies of 20ms = %v, want about 0.02", got)
+	}
+}
+
+func TestBackendLatencyGauge(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("ok"))
+	})
+	gateway, registry := startTestGateway(t, loadTestConfig(t, fmt.Sprintf(`
+metrics: true
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+`, backend.URL)))
+
+	for i := 0; i < 3; i++ {
+		get(t, gateway.URL+"/tweets", nil)
+	}
+	got := metricValue(t, registry, "tweets_backend_latency_ewma_seconds", map[string]string{"backend": backend.URL})
+	if got < 0.05 || got > 1 {
+		t.Errorf("latency gauge = %v, want about 0.05", got)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// parseDocument parses the configuration file according to its extension, YAML by default.
+// JSON documents are valid YAML, TOML documents are converted to a YAML document
+// so that the environment variables are expanded the same way whatever the format.
+func parseDocument(path string, data []byte) (*yaml.Node, error) {
+	var document yaml.Node
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		var values map[string]interface{}
+		if err := toml.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+		if err := document.Encode(values); err != nil {
+			return nil, err
+		}
+	default:
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, err
+		}
+	}
+	return &document, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfigFormats(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+port: "8000"
+metrics: true
+timeouts:
+  read: 30s
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888/tweets"
+    label: "tweets"
+    reqsPerSec: 10
+    burst: 20
+    methods: ["GET", "POST"]
+    responseHeaders:
+      X-Gateway: "ice-flow"
+`,
+		"config.json": `{
+  "port": "8000",
+  "metrics": true,
+  "timeouts": {"read": "30s"},
+  "routes": [
+    {
+      "frontend": "/tweets",
+      "backend": "http://localhost:8888/tweets",
+      "label": "tweets",
+      "reqsPerSec": 10,
+      "burst": 20,
+      "methods": ["GET", "POST"],
+      "responseHeaders": {"X-Gateway": "ice-flow"}
+    }
+  ]
+}`,
+		"config.toml": `
+port = "8000"
+metrics = true
+
+[timeouts]
+read = "30s"
+
+[[routes]]
+frontend = "/tweets"
+backend = "http://localhost:8888/tweets"
+label = "tweets"
+reqsPerSec = 10
+burst = 20
+methods = ["GET", "POST"]
+
+[routes.responseHeaders]
+X-Gateway = "ice-flow"
+`,
+	}
+
+	configs := map[string]Configuration{}
+	for name, content := range files {
+		config, err := loadConfig(writeTestConfig(t, name, content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		configs[name] = config
+	}
+	want := configs["config.yaml"]
+	if want.Timeouts.Read.String() != "30s" || want.Routes[0].burst() != 20 {
+		t.Fatalf("the YAML configuration was not parsed: %+v", want)
+	}
+	for _, name := range []string{"config.json", "config.toml"} {
+		if !reflect.DeepEqual(configs[name], want) {
+			t.Errorf("%s differs from the YAML configuration:\n%+v\n%+v", name, configs[name], want)
+		}
+	}
+}
+
+func TestConfigFormatErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"config.json": `{"port": "8000",`,
+		"config.toml": `port = `,
+		"config.yml":  "routes: [",
+	} {
+		if _, err := loadConfig(writeTestConfig(t, name, content)); err == nil {
+			t.Errorf("%s: the malformed configuration was loaded", name)
+		}
+	}
+}
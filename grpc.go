@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	httpProtocol = "http"
+	grpcProtocol = "grpc"
+)
+
+func validateProtocol(item GatewayItem) []string {
+	var problems []string
+	switch item.Protocol {
+	case "", httpProtocol:
+	case grpcProtocol:
+		if item.WebSocket {
+			problems = append(problems, "websocket cannot be used with the grpc protocol")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown protocol %q, expected %q or %q", item.Protocol, httpProtocol, grpcProtocol))
+	}
+	return problems
+}
+
+// grpcEnabled tells whether a route proxies gRPC, the gateway then accepts HTTP/2 over cleartext connections
+func (config Configuration) grpcEnabled() bool {
+	for _, item := range config.routes() {
+		if item.Protocol == grpcProtocol {
+			return true
+		}
+	}
+	return false
+}
+
+// withH2C serves the HTTP/2 cleartext connections of gRPC clients
+func withH2C(config Configuration, handler http.Handler) http.Handler {
+	if !config.grpcEnabled() {
+		return handler
+	}
+	return h2c.NewHandler(handler, &http2.Server{})
+}
+
+// grpcTransport sends the requests to http backends over HTTP/2 cleartext.
+// https backends go through the base transport, which negotiates HTTP/2 with TLS.
+type grpcTransport struct {
+	h2c  *http2.Transport
+	base http.RoundTripper
+}
+
+func newGRPCTransport(base http.RoundTripper) *grpcTransport {
+	dial := (&net.Dialer{}).DialContext
+	if transport, ok := base.(*http.Transport); ok && transport.DialContext != nil {
+		dial = transport.DialContext
+	}
+
+	return &grpcTransport{
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		},
+		base: base,
+	}
+}
+
+func (t *grpcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return t.h2c.RoundTrip(req)
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// grpcBackend serves the gRPC health service over HTTP/2 cleartext
+func grpcBackend(t *testing.T) (string, *health.Server) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := health.NewServer()
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, service)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String(), service
+}
+
+func TestGRPCProxy(t *testing.T) {
+	backend, service := grpcBackend(t)
+	service.SetServingStatus("tweets", healthpb.HealthCheckResponse_SERVING)
+	port := freePort(t)
+	config := loadTestConfig(t, fmt.Sprintf(`
+port: "%s"
+routes:
+  - frontend: "/grpc.health.v1.Health/"
+    backend: "http://%s/grpc.health.v1.Health/"
+    protocol: "grpc"
+`, port, backend))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, done := runTestServer(t, config, ctx)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	conn, err := grpc.Dial("127.0.0.1:"+port, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	callCtx, callCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer callCancel()
+
+	resp, err := client.Check(callCtx, &healthpb.HealthCheckRequest{Service: "tweets"})
+	if err != nil {
+		t.Fatalf("Check through the gateway: %v", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("got the status %v, want SERVING", resp.Status)
+	}
+
+	// The status of the gRPC errors is carried by the trailers
+	_, err = client.Check(callCtx, &healthpb.HealthCheckRequest{Service: "unknown"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("got the error %v, want NotFound", err)
+	}
+
+	// The server stream is flushed as the backend sends the messages
+	stream, err := client.Watch(callCtx, &healthpb.HealthCheckRequest{Service: "tweets"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update, err := stream.Recv(); err != nil || update.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("first update: got %v, %v", update, err)
+	}
+	service.SetServingStatus("tweets", healthpb.HealthCheckResponse_NOT_SERVING)
+	if update, err := stream.Recv(); err != nil || update.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("second update: got %v, %v", update, err)
+	}
+}
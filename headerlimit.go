@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+func validateHeaderLimits(config Configuration) []string {
+	var problems []string
+	if config.MaxHeaderBytes < 0 {
+		problems = append(problems, fmt.Sprintf("maxHeaderBytes must be positive, got %d", config.MaxHeaderBytes))
+	}
+	if config.MaxHeaderCount < 0 {
+		problems = append(problems, fmt.Sprintf("maxHeaderCount must be positive, got %d", config.MaxHeaderCount))
+	}
+	return problems
+}
+
+// HeaderLimitHandler rejects the requests carrying more header fields than the limit
+func HeaderLimitHandler(maxHeaderCount int, next http.Handler) http.Handler {
+	if maxHeaderCount <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := 0
+		for _, values := range r.Header {
+			count += len(values)
+		}
+		if count > maxHeaderCount {
+			http.Error(w, http.StatusText(http.StatusRequestHeaderFieldsTooLarge), http.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
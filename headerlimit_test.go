@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMaxHeaderBytes(t *testing.T) {
+	port := freePort(t)
+	config := loadTestConfig(t, fmt.Sprintf(`
+port: "%s"
+maxHeaderBytes: 1024
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+`, port, okBackend(t)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runTestServer(t, config, ctx)
+	url := fmt.Sprintf("http://127.0.0.1:%s/tweets", port)
+
+	if resp, _ := get(t, url, http.Header{"X-Small": {strings.Repeat("a", 512)}}); resp.StatusCode != http.StatusOK {
+		t.Errorf("got %d for headers under the limit, want 200", resp.StatusCode)
+	}
+	// The server accepts a few kilobytes over the limit before rejecting the headers
+	if resp, _ := get(t, url, http.Header{"X-Large": {strings.Repeat("a", 16<<10)}}); resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("got %d for oversized headers, want 431", resp.StatusCode)
+	}
+}
+
+func TestMaxHeaderCount(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(`
+maxHeaderCount: 10
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+`, okBackend(t)))
+
+	tests := []struct {
+		name    string
+		headers int
+		status  int
+	}{
+		{"under the limit", 3, http.StatusOK},
+		{"over the limit", 20, http.StatusRequestHeaderFieldsTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			for i := 0; i < test.headers; i++ {
+				header.Add(fmt.Sprintf("X-Header-%d", i), "value")
+			}
+			if resp, _ := get(t, gateway.URL+"/tweets", header); resp.StatusCode != test.status {
+				t.Errorf("got %d, want %d", resp.StatusCode, test.status)
+			}
+		})
+	}
+}
+
+func TestHeaderLimitsValidation(t *testing.T) {
+	err := validateTestConfig(t, `
+maxHeaderBytes: -1
+maxHeaderCount: -5
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`)
+	assertProblems(t, err, "maxHeaderBytes must be positive, got -1", "maxHeaderCount must be positive, got -5")
+}
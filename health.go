@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	livenessPath  = "/healthz"
+	readinessPath = "/readyz"
+
+	defaultHealthTimeout = 2 * time.Second
+)
+
+type HealthConfiguration struct {
+	CheckBackends bool          `yaml:"checkBackends"`
+	Timeout       time.Duration `yaml:"timeout"`
+}
+
+// backendAddress returns the host:port to dial for a backend URL
+func backendAddress(backend string) (string, error) {
+	backendUrl, err := url.Parse(backend)
+	if err != nil {
+		return "", err
+	}
+	if backendUrl.Port() != "" {
+		return backendUrl.Host, nil
+	}
+	if backendUrl.Scheme == "https" {
+		return net.JoinHostPort(backendUrl.Hostname(), "443"), nil
+	}
+	return net.JoinHostPort(backendUrl.Hostname(), "80"), nil
+}
+
+// checkBackends dials every distinct backend concurrently and returns the unreachable ones
+func checkBackends(backends []string, timeout time.Duration) map[string]error {
+	addresses := make(map[string]bool)
+	failures := make(map[string]error)
+	for _, backend := range backends {
+		address, err := backendAddress(backend)
+		if err != nil {
+			failures[backend] = err
+			continue
+		}
+		addresses[address] = true
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for address := range addresses {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			conn, err := net.DialTimeout("tcp", address, timeout)
+			if err != nil {
+				mutex.Lock()
+				failures[address] = err
+				mutex.Unlock()
+				return
+			}
+			conn.Close()
+		}(address)
+	}
+	wg.Wait()
+
+	return failures
+}
+
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+func ReadinessHandler(config HealthConfiguration, items []GatewayItem, drain *drainState) http.Handler {
+	var backends []string
+	for _, i := range items {
+		backends = append(backends, i.backendURLs()...)
+	}
+	timeout := valueOrDefault(config.Timeout, defaultHealthTimeout)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if drain.draining.Load() {
+			// The probe itself is not reported
+			http.Error(w, fmt.Sprintf("draining, %d requests in flight", drain.inFlight.Load()-1), http.StatusServiceUnavailable)
+			return
+		}
+		if !config.CheckBackends {
+			fmt.Fprintln(w, "ok")
+			return
+		}
+
+		failures := checkBackends(backends, timeout)
+		if len(failures) == 0 {
+			fmt.Fprintln(w, "ok")
+			return
+		}
+
+		var lines []string
+		for backend, err := range failures {
+			lines = append(lines, fmt.Sprintf("%s: %v", backend, err))
+		}
+		sort.Strings(lines)
+		http.Error(w, "unreachable backends:\n"+strings.Join(lines, "\n"), http.StatusServiceUnavailable)
+	})
+}
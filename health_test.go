@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLiveness(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+`, closedAddress(t)))
+
+	resp, body := get(t, gateway.URL+livenessPath, nil)
+	if resp.StatusCode != http.StatusOK || body != "ok\n" {
+		t.Errorf("liveness: got %d %q, want 200 ok", resp.StatusCode, body)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	down := closedAddress(t)
+	tests := []struct {
+		name          string
+		checkBackends bool
+		backend       string
+		status        int
+		body          string
+	}{
+		{name: "without check", backend: down, status: http.StatusOK, body: "ok"},
+		{name: "backend up", checkBackends: true, backend: okBackend(t), status: http.StatusOK, body: "ok"},
+		{name: "backend down", checkBackends: true, backend: down, status: http.StatusServiceUnavailable, body: "unreachable backends:\n" + strings.TrimPrefix(down, "http://") + ":"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gateway := newTestGateway(t, fmt.Sprintf(`
+health:
+  checkBackends: %t
+  timeout: 500ms
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+`, test.checkBackends, test.backend))
+
+			resp, body := get(t, gateway.URL+readinessPath, nil)
+			if resp.StatusCode != test.status || !strings.HasPrefix(body, test.body) {
+				t.Errorf("readiness: got %d %q, want %d %q", resp.StatusCode, body, test.status, test.body)
+			}
+		})
+	}
+}
+
+func TestReadinessWhileDraining(t *testing.T) {
+	drain := &drainState{}
+	drain.draining.Store(true)
+	drain.inFlight.Store(1)
+	rec := serve(ReadinessHandler(HealthConfiguration{}, nil, drain), http.MethodGet, readinessPath, "")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("readiness while draining: got %d, want 503", rec.Code)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHealthCheckInterval           = 10 * time.Second
+	defaultHealthCheckTimeout            = 2 * time.Second
+	defaultHealthCheckUnhealthyThreshold = 3
+	defaultHealthCheckHealthyThreshold   = 2
+)
+
+type HealthCheckConfiguration struct {
+	Path               string        `yaml:"path"`
+	Interval           time.Duration `yaml:"interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+	UnhealthyThreshold int           `yaml:"unhealthyThreshold"`
+	HealthyThreshold   int           `yaml:"healthyThreshold"`
+}
+
+func validateHealthCheck(config *HealthCheckConfiguration) []string {
+	var problems []string
+	if config == nil {
+		return problems
+	}
+	if !strings.HasPrefix(config.Path, "/") {
+		problems = append(problems, fmt.Sprintf("healthCheck.path %q must start with /", config.Path))
+	}
+	if config.Interval < 0 {
+		problems = append(problems, fmt.Sprintf("healthCheck.interval must be positive, got %v", config.Interval))
+	}
+	if config.Timeout < 0 {
+		problems = append(problems, fmt.Sprintf("healthCheck.timeout must be positive, got %v", config.Timeout))
+	}
+	if config.UnhealthyThreshold < 0 {
+		problems = append(problems, fmt.Sprintf("healthCheck.unhealthyThreshold must be positive, got %d", config.UnhealthyThreshold))
+	}
+	if config.HealthyThreshold < 0 {
+		problems = append(problems, fmt.Sprintf("healthCheck.healthyThreshold must be positive, got %d", config.HealthyThreshold))
+	}
+	return problems
+}
+
+// StartHealthChecks probes every upstream of the route until the context is canceled.
+// An upstream is taken out of rotation after unhealthyThreshold consecutive failures,
+// and put back after healthyThreshold consecutive successes.
+func StartHealthChecks(ctx context.Context, item GatewayItem, upstreams []*upstream, transport http.RoundTripper) {
+	if item.HealthCheck == nil {
+		return
+	}
+	for _, u := range upstreams {
+		go checkUpstream(ctx, item.Label, item.HostHeader, *item.HealthCheck, u, transport)
+	}
+}
+
+func checkUpstream(ctx context.Context, label string, host string, config HealthCheckConfiguration, u *upstream, transport http.RoundTripper) {
+	interval := valueOrDefault(config.Interval, defaultHealthCheckInterval)
+	timeout := valueOrDefault(config.Timeout, defaultHealthCheckTimeout)
+	unhealthyThreshold := valueOrDefault(config.UnhealthyThreshold, defaultHealthCheckUnhealthyThreshold)
+	healthyThreshold := valueOrDefault(config.HealthyThreshold, defaultHealthCheckHealthyThreshold)
+
+	probeUrl := *u.url
+	probeUrl.Path = config.Path
+	probeUrl.RawQuery = ""
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	successes, failures := 0, 0
+	for {
+		err := probeUpstream(ctx, transport, probeUrl.String(), host, timeout)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			successes = 0
+			failures++
+			if failures == unhealthyThreshold && !u.down.Load() {
+				u.down.Store(true)
+				logrus.WithFields(logrus.Fields{
+					"label":   label,
+					"backend": u.url.String(),
+				}).Warnf("Backend marked down after %d failed health checks: %v", failures, err)
+			}
+		} else {
+			failures = 0
+			successes++
+			if successes == healthyThreshold && u.down.Load() {
+				u.down.Store(false)
+				logrus.WithFields(logrus.Fields{
+					"label":   label,
+					"backend": u.url.String(),
+				}).Infof("Backend marked up after %d successful health checks", successes)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeUpstream succeeds when the health path answers with a 2xx or 3xx status
+func probeUpstream(ctx context.Context, transport http.RoundTripper, probeUrl string, host string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeUrl, nil)
+	if err != nil {
+		return err
+	}
+	req.Host = host
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("health check answered %d", resp.StatusCode)
+	}
+	return nil
+}
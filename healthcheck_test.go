@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// healthBackend answers its health path with 200 while healthy and 503 otherwise
+func healthBackend(t *testing.T) (*url.URL, *atomic.Bool) {
+	t.Helper()
+	var healthy atomic.Bool
+	healthy.Store(true)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" || !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	backendUrl, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backendUrl, &healthy
+}
+
+// waitDown waits until the upstream is marked down, or up
+func waitDown(t *testing.T, u *upstream, down bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for u.down.Load() != down {
+		if time.Now().After(deadline) {
+			t.Fatalf("the upstream down state is still %t", u.down.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHealthCheckDownAndRecovery(t *testing.T) {
+	backendUrl, healthy := healthBackend(t)
+	u := &upstream{url: backendUrl, weight: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	item := GatewayItem{Label: "tweets", HealthCheck: &HealthCheckConfiguration{
+		Path:               "/health",
+		Interval:           10 * time.Millisecond,
+		UnhealthyThreshold: 2,
+		HealthyThreshold:   2,
+	}}
+	StartHealthChecks(ctx, item, []*upstream{u}, http.DefaultTransport)
+
+	time.Sleep(50 * time.Millisecond)
+	if u.down.Load() {
+		t.Fatal("the healthy upstream was marked down")
+	}
+
+	healthy.Store(false)
+	waitDown(t, u, true)
+	if u.available() {
+		t.Error("the down upstream is still available to the balancer")
+	}
+
+	healthy.Store(true)
+	waitDown(t, u, false)
+	if !u.available() {
+		t.Error("the recovered upstream is not available to the balancer")
+	}
+}
+
+func TestHealthCheckSkipsDownBackend(t *testing.T) {
+	backendUrl, healthy := healthBackend(t)
+	otherUrl, _ := healthBackend(t)
+	down, up := &upstream{url: backendUrl, weight: 1}, &upstream{url: otherUrl, weight: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	healthy.Store(false)
+	StartHealthChecks(ctx, GatewayItem{HealthCheck: &HealthCheckConfiguration{Path: "/health", Interval: 10 * time.Millisecond, UnhealthyThreshold: 1}}, []*upstream{down, up}, http.DefaultTransport)
+	waitDown(t, down, true)
+
+	balancer := &roundRobinBalancer{upstreams: []*upstream{down, up}}
+	for i := 0; i < 4; i++ {
+		if balancer.Next(nil) != up {
+			t.Fatalf("request %d was not sent to the healthy upstream", i)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/kataras/requestid"
+	"github.com/sirupsen/logrus"
+)
+
+// parseNetworks parses a list of CIDR networks, a single IP is a network of one address
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not a valid IP or CIDR", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid IP or CIDR", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateIPFilter(item GatewayItem) []string {
+	var problems []string
+	if _, err := parseNetworks(item.AllowIPs); err != nil {
+		problems = append(problems, fmt.Sprintf("allowIPs: %v", err))
+	}
+	if _, err := parseNetworks(item.DenyIPs); err != nil {
+		problems = append(problems, fmt.Sprintf("denyIPs: %v", err))
+	}
+	return problems
+}
+
+// IPFilterHandler rejects the clients outside the allowed networks of the route, or inside its denied networks.
+// A client in both lists is rejected.
+func IPFilterHandler(item GatewayItem, next http.Handler) http.Handler {
+	if len(item.AllowIPs) == 0 && len(item.DenyIPs) == 0 {
+		return next
+	}
+	allow, _ := parseNetworks(item.AllowIPs)
+	deny, _ := parseNetworks(item.DenyIPs)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(clientIP(r))
+		if ip == nil || containsIP(deny, ip) || (len(allow) > 0 && !containsIP(allow, ip)) {
+			logrus.WithFields(logrus.Fields{
+				"label":     item.Label,
+				"method":    r.Method,
+				"uri":       r.RequestURI,
+				"requestid": requestid.Get(r),
+				"ip":        clientIP(r),
+			}).Errorf("Unauthorized IP %v", clientIP(r))
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type clientIPKey struct{}
+
+// forwardedClientIP returns the rightmost address of the X-Forwarded-For chain that is not a trusted proxy.
+// The chain is only read when the peer itself is a trusted proxy.
+func forwardedClientIP(r *http.Request, trusted []*net.IPNet) string {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	if ip := net.ParseIP(client); ip == nil || !containsIP(trusted, ip) {
+		return client
+	}
+
+	var chain []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		chain = append(chain, strings.Split(value, ",")...)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(chain[i]))
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !containsIP(trusted, ip) {
+			break
+		}
+	}
+	return client
+}
+
+// TrustedProxiesHandler keeps the client IP found behind the trusted proxies for the logs, the IP filters and the rate limiter
+func TrustedProxiesHandler(trustedProxies []string, next http.Handler) http.Handler {
+	if len(trustedProxies) == 0 {
+		return next
+	}
+	trusted, _ := parseNetworks(trustedProxies)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := forwardedClientIP(r, trusted)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	})
+}
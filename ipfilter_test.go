@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteIPFilter(t *testing.T) {
+	handler, _ := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+routes:
+  - frontend: "/admin"
+    backend: "%s"
+    allowIPs: ["10.0.0.0/8", "192.168.1.7", "2001:db8::/32"]
+    denyIPs: ["10.1.0.0/16", "2001:db8:bad::/48"]
+`, okBackend(t))))
+
+	tests := []struct {
+		remoteAddr string
+		status     int
+	}{
+		{remoteAddr: "10.2.3.4:1234", status: http.StatusOK},
+		{remoteAddr: "192.168.1.7:1234", status: http.StatusOK},
+		{remoteAddr: "[2001:db8::1]:1234", status: http.StatusOK},
+		{remoteAddr: "10.1.2.3:1234", status: http.StatusForbidden},
+		{remoteAddr: "192.168.1.8:1234", status: http.StatusForbidden},
+		{remoteAddr: "[2001:db8:bad::1]:1234", status: http.StatusForbidden},
+		{remoteAddr: "[2001:db9::1]:1234", status: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		if status := serve(handler, http.MethodGet, "/admin", test.remoteAddr).Code; status != test.status {
+			t.Errorf("%s: got %d, want %d", test.remoteAddr, status, test.status)
+		}
+	}
+}
+
+func TestRouteDenyIPsOnly(t *testing.T) {
+	handler, _ := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    denyIPs: ["203.0.113.0/24", "::1"]
+`, okBackend(t))))
+
+	for remoteAddr, status := range map[string]int{"198.51.100.1:1234": http.StatusOK, "203.0.113.9:1234": http.StatusForbidden, "[::1]:1234": http.StatusForbidden} {
+		if got := serve(handler, http.MethodGet, "/tweets", remoteAddr).Code; got != status {
+			t.Errorf("%s: got %d, want %d", remoteAddr, got, status)
+		}
+	}
+}
+
+func TestIPFilterValidation(t *testing.T) {
+	assertProblems(t, validateTestConfig(t, `
+routes:
+  - frontend: "/admin"
+    backend: "http://localhost:8888"
+    allowIPs: ["10.0.0.0/33"]
+    denyIPs: ["not-an-ip"]
+`), `allowIPs: "10.0.0.0/33" is not a valid IP or CIDR`, `denyIPs: "not-an-ip" is not a valid IP or CIDR`)
+}
+
+func TestForwardedClientIP(t *testing.T) {
+	trusted, err := parseNetworks([]string{"10.0.0.0/8", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{name: "untrusted peer ignores the chain", remoteAddr: "198.51.100.1:1234", forwarded: []string{"203.0.113.5"}, want: "198.51.100.1"},
+		{name: "trusted peer without chain", remoteAddr: "10.0.0.1:1234", want: "10.0.0.1"},
+		{name: "legitimate chain", remoteAddr: "10.0.0.1:1234", forwarded: []string{"203.0.113.5, 10.0.0.2"}, want: "203.0.113.5"},
+		{name: "spoofed entries before the client", remoteAddr: "10.0.0.1:1234", forwarded: []string{"1.2.3.4, 203.0.113.5"}, want: "203.0.113.5"},
+		{name: "chain over several headers", remoteAddr: "10.0.0.1:1234", forwarded: []string{"1.2.3.4", "203.0.113.5, 10.0.0.2"}, want: "203.0.113.5"},
+		{name: "malformed entry stops the chain", remoteAddr: "10.0.0.1:1234", forwarded: []string{"203.0.113.5, garbage, 10.0.0.2"}, want: "10.0.0.2"},
+		{name: "IPv6 chain", remoteAddr: "[fd00::1]:1234", forwarded: []string{"2001:db8::5, fd00::2"}, want: "2001:db8::5"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = test.remoteAddr
+			for _, value := range test.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if got := forwardedClientIP(req, trusted); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	handler, _ := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+trustedProxies: ["10.0.0.0/8"]
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    reqsPerSec: 1
+    burst: 0
+    varyBy:
+      remoteAddr: true
+    denyIPs: ["203.0.113.66"]
+`, okBackend(t))))
+
+	send := func(forwarded string) int {
+		req := httptest.NewRequest(http.MethodGet, "/tweets", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", forwarded)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// The clients behind the same load balancer have their own bucket
+	if status := send("203.0.113.5"); status != http.StatusOK {
+		t.Errorf("first client: got %d, want 200", status)
+	}
+	if status := send("203.0.113.6"); status != http.StatusOK {
+		t.Errorf("second client: got %d, want 200", status)
+	}
+	if status := send("203.0.113.5"); status != http.StatusTooManyRequests {
+		t.Errorf("first client again: got %d, want 429", status)
+	}
+	// A client cannot spoof its way out of the deny list
+	if status := send("203.0.113.7, 203.0.113.66"); status != http.StatusForbidden {
+		t.Errorf("denied client with a spoofed entry: got %d, want 403", status)
+	}
+}
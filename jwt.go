@@ -0,0 +1,299 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultJWTClaim = "sub"
+
+// JWTVaryByConfiguration groups the requests by a claim of the bearer token sent in the Authorization header.
+// The signature is checked with a secret, a public key or a JWKS, unless unverified tokens are explicitly accepted.
+type JWTVaryByConfiguration struct {
+	Claim         string `yaml:"claim"`
+	Secret        string `yaml:"secret"`
+	PublicKeyFile string `yaml:"publicKeyFile"`
+	JWKSFile      string `yaml:"jwksFile"`
+	Unverified    bool   `yaml:"unverified"`
+}
+
+func (config JWTVaryByConfiguration) claim() string {
+	if config.Claim == "" {
+		return defaultJWTClaim
+	}
+	return config.Claim
+}
+
+func validateJWTVaryBy(varyBy *VaryBy) []string {
+	var problems []string
+	if varyBy == nil || varyBy.JWT == nil {
+		return problems
+	}
+	keys := 0
+	for _, key := range []string{varyBy.JWT.Secret, varyBy.JWT.PublicKeyFile, varyBy.JWT.JWKSFile} {
+		if key != "" {
+			keys++
+		}
+	}
+	if keys > 1 {
+		problems = append(problems, "varyBy.jwt accepts only one of secret, publicKeyFile and jwksFile")
+	}
+	// Unverified tokens let the clients choose their bucket, they are only read when explicitly accepted
+	if keys == 0 && !varyBy.JWT.Unverified {
+		problems = append(problems, "varyBy.jwt requires secret, publicKeyFile or jwksFile, or unverified: true")
+	}
+	if keys > 0 && varyBy.JWT.Unverified {
+		problems = append(problems, "varyBy.jwt unverified cannot be used with a secret, publicKeyFile or jwksFile")
+	}
+	return problems
+}
+
+// jwtClaimKey reads the claim of the bearer token used to group the requests of a route
+type jwtClaimKey struct {
+	claim   string
+	parser  *jwt.Parser
+	keyFunc jwt.Keyfunc
+}
+
+func newJWTClaimKey(item GatewayItem, config JWTVaryByConfiguration) (*jwtClaimKey, error) {
+	key := &jwtClaimKey{claim: config.claim(), parser: jwt.NewParser()}
+
+	switch {
+	case config.Unverified:
+		logrus.WithFields(logrus.Fields{
+			"label":    item.Label,
+			"frontend": item.Frontend,
+		}).Warn("The bearer tokens are not verified, the clients can choose their rate limit bucket")
+	case config.Secret != "":
+		secret := []byte(config.Secret)
+		key.parser = jwt.NewParser(jwt.WithValidMethods(signingMethods(secret)))
+		key.keyFunc = func(*jwt.Token) (interface{}, error) { return secret, nil }
+	case config.PublicKeyFile != "":
+		publicKey, err := loadPublicKey(config.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("varyBy.jwt publicKeyFile: %w", err)
+		}
+		key.parser = jwt.NewParser(jwt.WithValidMethods(signingMethods(publicKey)))
+		key.keyFunc = func(*jwt.Token) (interface{}, error) { return publicKey, nil }
+	case config.JWKSFile != "":
+		keys, err := loadJWKS(config.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("varyBy.jwt jwksFile: %w", err)
+		}
+		var methods []string
+		for _, publicKey := range keys {
+			methods = append(methods, signingMethods(publicKey)...)
+		}
+		key.parser = jwt.NewParser(jwt.WithValidMethods(methods))
+		key.keyFunc = func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			if publicKey, ok := keys[kid]; ok {
+				return publicKey, nil
+			}
+			// A token without kid can only be verified by a single key
+			if len(keys) == 1 && kid == "" {
+				for _, publicKey := range keys {
+					return publicKey, nil
+				}
+			}
+			return nil, fmt.Errorf("no key found for kid %q", kid)
+		}
+	}
+	return key, nil
+}
+
+// value returns the claim of the bearer token, the token is ignored when it is invalid
+func (k *jwtClaimKey) value(r *http.Request) (string, bool) {
+	scheme, raw, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	claims := jwt.MapClaims{}
+	var err error
+	if k.keyFunc == nil {
+		_, _, err = k.parser.ParseUnverified(strings.TrimSpace(raw), claims)
+	} else {
+		_, err = k.parser.ParseWithClaims(strings.TrimSpace(raw), claims, k.keyFunc)
+	}
+	if err != nil {
+		return "", false
+	}
+
+	switch value := claims[k.claim].(type) {
+	case string:
+		return value, value != ""
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(value), true
+	}
+	return "", false
+}
+
+// signingMethods lists the algorithms accepted for a key, so that a token cannot choose a weaker one
+func signingMethods(key interface{}) []string {
+	switch key.(type) {
+	case []byte:
+		return []string{"HS256", "HS384", "HS512"}
+	case *rsa.PublicKey:
+		return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	case *ecdsa.PublicKey:
+		return []string{"ES256", "ES384", "ES512"}
+	case ed25519.PublicKey:
+		return []string{"EdDSA"}
+	}
+	return nil
+}
+
+// loadPublicKey reads a PEM public key or certificate
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", path)
+	}
+
+	var publicKey crypto.PublicKey
+	switch block.Type {
+	case "CERTIFICATE":
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		publicKey = certificate.PublicKey
+	case "RSA PUBLIC KEY":
+		if publicKey, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		if publicKey, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if signingMethods(publicKey) == nil {
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, publicKey)
+	}
+	return publicKey, nil
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// loadJWKS reads the signature keys of a JSON Web Key Set, by kid
+func loadJWKS(path string) (map[string]crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %w", path, key.Kid, err)
+		}
+		keys[key.Kid] = publicKey
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no signature key found", path)
+	}
+	return keys, nil
+}
+
+func (key jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := decodeBigInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(key.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch key.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", key.Crv)
+		}
+		x, err := decodeBigInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if key.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", key.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", key.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("invalid base64url value %q", value)
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+// signToken returns a token with the claims, signed by the key
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func bearerRequest(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/tweets", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestJWTClaimRateLimit(t *testing.T) {
+	handler, _ := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    reqsPerSec: 1
+    burst: 0
+    varyBy:
+      jwt:
+        claim: "tenant_id"
+        secret: "s3cret"
+`, okBackend(t))))
+	secret := []byte("s3cret")
+	tenantA := signToken(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"tenant_id": "a"})
+	tenantB := signToken(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"tenant_id": "b"})
+	forged := signToken(t, jwt.SigningMethodHS256, []byte("other"), "", jwt.MapClaims{"tenant_id": "c"})
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"first request of tenant a", tenantA, http.StatusOK},
+		{"second request of tenant a", tenantA, http.StatusTooManyRequests},
+		{"tenant b has its own limit", tenantB, http.StatusOK},
+		// The requests without a valid token are grouped by client IP
+		{"forged token", forged, http.StatusOK},
+		{"no token from the same IP", "", http.StatusTooManyRequests},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, bearerRequest(test.token))
+		if rec.Code != test.status {
+			t.Errorf("%s: got %d, want %d", test.name, rec.Code, test.status)
+		}
+	}
+}
+
+// writePEM writes the DER block in a file of the test directory
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func encodeBigInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+func TestJWTVerification(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaDer, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kid": "ec", "kty": "EC", "crv": "P-256", "x": encodeBigInt(ecKey.X), "y": encodeBigInt(ecKey.Y)},
+		{"kid": "ed", "kty": "OKP", "crv": "Ed25519", "x": base64.RawURLEncoding.EncodeToString(edPublic)},
+		{"kid": "enc", "kty": "RSA", "use": "enc", "n": encodeBigInt(rsaKey.N), "e": "AQAB"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksFile, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rsaConfig := JWTVaryByConfiguration{PublicKeyFile: writePEM(t, "PUBLIC KEY", rsaDer)}
+	jwksConfig := JWTVaryByConfiguration{JWKSFile: jwksFile}
+	claims := jwt.MapClaims{"sub": "alice"}
+
+	tests := []struct {
+		name   string
+		config JWTVaryByConfiguration
+		token  string
+		valid  bool
+	}{
+		{"RSA public key", rsaConfig, signToken(t, jwt.SigningMethodRS256, rsaKey, "", claims), true},
+		{"HMAC token signed with the RSA public key", rsaConfig, signToken(t, jwt.SigningMethodHS256, rsaDer, "", claims), false},
+		{"EC key of the JWKS", jwksConfig, signToken(t, jwt.SigningMethodES256, ecKey, "ec", claims), true},
+		{"Ed25519 key of the JWKS", jwksConfig, signToken(t, jwt.SigningMethodEdDSA, edKey, "ed", claims), true},
+		{"unknown kid", jwksConfig, signToken(t, jwt.SigningMethodES256, ecKey, "other", claims), false},
+		{"encryption key of the JWKS", jwksConfig, signToken(t, jwt.SigningMethodRS256, rsaKey, "enc", claims), false},
+		{"expired token", JWTVaryByConfiguration{Secret: "s3cret"}, signToken(t, jwt.SigningMethodHS256, []byte("s3cret"), "", jwt.MapClaims{"sub": "alice", "exp": 1}), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := newJWTClaimKey(GatewayItem{}, test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, ok := key.value(bearerRequest(test.token))
+			if ok != test.valid || (ok && value != "alice") {
+				t.Errorf("got the claim %q, %v, want valid=%v", value, ok, test.valid)
+			}
+		})
+	}
+}
+
+func TestJWTUnverified(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(logrus.LevelHooks{})
+	key, err := newJWTClaimKey(GatewayItem{Label: "tweets", Frontend: "/tweets"}, JWTVaryByConfiguration{Claim: "tenant_id", Unverified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := hook.LastEntry(); entry == nil || entry.Level != logrus.WarnLevel || entry.Data["label"] != "tweets" {
+		t.Errorf("no warning logged for the unverified tokens: %v", entry)
+	}
+
+	token := signToken(t, jwt.SigningMethodHS256, []byte("any"), "", jwt.MapClaims{"tenant_id": 42.0})
+	if value, ok := key.value(bearerRequest(token)); !ok || value != "42" {
+		t.Errorf("got the claim %q, %v, want 42", value, ok)
+	}
+	if _, ok := key.value(bearerRequest("not-a-token")); ok {
+		t.Errorf("read a claim from a malformed token")
+	}
+}
+
+func TestJWTValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		jwt     string
+		problem string
+	}{
+		{"no key", `{claim: "sub"}`, "varyBy.jwt requires secret, publicKeyFile or jwksFile, or unverified: true"},
+		{"several keys", `{secret: "s3cret", jwksFile: "jwks.json"}`, "varyBy.jwt accepts only one of secret, publicKeyFile and jwksFile"},
+		{"unverified with a key", `{secret: "s3cret", unverified: true}`, "varyBy.jwt unverified cannot be used with a secret, publicKeyFile or jwksFile"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateTestConfig(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    reqsPerSec: 1
+    varyBy:
+      jwt: %s
+`, test.jwt))
+			assertProblems(t, err, test.problem)
+		})
+	}
+}
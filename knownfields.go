@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlFields returns the fields of a struct type by their name in the configuration
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for index := 0; index < t.NumField(); index++ {
+		field := t.Field(index)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// suggestField returns the known field the unknown one is probably a typo of
+func suggestField(name string, fields map[string]reflect.Type) string {
+	lower := strings.ToLower(name)
+	for known := range fields {
+		knownLower := strings.ToLower(known)
+		if lower == knownLower || strings.HasPrefix(lower, knownLower) || strings.HasPrefix(knownLower, lower) {
+			return known
+		}
+	}
+	return ""
+}
+
+// unknownFields reports the keys of the document that match no parameter of the configuration,
+// so that a misspelled parameter is not silently ignored
+func unknownFields(node *yaml.Node, t reflect.Type, path string) []string {
+	var problems []string
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			problems = append(problems, unknownFields(child, t, path)...)
+		}
+	case yaml.AliasNode:
+		problems = append(problems, unknownFields(node.Alias, t, path)...)
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			break
+		}
+		for index, child := range node.Content {
+			problems = append(problems, unknownFields(child, t.Elem(), fmt.Sprintf("%s[%d]", path, index))...)
+		}
+	case yaml.MappingNode:
+		switch t.Kind() {
+		case reflect.Map:
+			for index := 0; index+1 < len(node.Content); index += 2 {
+				key := node.Content[index].Value
+				problems = append(problems, unknownFields(node.Content[index+1], t.Elem(), joinFieldPath(path, key))...)
+			}
+		case reflect.Struct:
+			fields := yamlFields(t)
+			for index := 0; index+1 < len(node.Content); index += 2 {
+				key, value := node.Content[index], node.Content[index+1]
+				// Merged mappings hold the fields of the same struct
+				if key.Value == "<<" {
+					problems = append(problems, unknownFields(value, t, path)...)
+					continue
+				}
+				fieldType, ok := fields[key.Value]
+				if !ok {
+					problems = append(problems, unknownFieldProblem(key, path, fields))
+					continue
+				}
+				problems = append(problems, unknownFields(value, fieldType, joinFieldPath(path, key.Value))...)
+			}
+		}
+	}
+	return problems
+}
+
+func joinFieldPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func unknownFieldProblem(key *yaml.Node, path string, fields map[string]reflect.Type) string {
+	problem := fmt.Sprintf("unknown field %q", key.Value)
+	if path != "" {
+		problem += " in " + path
+	}
+	if suggestion := suggestField(key.Value, fields); suggestion != "" {
+		problem += fmt.Sprintf(" (did you mean %q?)", suggestion)
+	}
+	if key.Line > 0 {
+		problem = fmt.Sprintf("line %d: %s", key.Line, problem)
+	}
+	return problem
+}
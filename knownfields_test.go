@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnknownFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		config string
+		err    string
+	}{
+		{"misspelled route field", "config.yaml", `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    reqsPerSecond: 10
+`, `unmarshal err: line 5: unknown field "reqsPerSecond" in routes[0] (did you mean "reqsPerSec"?)`},
+		{"misspelled top level field", "config.yaml", `
+prot: "8000"
+routes: []
+`, `unmarshal err: line 2: unknown field "prot"`},
+		{"nested field", "config.yaml", `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    retry:
+      attempt: 2
+`, `unmarshal err: line 6: unknown field "attempt" in routes[0].retry (did you mean "attempts"?)`},
+		{"merged mapping", "config.yaml", `
+defaults: &defaults
+  reqsPerSec: 10
+routes:
+  - <<: *defaults
+    frontend: "/tweets"
+    backend: "http://localhost:8888"
+`, `unmarshal err: line 2: unknown field "defaults"`},
+		{"json", "config.json", `{"routes": [{"frontend": "/tweets", "backend": "http://localhost:8888", "reqsPerSecond": 1}]}`,
+			`unmarshal err: line 1: unknown field "reqsPerSecond" in routes[0] (did you mean "reqsPerSec"?)`},
+		{"known fields", "config.yaml", `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    reqsPerSec: 10
+    errorPages:
+      "503":
+        body: "unavailable"
+`, ``},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var config Configuration
+			err := decodeConfig(test.path, []byte(test.config), &config)
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got the error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestSuggestField(t *testing.T) {
+	fields := yamlFields(reflect.TypeOf(GatewayItem{}))
+	tests := map[string]string{
+		"reqsPerSecond": "reqsPerSec",
+		"Frontend":      "frontend",
+		"xyz":           "",
+	}
+	for name, want := range tests {
+		if got := suggestField(name, fields); got != want {
+			t.Errorf("suggestField(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
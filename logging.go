@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	stderrOutput = "stderr"
+	stdoutOutput = "stdout"
+)
+
+type LogConfiguration struct {
+	Level string `yaml:"level"`
+	// stderr, stdout or the path of a file the logs are appended to
+	Output string `yaml:"output"`
+}
+
+var logLevels = map[string]logrus.Level{
+	"debug": logrus.DebugLevel,
+	"info":  logrus.InfoLevel,
+	"warn":  logrus.WarnLevel,
+	"error": logrus.ErrorLevel,
+}
+
+func parseLogLevel(level string) (logrus.Level, error) {
+	if level == "" {
+		return logrus.InfoLevel, nil
+	}
+	if l, ok := logLevels[level]; ok {
+		return l, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q, expected one of debug, info, warn, error", level)
+}
+
+func validateLog(config LogConfiguration) []string {
+	var problems []string
+	if _, err := parseLogLevel(config.Level); err != nil {
+		problems = append(problems, fmt.Sprintf("log: %v", err))
+	}
+	return problems
+}
+
+// logWriter writes to the configured output, so that it can be switched on reload while requests are logged
+type logWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func (w *logWriter) set(output io.Writer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.w = output
+}
+
+// logOutput is the output of the gateway logs and of the access logs
+var logOutput = &logWriter{w: os.Stderr}
+
+// logFile is the file the logs are currently written to, closed when the output changes
+var logFile *os.File
+
+// setupLogging applies the level and the output of the configuration to the gateway logs
+func setupLogging(config LogConfiguration) error {
+	level, err := parseLogLevel(config.Level)
+	if err != nil {
+		return err
+	}
+
+	var output io.Writer
+	var file *os.File
+	switch config.Output {
+	case "", stderrOutput:
+		output = os.Stderr
+	case stdoutOutput:
+		output = os.Stdout
+	default:
+		file, err = os.OpenFile(config.Output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return fmt.Errorf("log output: %w", err)
+		}
+		output = file
+	}
+
+	logrus.SetLevel(level)
+	logOutput.set(output)
+	logrus.SetOutput(logOutput)
+	// The previous file is reopened on reload, so that rotated logs are released
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = file
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// resetLogging discards the logs again once the test is done
+func resetLogging(t *testing.T) {
+	t.Cleanup(func() {
+		logrus.SetLevel(logrus.InfoLevel)
+		logOutput.set(io.Discard)
+		logrus.SetOutput(io.Discard)
+		if logFile != nil {
+			logFile.Close()
+			logFile = nil
+		}
+	})
+}
+
+func TestLogLevelFiltering(t *testing.T) {
+	resetLogging(t)
+
+	tests := []struct {
+		level  string
+		logged []string
+	}{
+		{"", []string{"info", "warn", "error"}},
+		{"debug", []string{"debug", "info", "warn", "error"}},
+		{"warn", []string{"warn", "error"}},
+		{"error", []string{"error"}},
+	}
+	for _, test := range tests {
+		t.Run(test.level, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "gateway.log")
+			if err := setupLogging(LogConfiguration{Level: test.level, Output: output}); err != nil {
+				t.Fatal(err)
+			}
+			logrus.Debug("debug message")
+			logrus.Info("info message")
+			logrus.Warn("warn message")
+			logrus.Error("error message")
+
+			data, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var logged []string
+			for _, level := range []string{"debug", "info", "warn", "error"} {
+				if strings.Contains(string(data), level+" message") {
+					logged = append(logged, level)
+				}
+			}
+			if strings.Join(logged, ",") != strings.Join(test.logged, ",") {
+				t.Errorf("logged %v, want %v", logged, test.logged)
+			}
+		})
+	}
+}
+
+func TestAccessLogOutput(t *testing.T) {
+	resetLogging(t)
+	handler, _ := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+accessLog:
+  enabled: true
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+`, okBackend(t))))
+
+	// The access logs follow the log output, when it changes on reload as well
+	for _, name := range []string{"gateway.log", "reloaded.log"} {
+		output := filepath.Join(t.TempDir(), name)
+		if err := setupLogging(LogConfiguration{Output: output}); err != nil {
+			t.Fatal(err)
+		}
+		serve(handler, http.MethodGet, "/tweets", "10.0.0.1:1234")
+		logrus.Info("gateway message")
+
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), `"msg":"Access"`) || !strings.Contains(string(data), "gateway message") {
+			t.Errorf("%s: got %q, want the access log and the gateway log", name, data)
+		}
+	}
+}
+
+func TestLogValidation(t *testing.T) {
+	err := validateTestConfig(t, `
+log:
+  level: "verbose"
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`)
+	assertProblems(t, err, `log: unknown log level "verbose", expected one of debug, info, warn, error`)
+
+	if err := setupLogging(LogConfiguration{Output: filepath.Join(t.TempDir(), "missing", "gateway.log")}); err == nil {
+		t.Errorf("opened a log file in a missing directory")
+	}
+}
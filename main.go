@@ -1,34 +1,184 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"reflect"
 	"regexp"
-	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/kataras/requestid"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/throttled/throttled/v2"
-	"github.com/throttled/throttled/v2/store/memstore"
-	"gopkg.in/yaml.v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultConfigPath      = "rockhopper.yaml"
+	stdinConfigPath        = "-"
+	defaultShutdownTimeout = 15 * time.Second
+
+	defaultReadTimeout       = 15 * time.Second
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultWriteTimeout      = 15 * time.Second
+	defaultIdleTimeout       = 60 * time.Second
+
+	// Non standard status, borrowed from nginx, recorded when the client disconnects before the response
+	statusClientClosedRequest = 499
 )
 
 type GatewayItem struct {
-	Frontend     string   `yaml:"frontend"`
-	Backend      string   `yaml:"backend"`
-	MaxReqPerSec int      `yaml:"reqsPerSec"`
-	MaxBurst     int      `yaml:"burst"`
-	Label        string   `yaml:"label"`
-	Headers      []string `yaml:"headers"`
-	QueryParams  []string `yaml:"queryParams"`
+	Frontend     string             `yaml:"frontend"`
+	Backend      string             `yaml:"backend"`
+	Backends     []Backend          `yaml:"backends"`
+	MaxReqPerSec int                `yaml:"reqsPerSec"`
+	Rate         string             `yaml:"rate"`
+	MaxBurst     *int               `yaml:"burst"`
+	Label        string             `yaml:"label"`
+	Headers      []string           `yaml:"headers"`
+	QueryParams  []string           `yaml:"queryParams"`
+	VaryBy       *VaryBy            `yaml:"varyBy"`
+	StripPrefix  *bool              `yaml:"stripPrefix"`
+	Timeout      time.Duration      `yaml:"timeout"`
+	MaxBodyBytes int64              `yaml:"maxBodyBytes"`
+	Retry        RetryConfiguration `yaml:"retry"`
+	WebSocket    bool               `yaml:"websocket"`
+	CORS         *CORSConfiguration `yaml:"cors"`
+	Metrics      *bool              `yaml:"metrics"`
+	Methods      []string           `yaml:"methods"`
+	Balancing    string             `yaml:"balancing"`
+
+	ResponseHeaders         map[string]string                 `yaml:"responseHeaders"`
+	OverrideResponseHeaders bool                              `yaml:"overrideResponseHeaders"`
+	HealthCheck             *HealthCheckConfiguration         `yaml:"healthCheck"`
+	CircuitBreaker          *CircuitBreakerConfiguration      `yaml:"circuitBreaker"`
+	Rewrite                 *RewriteConfiguration             `yaml:"rewrite"`
+	RateLimitResponse       *RateLimitResponseConfiguration   `yaml:"rateLimitResponse"`
+	BasicAuth               *BasicAuthConfiguration           `yaml:"basicAuth"`
+	APIKey                  *APIKeyConfiguration              `yaml:"apiKey"`
+	MaxConcurrent           int                               `yaml:"maxConcurrent"`
+	QueueTimeout            time.Duration                     `yaml:"queueTimeout"`
+	HostHeader              string                            `yaml:"hostHeader"`
+	PreserveHost            bool                              `yaml:"preserveHost"`
+	Protocol                string                            `yaml:"protocol"`
+	AllowIPs                []string                          `yaml:"allowIPs"`
+	DenyIPs                 []string                          `yaml:"denyIPs"`
+	MetricsBuckets          []float64                         `yaml:"metricsBuckets"`
+	Compression             *CompressionConfiguration         `yaml:"compression"`
+	DebugBodyBytes          int                               `yaml:"debugBodyBytes"`
+	Sticky                  *StickyConfiguration              `yaml:"sticky"`
+	UpstreamTLS             *UpstreamTLSConfiguration         `yaml:"upstreamTls"`
+	Streaming               bool                              `yaml:"streaming"`
+	RateLimitBypass         *RateLimitBypassConfiguration     `yaml:"rateLimitBypass"`
+	UpstreamHeader          string                            `yaml:"upstreamHeader"`
+	Match                   string                            `yaml:"match"`
+	FollowRedirects         bool                              `yaml:"followRedirects"`
+	TrailingSlash           *TrailingSlashConfiguration       `yaml:"trailingSlash"`
+	Maintenance             *MaintenanceConfiguration         `yaml:"maintenance"`
+	Transport               *TransportConfiguration           `yaml:"transport"`
+	Cache                   *CacheConfiguration               `yaml:"cache"`
+	RateLimitCost           bool                              `yaml:"rateLimitCost"`
+	ErrorPages              map[string]ErrorPageConfiguration `yaml:"errorPages"`
+
+	// Set from the global configuration
+	requestIDHeader  string
+	notFoundResponse *NotFoundResponseConfiguration
+}
+
+func (item GatewayItem) backends() []Backend {
+	var backends []Backend
+	if item.Backend != "" {
+		backends = append(backends, Backend{URL: item.Backend})
+	}
+	return append(backends, item.Backends...)
+}
+
+// withBackendScheme prefixes the backends without scheme, like localhost:9000, with the scheme
+func (item GatewayItem) withBackendScheme(scheme string) GatewayItem {
+	if item.Backend != "" && !strings.Contains(item.Backend, "://") {
+		item.Backend = scheme + "://" + item.Backend
+	}
+	if len(item.Backends) > 0 {
+		backends := make([]Backend, len(item.Backends))
+		for index, backend := range item.Backends {
+			if backend.URL != "" && !strings.Contains(backend.URL, "://") {
+				backend.URL = scheme + "://" + backend.URL
+			}
+			backends[index] = backend
+		}
+		item.Backends = backends
+	}
+	return item
+}
+
+// applyDefaultBackendScheme sets the defaultBackendScheme on the backends without scheme
+func (config *Configuration) applyDefaultBackendScheme() {
+	if config.DefaultBackendScheme != "http" && config.DefaultBackendScheme != "https" {
+		return
+	}
+	for index, item := range config.Routes {
+		config.Routes[index] = item.withBackendScheme(config.DefaultBackendScheme)
+	}
+	if config.DefaultRoute != nil {
+		item := config.DefaultRoute.withBackendScheme(config.DefaultBackendScheme)
+		config.DefaultRoute = &item
+	}
+}
+
+func (item GatewayItem) backendURLs() []string {
+	var backends []string
+	for _, backend := range item.backends() {
+		backends = append(backends, backend.URL)
+	}
+	return backends
+}
+
+// burst returns the configured burst, or the rate when the burst is omitted, so a route
+// without explicit burst can absorb one period worth of requests at once
+func (item GatewayItem) burst() int {
+	if item.MaxBurst != nil {
+		return *item.MaxBurst
+	}
+	if count, _, err := parseRate(item.Rate); err == nil {
+		return count
+	}
+	return item.MaxReqPerSec
+}
+
+// metricsEnabled returns the metrics setting of the route, or the global one when the route does not override it
+func (item GatewayItem) metricsEnabled(global bool) bool {
+	if item.Metrics == nil {
+		return global
+	}
+	return *item.Metrics
+}
+
+// The frontend prefix is stripped from the forwarded path unless explicitly disabled
+func (item GatewayItem) stripPrefix() bool {
+	return item.StripPrefix == nil || *item.StripPrefix
+}
+
+type VaryBy struct {
+	RemoteAddr bool                    `yaml:"remoteAddr"`
+	Path       bool                    `yaml:"path"`
+	Method     bool                    `yaml:"method"`
+	Headers    []string                `yaml:"headers"`
+	JWT        *JWTVaryByConfiguration `yaml:"jwt"`
 }
 
 type IpConfiguration struct {
@@ -37,10 +187,133 @@ type IpConfiguration struct {
 }
 
 type Configuration struct {
-	Routes  []GatewayItem   `yaml:"routes"`
-	Metrics bool            `yaml:"metrics"`
-	Port    string          `yaml:"port"`
-	Ip      IpConfiguration `yaml:"ip"`
+	Routes       []GatewayItem             `yaml:"routes"`
+	Metrics      bool                      `yaml:"metrics"`
+	Port         string                    `yaml:"port"`
+	Host         string                    `yaml:"host"`
+	Ip           IpConfiguration           `yaml:"ip"`
+	Store        StoreConfiguration        `yaml:"store"`
+	Timeouts     TimeoutsConfiguration     `yaml:"timeouts"`
+	Transport    TransportConfiguration    `yaml:"transport"`
+	AccessLog    AccessLogConfiguration    `yaml:"accessLog"`
+	Log          LogConfiguration          `yaml:"log"`
+	Tracing      TracingConfiguration      `yaml:"tracing"`
+	Health       HealthConfiguration       `yaml:"health"`
+	StartupProbe StartupProbeConfiguration `yaml:"startupProbe"`
+	TLS          TLSConfiguration          `yaml:"tls"`
+
+	MetricsPath    string `yaml:"metricsPath"`
+	MetricsAddress string `yaml:"metricsAddress"`
+
+	DefaultRoute        *GatewayItem                      `yaml:"defaultRoute"`
+	RateLimitResponse   *RateLimitResponseConfiguration   `yaml:"rateLimitResponse"`
+	RequestIDHeader     string                            `yaml:"requestIdHeader"`
+	TrustedProxies      []string                          `yaml:"trustedProxies"`
+	MetricsBuckets      []float64                         `yaml:"metricsBuckets"`
+	MaxHeaderBytes      int                               `yaml:"maxHeaderBytes"`
+	MaxHeaderCount      int                               `yaml:"maxHeaderCount"`
+	ConfigEndpoint      *ConfigEndpointConfiguration      `yaml:"configEndpoint"`
+	UpstreamTLS         *UpstreamTLSConfiguration         `yaml:"upstreamTls"`
+	NotFoundResponse    *NotFoundResponseConfiguration    `yaml:"notFoundResponse"`
+	Stats               *StatsConfiguration               `yaml:"stats"`
+	MaintenanceEndpoint *MaintenanceEndpointConfiguration `yaml:"maintenanceEndpoint"`
+
+	RateLimitSummaryInterval time.Duration `yaml:"rateLimitSummaryInterval"`
+	AllowEmptyRoutes         bool          `yaml:"allowEmptyRoutes"`
+	DefaultBackendScheme     string        `yaml:"defaultBackendScheme"`
+}
+
+const defaultRouteLabel = "default"
+
+// routes returns the configured routes, followed by the catch-all route when one is configured.
+// The global settings are applied to the routes that do not override them.
+func (config Configuration) routes() []GatewayItem {
+	routes := make([]GatewayItem, 0, len(config.Routes)+1)
+	routes = append(routes, config.Routes...)
+
+	if config.DefaultRoute != nil {
+		item := *config.DefaultRoute
+		item.Frontend = "/"
+		if item.Label == "" {
+			item.Label = defaultRouteLabel
+		}
+		// Unmatched traffic is usually spread over many paths, it is grouped by client instead
+		if item.VaryBy == nil {
+			item.VaryBy = &VaryBy{RemoteAddr: true}
+		}
+		routes = append(routes, item)
+	}
+
+	for index := range routes {
+		if routes[index].RateLimitResponse == nil {
+			routes[index].RateLimitResponse = config.RateLimitResponse
+		}
+		if routes[index].MetricsBuckets == nil {
+			routes[index].MetricsBuckets = config.MetricsBuckets
+		}
+		if routes[index].UpstreamTLS == nil {
+			routes[index].UpstreamTLS = config.UpstreamTLS
+		}
+		if routes[index].Transport != nil {
+			transport := routes[index].Transport.withDefaults(config.Transport)
+			routes[index].Transport = &transport
+		}
+		routes[index].requestIDHeader = config.requestIDHeader()
+		routes[index].notFoundResponse = config.NotFoundResponse
+	}
+	return routes
+}
+
+// address is the listen address of the gateway, all the interfaces unless a host is configured
+func (config Configuration) address() string {
+	return net.JoinHostPort(config.Host, config.Port)
+}
+
+// displayHost is the host printed in the gateway URLs
+func (config Configuration) displayHost() string {
+	if ip := net.ParseIP(config.Host); config.Host == "" || (ip != nil && ip.IsUnspecified()) {
+		return "127.0.0.1"
+	}
+	return config.Host
+}
+
+type TimeoutsConfiguration struct {
+	Shutdown   time.Duration `yaml:"shutdown"`
+	Read       time.Duration `yaml:"read"`
+	ReadHeader time.Duration `yaml:"readHeader"`
+	Write      time.Duration `yaml:"write"`
+	Idle       time.Duration `yaml:"idle"`
+	Drain      time.Duration `yaml:"drain"`
+}
+
+func (timeouts TimeoutsConfiguration) shutdown() time.Duration {
+	return valueOrDefault(timeouts.Shutdown, defaultShutdownTimeout)
+}
+
+func validateTimeouts(timeouts TimeoutsConfiguration) []string {
+	var problems []string
+	names := []string{"shutdown", "read", "readHeader", "write", "idle", "drain"}
+	values := []time.Duration{timeouts.Shutdown, timeouts.Read, timeouts.ReadHeader, timeouts.Write, timeouts.Idle, timeouts.Drain}
+	for i, value := range values {
+		if value < 0 {
+			problems = append(problems, fmt.Sprintf("timeouts.%s must be positive, got %v", names[i], value))
+		}
+	}
+	return problems
+}
+
+// newHTTPServer builds the gateway listener, a slow client cannot hold a connection longer than the timeouts
+func newHTTPServer(config Configuration, handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           withH2C(config, handler),
+		Addr:              config.address(),
+		ReadTimeout:       valueOrDefault(config.Timeouts.Read, defaultReadTimeout),
+		ReadHeaderTimeout: valueOrDefault(config.Timeouts.ReadHeader, defaultReadHeaderTimeout),
+		WriteTimeout:      valueOrDefault(config.Timeouts.Write, defaultWriteTimeout),
+		IdleTimeout:       valueOrDefault(config.Timeouts.Idle, defaultIdleTimeout),
+		MaxHeaderBytes:    valueOrDefault(config.MaxHeaderBytes, http.DefaultMaxHeaderBytes),
+		ErrorLog:          log.New(logrus.StandardLogger().WriterLevel(logrus.WarnLevel), "", 0),
+	}
 }
 
 type ResponseTime struct {
@@ -55,23 +328,229 @@ func (resp *ResponseTime) Collect(method string, route string, code string, resp
 	}).Observe(responseTime)
 }
 
-func NewResponseTime(label string) *ResponseTime {
+var defaultResponseTimeBuckets = []float64{.1, 5, 15, 50, 100, 200, 300, 400, 500, 1000}
+
+func responseTimeBuckets(buckets []float64) []float64 {
+	if len(buckets) == 0 {
+		return defaultResponseTimeBuckets
+	}
+	return buckets
+}
+
+func NewResponseTime(registry *prometheus.Registry, label string, buckets []float64) *ResponseTime {
 	responseTimeHistogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    fmt.Sprintf("%s_http_request_duration_ms", label),
 		Help:    fmt.Sprintf("Duration of HTTP requests received by the %s endpoint in ms", label),
-		Buckets: []float64{.1, 5, 15, 50, 100, 200, 300, 400, 500, 1000},
+		Buckets: responseTimeBuckets(buckets),
 	}, []string{"method", "route", "code"})
-	prometheus.MustRegister(responseTimeHistogram)
+	responseTimeHistogram = registerCollector(registry, responseTimeHistogram)
 	return &ResponseTime{
 		responseTimeHistogram,
 	}
 }
 
+const problemBackendRequired = "backend is required"
+
+func validateRoute(item GatewayItem) []string {
+	var problems []string
+
+	if item.Frontend == "" {
+		problems = append(problems, "frontend is required")
+	} else if !strings.HasPrefix(item.Frontend, "/") {
+		problems = append(problems, fmt.Sprintf("frontend %q must start with /", item.Frontend))
+	}
+	problems = append(problems, validateMatch(item)...)
+	problems = append(problems, validateTrailingSlash(item.TrailingSlash)...)
+
+	if item.Backend != "" && len(item.Backends) > 0 {
+		problems = append(problems, "backend and backends cannot be used at the same time")
+	} else if item.Backend == "" && len(item.Backends) == 0 {
+		problems = append(problems, problemBackendRequired)
+	}
+	for _, backend := range item.Backends {
+		if backend.URL == "" {
+			problems = append(problems, "backends entries require an url")
+		}
+		if backend.Weight < 0 {
+			problems = append(problems, fmt.Sprintf("backend %q weight must be positive, got %d", backend.URL, backend.Weight))
+		}
+	}
+	for _, backend := range item.backendURLs() {
+		if backend != "" && !strings.Contains(backend, "://") {
+			problems = append(problems, fmt.Sprintf("backend %q has no scheme, use \"http://%s\" or set defaultBackendScheme", backend, backend))
+		} else if backendUrl, err := url.Parse(backend); err != nil {
+			problems = append(problems, fmt.Sprintf("backend %q is not a valid URL: %v", backend, err))
+		} else if backendUrl.Scheme != "http" && backendUrl.Scheme != "https" {
+			problems = append(problems, fmt.Sprintf("backend %q must use the http or https scheme", backend))
+		} else if backendUrl.Host == "" {
+			problems = append(problems, fmt.Sprintf("backend %q has no host", backend))
+		}
+	}
+
+	if item.MaxReqPerSec < 0 {
+		problems = append(problems, fmt.Sprintf("reqsPerSec must be positive, or 0 to disable rate limiting, got %d", item.MaxReqPerSec))
+	}
+	if item.Rate != "" {
+		if item.MaxReqPerSec != 0 {
+			problems = append(problems, "rate and reqsPerSec cannot be used at the same time")
+		}
+		if _, _, err := parseRate(item.Rate); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if item.MaxBurst != nil && *item.MaxBurst < 0 {
+		problems = append(problems, fmt.Sprintf("burst must be positive, got %d", *item.MaxBurst))
+	}
+	if item.MaxBodyBytes < 0 {
+		problems = append(problems, fmt.Sprintf("maxBodyBytes must be positive, got %d", item.MaxBodyBytes))
+	}
+	if item.Timeout < 0 {
+		problems = append(problems, fmt.Sprintf("timeout must be positive, got %v", item.Timeout))
+	}
+	problems = append(problems, validateRetry(item.Retry)...)
+	problems = append(problems, validateCORS(item.CORS)...)
+	problems = append(problems, validateBalancing(item)...)
+	problems = append(problems, validateHealthCheck(item.HealthCheck)...)
+	problems = append(problems, validateCircuitBreaker(item.CircuitBreaker)...)
+	problems = append(problems, validateBasicAuth(item.BasicAuth)...)
+	problems = append(problems, validateAPIKey(item.APIKey)...)
+	problems = append(problems, validateConcurrency(item)...)
+	problems = append(problems, validateProtocol(item)...)
+	problems = append(problems, validateIPFilter(item)...)
+	problems = append(problems, validateRateLimitBypass(item)...)
+	if item.RateLimitCost && !item.rateLimited() {
+		problems = append(problems, "rateLimitCost requires a rate limit")
+	}
+	problems = append(problems, validateJWTVaryBy(item.VaryBy)...)
+	if strings.ContainsAny(item.UpstreamHeader, " \t:") {
+		problems = append(problems, fmt.Sprintf("upstreamHeader %q is not a valid header name", item.UpstreamHeader))
+	}
+	problems = append(problems, validateBuckets(item.MetricsBuckets)...)
+	problems = append(problems, validateCompression(item.Compression)...)
+	problems = append(problems, validateDebugBodyBytes(item.DebugBodyBytes)...)
+	problems = append(problems, validateUpstreamTLS(item.UpstreamTLS)...)
+	problems = append(problems, validateCache(item)...)
+	problems = append(problems, validateErrorPages(item.ErrorPages)...)
+	if item.HostHeader != "" && item.PreserveHost {
+		problems = append(problems, "hostHeader and preserveHost cannot be used at the same time")
+	}
+	if item.Rewrite != nil {
+		if item.Rewrite.Pattern == "" {
+			problems = append(problems, "rewrite.pattern is required")
+		} else if _, err := regexp.Compile(item.Rewrite.Pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("rewrite.pattern %q is not a valid regular expression: %v", item.Rewrite.Pattern, err))
+		}
+	}
+	for _, method := range item.Methods {
+		if method == "" || method != strings.ToUpper(method) || strings.ContainsAny(method, " \t,") {
+			problems = append(problems, fmt.Sprintf("methods entry %q must be an uppercase HTTP method", method))
+		}
+	}
+	for name := range item.ResponseHeaders {
+		if name == "" || strings.ContainsAny(name, " \t:") {
+			problems = append(problems, fmt.Sprintf("responseHeaders has an invalid header name %q", name))
+		}
+	}
+
+	return problems
+}
+
+// ValidateConfig checks the whole configuration and reports every problem found at once
 func ValidateConfig(config Configuration) error {
+	var problems []string
+
 	if len(config.Ip.Blacklist) > 0 && len(config.Ip.Whitelist) > 0 {
-		return fmt.Errorf("ip whitelisting and blacklisting cannot be used at the same time")
+		problems = append(problems, "ip whitelisting and blacklisting cannot be used at the same time")
+	}
+
+	problems = append(problems, validateTLS(config.TLS)...)
+	problems = append(problems, validateTimeouts(config.Timeouts)...)
+	problems = append(problems, validateLog(config.Log)...)
+	problems = append(problems, validateAccessLog(config.AccessLog)...)
+	problems = append(problems, validateTracing(config.Tracing)...)
+	problems = append(problems, validateStartupProbe(config.StartupProbe)...)
+
+	if config.MetricsPath != "" && !strings.HasPrefix(config.MetricsPath, "/") {
+		problems = append(problems, fmt.Sprintf("metricsPath %q must start with /", config.MetricsPath))
+	}
+	if config.metricsOnGateway() && (config.metricsPath() == livenessPath || config.metricsPath() == readinessPath) {
+		problems = append(problems, fmt.Sprintf("metricsPath %q is reserved by the gateway", config.MetricsPath))
 	}
 
+	problems = append(problems, validateBuckets(config.MetricsBuckets)...)
+	problems = append(problems, validateHeaderLimits(config)...)
+	problems = append(problems, validateConfigEndpoint(config)...)
+	problems = append(problems, validateStats(config)...)
+	problems = append(problems, validateMaintenanceEndpoint(config)...)
+	problems = append(problems, validateUpstreamTLS(config.UpstreamTLS)...)
+	if config.DefaultBackendScheme != "" && config.DefaultBackendScheme != "http" && config.DefaultBackendScheme != "https" {
+		problems = append(problems, fmt.Sprintf("defaultBackendScheme must be http or https, got %q", config.DefaultBackendScheme))
+	}
+	// Without routes every request is answered 404, which is usually a mistake in the configuration
+	if len(config.routes()) == 0 && !config.AllowEmptyRoutes {
+		problems = append(problems, "no route is configured, add routes or a defaultRoute, or set allowEmptyRoutes to start without routes")
+	}
+	if config.RateLimitSummaryInterval < 0 {
+		problems = append(problems, fmt.Sprintf("rateLimitSummaryInterval must be positive, got %v", config.RateLimitSummaryInterval))
+	}
+	if _, err := parseNetworks(config.TrustedProxies); err != nil {
+		problems = append(problems, fmt.Sprintf("trustedProxies: %v", err))
+	}
+
+	if strings.ContainsAny(config.RequestIDHeader, " \t:") {
+		problems = append(problems, fmt.Sprintf("requestIdHeader %q is not a valid header name", config.RequestIDHeader))
+	}
+
+	switch config.Store.Type {
+	case "", memoryStore:
+	case redisStore:
+		if config.Store.Redis.Address == "" {
+			problems = append(problems, "redis store requires an address")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown store type %q, expected %q or %q", config.Store.Type, memoryStore, redisStore))
+	}
+
+	frontends := make(map[string]int)
+	for index, item := range config.Routes {
+		for _, problem := range validateRoute(item) {
+			problems = append(problems, fmt.Sprintf("routes[%d] (%s): %s", index, item.Frontend, problem))
+		}
+
+		if item.Frontend == "" {
+			continue
+		}
+		if item.Frontend == livenessPath || item.Frontend == readinessPath || (config.metricsOnGateway() && item.Frontend == config.metricsPath()) || item.Frontend == config.configEndpointPath() || item.Frontend == config.statsPath() || item.Frontend == config.maintenanceEndpointPath() {
+			problems = append(problems, fmt.Sprintf("routes[%d] (%s): frontend is reserved by the gateway", index, item.Frontend))
+		}
+		if previous, exists := frontends[item.matchKey()]; exists {
+			problems = append(problems, fmt.Sprintf("routes[%d] (%s): frontend already used by routes[%d]", index, item.Frontend, previous))
+		} else {
+			frontends[item.matchKey()] = index
+		}
+	}
+
+	problems = append(problems, validateMetricLabels(config)...)
+
+	if config.DefaultRoute != nil {
+		if config.DefaultRoute.Frontend != "" && config.DefaultRoute.Frontend != "/" {
+			problems = append(problems, "defaultRoute: frontend cannot be set, the default route matches every unmatched path")
+		}
+		if _, exists := frontends["/*"]; exists {
+			problems = append(problems, "defaultRoute: cannot be used with a route on the / frontend")
+		}
+		item := config.routes()[len(config.Routes)]
+		for _, problem := range validateRoute(item) {
+			// Without backend, unmatched paths are answered with 404
+			if problem != problemBackendRequired {
+				problems = append(problems, fmt.Sprintf("defaultRoute: %s", problem))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
 	return nil
 }
 
@@ -106,7 +585,108 @@ func isParamAuthorized(param string, list []string) bool {
 	return false
 }
 
-func RPHandler(label string, backend string, requestTotalCounter prometheus.Counter, responseTimeCollector *ResponseTime, ipConfig IpConfiguration, headerFilter []string, queryParamsFilter []string) func(w http.ResponseWriter, r *http.Request) {
+// routeVaryBy prefixes the throttled key with the route frontend so routes
+// sharing the same store never share a bucket.
+type routeVaryBy struct {
+	frontend   string
+	remoteAddr bool
+	jwt        *jwtClaimKey
+	varyBy     *throttled.VaryBy
+}
+
+// The client IP is added to the key by the gateway, so that it can be found behind the trusted proxies
+func (v *routeVaryBy) Key(r *http.Request) string {
+	key := v.frontend + "\n"
+	if v.remoteAddr {
+		key += clientIP(r) + "\n"
+	}
+	// Requests without a valid token are grouped by client IP
+	if v.jwt != nil {
+		if claim, ok := v.jwt.value(r); ok {
+			key += "jwt:" + claim + "\n"
+		} else {
+			key += "ip:" + clientIP(r) + "\n"
+		}
+	}
+	return key + v.varyBy.Key(r)
+}
+
+func newRouteVaryBy(item GatewayItem) (*routeVaryBy, error) {
+	// Without configuration, requests are grouped by path only
+	varyBy := &throttled.VaryBy{Path: true}
+	remoteAddr := false
+	var jwtKey *jwtClaimKey
+	if item.VaryBy != nil {
+		varyBy = &throttled.VaryBy{
+			Path:    item.VaryBy.Path,
+			Method:  item.VaryBy.Method,
+			Headers: item.VaryBy.Headers,
+		}
+		remoteAddr = item.VaryBy.RemoteAddr
+		if item.VaryBy.JWT != nil {
+			var err error
+			if jwtKey, err = newJWTClaimKey(item, *item.VaryBy.JWT); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &routeVaryBy{
+		frontend:   item.Frontend,
+		remoteAddr: remoteAddr,
+		jwt:        jwtKey,
+		varyBy:     varyBy,
+	}, nil
+}
+
+// clientIP returns the IP of the client, found behind the trusted proxies if any
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func joinURLPath(base string, suffix string) string {
+	if suffix == "" {
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(suffix, "/")
+}
+
+func joinRawQuery(base string, incoming string) string {
+	if base == "" || incoming == "" {
+		return base + incoming
+	}
+	return base + "&" + incoming
+}
+
+// Failures to reach the backend are reported as 502, or 504 when the backend took too long.
+// A request body exceeding the route limit is only detected while sending it, and reported as 413.
+func upstreamErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+func RPHandler(ctx context.Context, item GatewayItem, client *http.Client, routeMetrics *RouteMetrics, ipConfig IpConfiguration) (http.HandlerFunc, error) {
+	label := item.Label
+	proxy, err := NewReverseProxy(ctx, item, client, routeMetrics)
+	if err != nil {
+		return nil, err
+	}
+	limiter := newConcurrencyLimiter(item)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := requestid.Get(r)
 		logrus.WithFields(logrus.Fields{
@@ -118,14 +698,10 @@ func RPHandler(label string, backend string, requestTotalCounter prometheus.Coun
 		}).Info("Incoming call")
 
 		start := time.Now()
-		if requestTotalCounter != nil {
-			requestTotalCounter.Inc()
-		}
+		defer routeMetrics.started()()
 
-		ip := strings.Split(r.RemoteAddr, ":")[0]
-
-		if (len(ipConfig.Blacklist) > 0 && isIPBlacklisted(ip, ipConfig)) || (len(ipConfig.Whitelist) > 0 && !isIPWhitelisted(ip, ipConfig)) {
-			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		// report records the failure of the request in logs and metrics
+		report := func(status int, fields logrus.Fields, message string) {
 			execTime := time.Since(start)
 			logrus.WithFields(logrus.Fields{
 				"label":          label,
@@ -134,210 +710,493 @@ func RPHandler(label string, backend string, requestTotalCounter prometheus.Coun
 				"user-agent":     r.UserAgent(),
 				"requestid":      id,
 				"execution-time": execTime,
-				"ip":             ip,
-			}).Errorf("Unauthorized IP %v", ip)
-			if responseTimeCollector != nil {
-				responseTimeCollector.Collect(r.Method, r.RequestURI, strconv.Itoa(http.StatusForbidden), float64(execTime.Milliseconds()))
-			}
-			return
+			}).WithFields(fields).Error(message)
+			routeMetrics.completed(r, status, execTime)
+		}
+		fail := func(status int, fields logrus.Fields, message string) {
+			http.Error(w, http.StatusText(status), status)
+			report(status, fields, message)
 		}
 
-		// Manage query parms
-		backendUrl, err := url.Parse(backend)
-		if err != nil {
-			log.Fatal(err)
+		ip := clientIP(r)
+
+		if (len(ipConfig.Blacklist) > 0 && isIPBlacklisted(ip, ipConfig)) || (len(ipConfig.Whitelist) > 0 && !isIPWhitelisted(ip, ipConfig)) {
+			fail(http.StatusForbidden, logrus.Fields{"ip": ip}, fmt.Sprintf("Unauthorized IP %v", ip))
+			return
 		}
-		backendQuery := backendUrl.Query()
-		for k, v := range r.URL.Query() {
-			if isParamAuthorized(k, queryParamsFilter) {
-				for _, val := range v {
-					backendQuery.Add(k, val)
-				}
+
+		if item.MaxBodyBytes > 0 {
+			if r.ContentLength > item.MaxBodyBytes {
+				fail(http.StatusRequestEntityTooLarge, nil, fmt.Sprintf("Request body of %d bytes exceeds the limit of %d bytes", r.ContentLength, item.MaxBodyBytes))
+				return
 			}
+			r.Body = http.MaxBytesReader(w, r.Body, item.MaxBodyBytes)
 		}
-		backendUrl.RawQuery = backendQuery.Encode()
 
-		req, err := http.NewRequest(r.Method, backendUrl.String(), r.Body)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			execTime := time.Since(start)
-			logrus.WithFields(logrus.Fields{
-				"label":          label,
-				"method":         r.Method,
-				"uri":            r.RequestURI,
-				"user-agent":     r.UserAgent(),
-				"requestid":      id,
-				"execution-time": execTime,
-			}).Errorf("Execution error %v", err.Error())
-			if responseTimeCollector != nil {
-				responseTimeCollector.Collect(r.Method, r.RequestURI, strconv.Itoa(http.StatusInternalServerError), float64(execTime.Milliseconds()))
+		if err := limiter.acquire(r.Context()); err != nil {
+			if errors.Is(err, errConcurrencyLimit) {
+				fail(http.StatusServiceUnavailable, nil, fmt.Sprintf("Concurrency limit of %d requests reached", item.MaxConcurrent))
+			} else {
+				report(statusClientClosedRequest, nil, "Client closed the request")
 			}
 			return
 		}
+		defer limiter.release()
 
-		// Manage headers
-		for k, v := range r.Header {
-			if isParamAuthorized(k, headerFilter) {
-				req.Header[k] = v
-			}
+		// The body is counted as it is read from the client, the retried attempts are not counted again
+		var requestBytes *countingReader
+		if routeMetrics != nil && r.Body != nil && r.Body != http.NoBody {
+			requestBytes = &countingReader{Reader: r.Body}
+			r.Body = readCloser{requestBytes, r.Body}
 		}
 
-		client := &http.Client{}
+		webSocket := item.WebSocket && isWebSocketRequest(r)
 
-		resp, err := client.Do(req)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			execTime := time.Since(start)
-			logrus.WithFields(logrus.Fields{
-				"label":          label,
-				"method":         r.Method,
-				"uri":            r.RequestURI,
-				"user-agent":     r.UserAgent(),
-				"requestid":      id,
-				"execution-time": execTime,
-			}).Errorf("Execution error %v", err.Error())
-			if responseTimeCollector != nil {
-				responseTimeCollector.Collect(r.Method, r.RequestURI, strconv.Itoa(http.StatusInternalServerError), float64(execTime.Milliseconds()))
+		// The route timeout does not apply to a websocket session, nor to a streaming route
+		ctx := r.Context()
+		if item.Timeout > 0 && !webSocket && !item.Streaming {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, item.Timeout)
+			defer cancel()
+		}
+
+		// Retried requests need a body that can be sent again, it is kept in memory up to the
+		// buffer limit of the route. A larger body is streamed to the backend and is not retried.
+		if item.Retry.allows(r.Method) && r.Body != nil && r.Body != http.NoBody {
+			limit := item.Retry.maxBufferBytes()
+			body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+			if err != nil {
+				status := http.StatusBadRequest
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					status = http.StatusRequestEntityTooLarge
+				}
+				fail(status, nil, fmt.Sprintf("Reading request body failed %v", err.Error()))
+				return
+			}
+			if int64(len(body)) > limit {
+				r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			} else {
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				// The buffered body is sent with its length, even when the client sent it chunked
+				r.ContentLength = int64(len(body))
+				r.TransferEncoding = nil
+				r.GetBody = func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(body)), nil
+				}
 			}
-			return
 		}
-		defer resp.Body.Close()
 
-		for k, v := range resp.Header {
-			w.Header()[k] = v
+		// The beginning of the bodies is logged for debugging, the bodies are still streamed
+		var requestBody, responseBody *bodyCapture
+		if item.DebugBodyBytes > 0 && !webSocket {
+			requestBody = &bodyCapture{limit: item.DebugBodyBytes}
+			responseBody = &bodyCapture{limit: item.DebugBodyBytes}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = readCloser{io.TeeReader(r.Body, requestBody), r.Body}
+			}
+			w = &capturingWriter{ResponseWriter: w, capture: responseBody}
 		}
-		w.WriteHeader(resp.StatusCode)
 
-		if _, err := io.Copy(w, resp.Body); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			execTime := time.Since(start)
-			logrus.WithFields(logrus.Fields{
-				"label":          label,
-				"method":         r.Method,
-				"uri":            r.RequestURI,
-				"user-agent":     r.UserAgent(),
-				"requestid":      id,
-				"execution-time": execTime,
-			}).Errorf("Execution error %v", err.Error())
-			if responseTimeCollector != nil {
-				responseTimeCollector.Collect(r.Method, r.RequestURI, strconv.Itoa(http.StatusInternalServerError), float64(execTime.Milliseconds()))
+		failure := &proxyError{}
+		rec := &statusRecorder{ResponseWriter: w}
+		defer routeMetrics.transferred(requestBytes, rec)
+		// The reverse proxy aborts the response when the backend body fails after the headers are sent.
+		// The route timeout bounds the whole exchange, a body streamed too slowly is aborted as well.
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					if errors.Is(ctx.Err(), context.DeadlineExceeded) && r.Context().Err() == nil {
+						report(http.StatusGatewayTimeout, logrus.Fields{"timeout": item.Timeout.String()}, "Response aborted, the route timeout expired while the backend body was copied")
+					} else {
+						report(rec.status, nil, "Response aborted, the backend body could not be copied")
+					}
+				}
+				panic(err)
 			}
+		}()
+		proxy.ServeHTTP(rec, r.WithContext(context.WithValue(ctx, proxyErrorKey{}, failure)))
+
+		if requestBody != nil {
+			logrus.WithFields(logrus.Fields{
+				"label":         label,
+				"method":        r.Method,
+				"uri":           r.RequestURI,
+				"requestid":     id,
+				"status":        rec.status,
+				"request-body":  requestBody.String(),
+				"response-body": responseBody.String(),
+			}).Info("Request and response bodies")
+		}
+
+		if failure.status == statusClientClosedRequest {
+			report(failure.status, nil, "Client closed the request")
+			return
+		}
+		if failure.err != nil {
+			report(failure.status, nil, fmt.Sprintf("Execution error %v", failure.err.Error()))
+			return
 		}
 
 		execTime := time.Since(start)
+		message := fmt.Sprintf("Execution time %v", execTime)
+		if rec.status == http.StatusSwitchingProtocols {
+			message = fmt.Sprintf("WebSocket session closed after %v", execTime)
+		}
 		logrus.WithFields(logrus.Fields{
 			"label":      label,
 			"method":     r.Method,
 			"uri":        r.RequestURI,
 			"user-agent": r.UserAgent(),
 			"requestid":  id,
-		}).Infof("Execution time %v", execTime)
-		if responseTimeCollector != nil {
-			responseTimeCollector.Collect(r.Method, r.RequestURI, strconv.Itoa(http.StatusOK), float64(execTime.Milliseconds()))
+		}).Info(message)
+		routeMetrics.completed(r, rec.status, execTime)
+	}, nil
+}
+
+func DeniedHandler(routeMetrics *RouteMetrics, response *RateLimitResponseConfiguration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		markRateLimited(r)
+		routeMetrics.rateLimited()
+		if response == nil {
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
 		}
+		w.Header().Set("Content-Type", response.contentType())
+		w.WriteHeader(http.StatusTooManyRequests)
+		io.WriteString(w, response.Body)
+	})
+}
+
+// MethodFilterHandler answers 405 to the requests whose method is not allowed on the route
+func MethodFilterHandler(methods []string, next http.Handler) http.Handler {
+	if len(methods) == 0 {
+		return next
 	}
+
+	allow := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isParamAuthorized(r.Method, methods) {
+			w.Header().Set("Allow", allow)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
-func DeniedHandler(requestDeniedCounter prometheus.Counter) http.Handler {
+// NotFoundHandler answers the unmatched paths of a default route without backend
+func NotFoundHandler(routeMetrics *RouteMetrics, response *NotFoundResponseConfiguration) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestDeniedCounter.Inc()
-		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		start := time.Now()
+		defer routeMetrics.started()()
+		writeNotFound(w, response)
+		routeMetrics.completed(r, http.StatusNotFound, time.Since(start))
 	})
 }
 
-func LoadGateway(mux *http.ServeMux, store *memstore.MemStore, items []GatewayItem, metrics bool, ipConfig IpConfiguration) {
+func LoadGateway(ctx context.Context, router *Router, registry *prometheus.Registry, store throttled.GCRAStore, client *http.Client, accessLogger *logrus.Logger, tracer trace.Tracer, summary *RateLimitSummary, maintenance *Maintenance, items []GatewayItem, metrics bool, ipConfig IpConfiguration) error {
 	for _, i := range items {
-		var requestTotalCounter prometheus.Counter
-		var requestDeniedCounter prometheus.Counter
-		var responseTimeCollector *ResponseTime
+		var routeMetrics *RouteMetrics
+		if i.metricsEnabled(metrics) {
+			routeMetrics = NewRouteMetrics(registry, i.Label, i.MetricsBuckets)
+		}
 
-		if metrics {
-			re, err := regexp.Compile(`\W`)
+		var handler http.Handler
+		if len(i.backendURLs()) == 0 {
+			handler = NotFoundHandler(routeMetrics, i.notFoundResponse)
+		} else {
+			proxyHandler, err := RPHandler(ctx, i, client, routeMetrics, ipConfig)
 			if err != nil {
-				log.Fatal(err)
+				return fmt.Errorf("route %s: %w", i.Frontend, err)
 			}
-			transformed := re.ReplaceAllString(i.Label, "")
-			metricLabel := strings.ToLower(transformed)
-
-			requestTotalCounter = prometheus.NewCounter(prometheus.CounterOpts{
-				Name: fmt.Sprintf("%s_requests_total", metricLabel),
-				Help: fmt.Sprintf("The total number of requests received by the %s endpoint.", metricLabel),
-			})
-			prometheus.MustRegister(requestTotalCounter)
-
-			requestDeniedCounter = prometheus.NewCounter(prometheus.CounterOpts{
-				Name: fmt.Sprintf("%s_requests_denied", metricLabel),
-				Help: fmt.Sprintf("The total number of denied requests received by the %s endpoint.", metricLabel),
-			})
-			prometheus.MustRegister(requestDeniedCounter)
-
-			responseTimeCollector = NewResponseTime(metricLabel)
+			handler = CompressionHandler(i.Compression, CacheHandler(NewResponseCache(i.Cache), i.Label, routeMetrics, proxyHandler))
 		}
-
-		if i.MaxReqPerSec == 0 {
-			mux.Handle(i.Frontend, http.HandlerFunc(RPHandler(i.Label, i.Backend, requestTotalCounter, responseTimeCollector, ipConfig, i.Headers, i.QueryParams)))
-		} else {
-			quota := throttled.RateQuota{MaxRate: throttled.PerSec(i.MaxReqPerSec), MaxBurst: i.MaxBurst}
+		if i.rateLimited() {
+			quota, err := i.rateQuota()
+			if err != nil {
+				return fmt.Errorf("route %s: %w", i.Frontend, err)
+			}
 			rateLimiter, err := throttled.NewGCRARateLimiter(store, quota)
 			if err != nil {
-				log.Fatal(err)
+				return fmt.Errorf("route %s: %w", i.Frontend, err)
 			}
 
+			varyBy, err := newRouteVaryBy(i)
+			if err != nil {
+				return fmt.Errorf("route %s: %w", i.Frontend, err)
+			}
+
+			counter := summary.route(i)
 			httpRateLimiter := throttled.HTTPRateLimiter{
 				RateLimiter:   rateLimiter,
-				VaryBy:        &throttled.VaryBy{Path: true},
-				DeniedHandler: DeniedHandler(requestDeniedCounter),
+				VaryBy:        varyBy,
+				DeniedHandler: counter.countRejected(DeniedHandler(routeMetrics, i.RateLimitResponse)),
+			}
+			limited := httpRateLimiter.RateLimit(counter.countAllowed(RateLimitCostHandler(i, rateLimiter, varyBy, handler)))
+			if i.RateLimitBypass != nil {
+				limited = RateLimitSwitch(limited, handler)
 			}
+			handler = limited
+		}
 
-			mux.Handle(i.Frontend, httpRateLimiter.RateLimit(http.HandlerFunc(RPHandler(i.Label, i.Backend, requestTotalCounter, responseTimeCollector, ipConfig, i.Headers, i.QueryParams))))
+		// Preflight requests, disallowed methods, blocked IPs and unauthenticated requests are answered before the rate limiter
+		handler = IPFilterHandler(i, BasicAuthHandler(i.BasicAuth, APIKeyHandler(i.APIKey, handler)))
+		handler = RateLimitBypassHandler(i.RateLimitBypass, handler)
+		handler, err := ErrorPageHandler(i, TrailingSlashHandler(i.TrailingSlash, MaintenanceHandler(maintenance.route(i), i.Maintenance, CORSHandler(i.CORS, MethodFilterHandler(i.Methods, handler)))))
+		if err != nil {
+			return fmt.Errorf("route %s: %w", i.Frontend, err)
 		}
+		router.Handle(i, TracingHandler(tracer, i, AccessLogHandler(accessLogger, i.Label, handler)))
 	}
+	return nil
 }
 
-func main() {
-	logrus.SetFormatter(&logrus.JSONFormatter{})
+func resolveConfigPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if envValue := os.Getenv("ICE_CONFIG"); envValue != "" {
+		return envValue
+	}
+	return defaultConfigPath
+}
 
+func loadConfig(path string) (Configuration, error) {
 	var config Configuration
 
-	data, err := os.ReadFile("rockhopper.yaml")
+	if path == stdinConfigPath {
+		if err := decodeConfigStdin(&config); err != nil {
+			return config, err
+		}
+	} else {
+		info, err := os.Stat(path)
+		if err != nil {
+			return config, fmt.Errorf("readfile err: %w", err)
+		}
+		if info.IsDir() {
+			err = decodeConfigDir(path, &config)
+		} else {
+			err = decodeConfigFile(path, &config)
+		}
+		if err != nil {
+			return config, err
+		}
+	}
+
+	config.applyDefaultBackendScheme()
+	if err := ValidateConfig(config); err != nil {
+		return config, fmt.Errorf("validation err: %w", err)
+	}
+	if len(config.routes()) == 0 {
+		logrus.WithField("path", path).Warn("No route is configured, every request is answered with 404 Not Found")
+	}
+
+	return config, nil
+}
+
+// buildHandler builds the routes of the configuration, their background work stops when the context is canceled
+func buildHandler(ctx context.Context, config Configuration, store throttled.GCRAStore, client *http.Client, registry *prometheus.Registry, drain *drainState) (http.Handler, error) {
+	mux := http.NewServeMux()
+	// The paths matching no route get the JSON 404 instead of the plain text one of the mux
+	router := NewRouter(UnmatchedHandler(registry, config.NotFoundResponse))
+	mux.Handle("/", router)
+
+	var tracer trace.Tracer
+	if config.Tracing.Enabled {
+		tracer = otel.Tracer(tracerName)
+	}
+	summary := NewRateLimitSummary(config.RateLimitSummaryInterval)
+	maintenance := NewMaintenance(config.routes())
+	err := LoadGateway(ctx, router, registry, store, client, NewAccessLogger(config.AccessLog), tracer, summary, maintenance, config.routes(), config.Metrics, config.Ip)
 	if err != nil {
-		log.Fatal("readfile err", err)
+		return nil, err
+	}
+	summary.Start(ctx)
+
+	if config.metricsOnGateway() {
+		mux.Handle(config.metricsPath(), metricsHandler(registry))
+	}
+
+	if path := config.configEndpointPath(); path != "" {
+		mux.Handle(path, ConfigEndpointHandler(config))
 	}
+	if path := config.statsPath(); path != "" {
+		mux.Handle(path, StatsHandler(config, registry))
+	}
+	if path := config.maintenanceEndpointPath(); path != "" {
+		mux.Handle(path, MaintenanceEndpointHandler(config, maintenance))
+	}
+
+	mux.Handle(livenessPath, LivenessHandler())
+	mux.Handle(readinessPath, ReadinessHandler(config.Health, config.routes(), drain))
 
-	err = yaml.Unmarshal(data, &config)
+	handler := requestid.HandlerWithGenerator(HeaderLimitHandler(config.MaxHeaderCount, drain.track(mux)), newRequestIDGenerator(config.requestIDHeader()))
+	return TrustedProxiesHandler(config.TrustedProxies, handler), nil
+}
+
+func printRoutes(w io.Writer, scheme string, config Configuration) {
+	host := net.JoinHostPort(config.displayHost(), config.Port)
+	fmt.Fprintln(w, "Loaded routes :")
+	for _, i := range config.Routes {
+		fmt.Fprintf(w, "%s://%s%s => %s - ratelimit: %v - burst: %v\n", scheme, host, i.Frontend, strings.Join(i.backendURLs(), ", "), i.rateLimit(), i.burst())
+	}
+	if config.DefaultRoute != nil {
+		backend := strings.Join(config.DefaultRoute.backendURLs(), ", ")
+		if backend == "" {
+			backend = "404"
+		}
+		fmt.Fprintf(w, "%s://%s/* => %s - ratelimit: %v - burst: %v\n", scheme, host, backend, config.DefaultRoute.rateLimit(), config.DefaultRoute.burst())
+	}
+}
+
+// decodeConfigFile decodes the file into the configuration, the parameters it sets replace the current ones
+func decodeConfigFile(path string, config *Configuration) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatal("unmarshal err", err)
+		return fmt.Errorf("readfile err: %w", err)
 	}
+	return decodeConfig(path, data, config)
+}
 
-	err = ValidateConfig(config)
+// decodeConfigStdin decodes the configuration piped to the service, it has no extension and is read as YAML
+func decodeConfigStdin(config *Configuration) error {
+	data, err := io.ReadAll(os.Stdin)
 	if err != nil {
-		log.Fatal("validation err", err)
+		return fmt.Errorf("readfile err: %w", err)
 	}
+	return decodeConfig("", data, config)
+}
 
-	mux := http.NewServeMux()
+func decodeConfig(path string, data []byte, config *Configuration) error {
+	document, err := parseDocument(path, data)
+	if err != nil {
+		return fmt.Errorf("unmarshal err: %w", err)
+	}
 
-	store, err := memstore.New(65536)
+	err = expandEnv(document)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("env err: %w", err)
 	}
 
-	LoadGateway(mux, store, config.Routes, config.Metrics, config.Ip)
+	if problems := unknownFields(document, reflect.TypeOf(config), ""); len(problems) > 0 {
+		return fmt.Errorf("unmarshal err: %s", strings.Join(problems, ", "))
+	}
 
-	if config.Metrics {
-		mux.Handle("/metrics", promhttp.Handler())
+	err = document.Decode(config)
+	if err != nil {
+		return fmt.Errorf("unmarshal err: %w", err)
 	}
+	return nil
+}
 
-	srv := &http.Server{
-		Handler:      requestid.Handler(mux),
-		Addr:         fmt.Sprintf(":%s", config.Port),
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+// checkConfig loads and validates the configuration, then prints the routes it defines
+func checkConfig(path string, w io.Writer) error {
+	config, err := loadConfig(path)
+	if err != nil {
+		return err
 	}
 
-	fmt.Printf("🐧 ice-flow-limiter service is running http://127.0.0.1:%s\n", config.Port)
-	fmt.Println("Loaded routes :")
-	for _, i := range config.Routes {
-		fmt.Printf("http://127.0.0.1:%s%s => %s - ratelimit: %v - burst: %v\n", config.Port, i.Frontend, i.Backend, i.MaxReqPerSec, i.MaxBurst)
+	scheme := "http"
+	if config.TLS.enabled() {
+		scheme = "https"
+		if _, err := tls.LoadX509KeyPair(config.TLS.CertFile, config.TLS.KeyFile); err != nil {
+			return fmt.Errorf("tls err: %w", err)
+		}
+	}
+
+	if path == stdinConfigPath {
+		path = "from stdin"
+	}
+	fmt.Fprintf(w, "Configuration %s is valid\n", path)
+	printRoutes(w, scheme, config)
+	return nil
+}
+
+// handleSignals reloads the configuration on SIGHUP. The first SIGINT or SIGTERM cancels the context
+// to shut down gracefully, a second one exits without waiting.
+func handleSignals(signals <-chan os.Signal, server *Server, configPath string, cancel context.CancelFunc, exit func(int)) {
+	stopping := false
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			if stopping {
+				logrus.WithField("signal", sig.String()).Warn("Signal received again, exiting now")
+				exit(1)
+				return
+			}
+			logrus.WithField("signal", sig.String()).Info("Signal received")
+			stopping = true
+			cancel()
+			continue
+		}
+		if configPath == stdinConfigPath {
+			logrus.Warn("The configuration read from stdin cannot be reloaded")
+			continue
+		}
+		logrus.WithField("path", configPath).Info("Reloading configuration")
+		if err := server.Reload(configPath); err != nil {
+			logrus.Errorf("Configuration reload failed, keeping the current configuration: %v", err)
+		}
+	}
+}
+
+func main() {
+	configFlag := flag.String("config", "", fmt.Sprintf("path to the configuration file, - to read it from stdin (default %q, or $ICE_CONFIG)", defaultConfigPath))
+	checkFlag := flag.Bool("check", false, "validate the configuration and print the routes, without starting the service")
+	versionFlag := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+
+	if *versionFlag {
+		fmt.Println(versionString())
+		return
+	}
+
+	configPath := resolveConfigPath(*configFlag)
+	if *checkFlag {
+		if err := checkConfig(configPath, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+
+	logrus.WithField("path", configPath).Info("Loading configuration")
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := setupLogging(config.Log); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := ProbeBackends(context.Background(), config); err != nil {
+		logrus.Fatal(err)
+	}
+
+	server, err := NewServer(config)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	scheme := "http"
+	if config.TLS.enabled() {
+		scheme = "https"
+	}
+	fmt.Printf("🐧 ice-flow-limiter service is running %s://%s\n", scheme, net.JoinHostPort(config.displayHost(), config.Port))
+	printRoutes(os.Stdout, scheme, config)
+	if server.metricsSrv != nil {
+		fmt.Printf("Metrics are served on %s%s\n", server.metricsSrv.Addr, config.metricsPath())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	go handleSignals(signals, server, configPath, cancel, os.Exit)
+
+	if err := server.Run(ctx); err != nil && err != http.ErrServerClosed {
+		logrus.Fatal(err)
 	}
-	log.Fatal(srv.ListenAndServe())
 }
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestMain(m *testing.M) {
+	logOutput.set(io.Discard)
+	logrus.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// writeTestConfig writes the configuration in a file of the test directory, with the given name
+func writeTestConfig(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// loadTestConfig loads and validates a YAML configuration like the service does
+func loadTestConfig(t *testing.T, content string) Configuration {
+	t.Helper()
+	config, err := loadConfig(writeTestConfig(t, "config.yaml", content))
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	return config
+}
+
+// buildTestHandler builds the routes of the configuration, their background work stops at the end of the test
+func buildTestHandler(t *testing.T, config Configuration) (http.Handler, *prometheus.Registry) {
+	t.Helper()
+	store, err := NewStore(config.Store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	registry := NewRegistry()
+	handler, err := buildHandler(ctx, config, store, NewHTTPClient(config.Transport), registry, &drainState{})
+	if err != nil {
+		t.Fatalf("buildHandler: %v", err)
+	}
+	return handler, registry
+}
+
+// startTestGateway serves the routes of the configuration until the end of the test
+func startTestGateway(t *testing.T, config Configuration) (*httptest.Server, *prometheus.Registry) {
+	t.Helper()
+	handler, registry := buildTestHandler(t, config)
+	gateway := httptest.NewServer(handler)
+	t.Cleanup(gateway.Close)
+	return gateway, registry
+}
+
+// serve sends the request to the handler and returns the recorded response
+func serve(handler http.Handler, method string, target string, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if remoteAddr != "" {
+		req.RemoteAddr = remoteAddr
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// metricValue sums the values of the metric with the labels, the histograms count their observations
+func metricValue(t *testing.T, registry *prometheus.Registry, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total float64
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for labelName, labelValue := range labels {
+				if !hasLabel(metric, labelName, labelValue) {
+					continue metrics
+				}
+			}
+			switch {
+			case metric.Counter != nil:
+				total += metric.Counter.GetValue()
+			case metric.Gauge != nil:
+				total += metric.Gauge.GetValue()
+			case metric.Histogram != nil:
+				total += float64(metric.Histogram.GetSampleCount())
+			}
+		}
+	}
+	return total
+}
+
+// newTestGateway serves the routes of a YAML configuration until the end of the test
+func newTestGateway(t *testing.T, content string) *httptest.Server {
+	t.Helper()
+	gateway, _ := startTestGateway(t, loadTestConfig(t, content))
+	return gateway
+}
+
+// newTestBackend starts a backend closed at the end of the test
+func newTestBackend(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(handler)
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// get sends a GET request to the url and returns the response with its body read
+func get(t *testing.T, url string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	return do(t, req)
+}
+
+func do(t *testing.T, req *http.Request) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestResolveConfigPath(t *testing.T) {
+	tests := []struct {
+		name string
+		flag string
+		env  string
+		want string
+	}{
+		{name: "default", want: defaultConfigPath},
+		{name: "env", env: "/etc/env.yaml", want: "/etc/env.yaml"},
+		{name: "flag", flag: "/etc/flag.yaml", want: "/etc/flag.yaml"},
+		{name: "flag over env", flag: "/etc/flag.yaml", env: "/etc/env.yaml", want: "/etc/flag.yaml"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("ICE_CONFIG", test.env)
+			if got := resolveConfigPath(test.flag); got != test.want {
+				t.Errorf("resolveConfigPath(%q) = %q, want %q", test.flag, got, test.want)
+			}
+		})
+	}
+}
+
+// withStdin replaces the standard input with the content until the end of the test
+func withStdin(t *testing.T, content string) {
+	t.Helper()
+	stdin := writeTestConfig(t, "stdin", content)
+	file, err := os.Open(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := os.Stdin
+	os.Stdin = file
+	t.Cleanup(func() {
+		os.Stdin = previous
+		file.Close()
+	})
+}
+
+func TestLoadConfigFromStdin(t *testing.T) {
+	withStdin(t, `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    label: "tweets"
+`)
+	config, err := loadConfig(stdinConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Routes) != 1 || config.Routes[0].Frontend != "/tweets" || config.Routes[0].Backend != "http://localhost:8888" {
+		t.Errorf("got the routes %+v, want the /tweets route read from stdin", config.Routes)
+	}
+}
+
+func TestCheckConfigFromStdin(t *testing.T) {
+	withStdin(t, `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`)
+	var output strings.Builder
+	if err := checkConfig(stdinConfigPath, &output); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output.String(), "Configuration from stdin is valid\n") {
+		t.Errorf("unexpected output:\n%s", output.String())
+	}
+}
+
+func TestStdinConfigIsNotReloaded(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(logrus.LevelHooks{})
+	signals := make(chan os.Signal, 1)
+	signals <- syscall.SIGHUP
+	close(signals)
+	handleSignals(signals, nil, stdinConfigPath, func() {}, func(int) { t.Error("exited on SIGHUP") })
+
+	if entry := hook.LastEntry(); entry == nil || entry.Message != "The configuration read from stdin cannot be reloaded" {
+		t.Errorf("got the log %v, want the reload to be skipped", entry)
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	_, err := loadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+// validateTestConfig decodes the YAML configuration and returns the error of its validation
+func validateTestConfig(t *testing.T, content string) error {
+	t.Helper()
+	var config Configuration
+	if err := decodeConfig("", []byte(content), &config); err != nil {
+		t.Fatalf("decodeConfig: %v", err)
+	}
+	config.applyDefaultBackendScheme()
+	return ValidateConfig(config)
+}
+
+// assertProblems checks the validation error reports each problem
+func assertProblems(t *testing.T, err error, problems ...string) {
+	t.Helper()
+	if len(problems) == 0 {
+		if err != nil {
+			t.Errorf("unexpected validation error: %v", err)
+		}
+		return
+	}
+	if err == nil {
+		t.Fatalf("expected the problems %q, the configuration is valid", problems)
+	}
+	for _, problem := range problems {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("the validation error does not report %q:\n%v", problem, err)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		problems []string
+	}{
+		{
+			name: "valid",
+			config: `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888/tweets"
+    reqsPerSec: 10
+`,
+		},
+		{
+			name:     "no route",
+			config:   `port: "8000"`,
+			problems: []string{"no route is configured"},
+		},
+		{
+			name: "missing frontend and backend",
+			config: `
+routes:
+  - label: "tweets"
+`,
+			problems: []string{"routes[0] (): frontend is required", "routes[0] (): backend is required"},
+		},
+		{
+			name: "relative frontend",
+			config: `
+routes:
+  - frontend: "tweets"
+    backend: "http://localhost:8888"
+`,
+			problems: []string{`frontend "tweets" must start with /`},
+		},
+		{
+			name: "invalid backend",
+			config: `
+routes:
+  - frontend: "/a"
+    backend: "ftp://localhost"
+  - frontend: "/b"
+    backend: "http://"
+`,
+			problems: []string{`routes[0] (/a): backend "ftp://localhost" must use the http or https scheme`, `routes[1] (/b): backend "http://" has no host`},
+		},
+		{
+			name: "negative limits",
+			config: `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    reqsPerSec: -1
+    burst: -2
+`,
+			problems: []string{"reqsPerSec must be positive", "burst must be positive, got -2"},
+		},
+		{
+			name: "duplicate frontend",
+			config: `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+  - frontend: "/tweets"
+    backend: "http://localhost:8889"
+`,
+			problems: []string{"routes[1] (/tweets): frontend already used by routes[0]"},
+		},
+		{
+			name: "whitelist and blacklist",
+			config: `
+ip:
+  whitelist: ["10.0.0.1"]
+  blacklist: ["10.0.0.2"]
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`,
+			problems: []string{"ip whitelisting and blacklisting cannot be used at the same time"},
+		},
+		{
+			name: "unknown store",
+			config: `
+store:
+  type: "memcached"
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`,
+			problems: []string{`unknown store type "memcached"`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assertProblems(t, validateTestConfig(t, test.config), test.problems...)
+		})
+	}
+}
+
+func TestBackendURLs(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		backends []string
+		err      string
+	}{
+		{"http", `backend: "http://localhost:9000"`, []string{"http://localhost:9000"}, ""},
+		{"https", `backend: "https://api.example.com/v1"`, []string{"https://api.example.com/v1"}, ""},
+		{"scheme-less", `backend: "localhost:9000"`, nil,
+			`backend "localhost:9000" has no scheme, use "http://localhost:9000" or set defaultBackendScheme`},
+		{"scheme-less with defaultBackendScheme", "backends: [\"localhost:9000\", \"http://localhost:9001\"]\ndefaultBackendScheme: https",
+			[]string{"https://localhost:9000", "http://localhost:9001"}, ""},
+		{"invalid defaultBackendScheme", "backend: \"localhost:9000\"\ndefaultBackendScheme: ftp", nil,
+			`defaultBackendScheme must be http or https, got "ftp"`},
+		{"malformed", `backend: "http://[::1"`, nil, `backend "http://[::1" is not a valid URL`},
+		{"unsupported scheme", `backend: "ws://localhost:9000"`, nil, `backend "ws://localhost:9000" must use the http or https scheme`},
+		{"no host", `backend: "http:///tweets"`, nil, `backend "http:///tweets" has no host`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The route parameters are indented under the route, the global ones are moved to the top level
+			route, global, _ := strings.Cut(test.config, "\n")
+			config, err := loadConfig(writeTestConfig(t, "config.yaml", fmt.Sprintf(`%s
+routes:
+  - frontend: "/tweets"
+    %s
+`, global, route)))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("got the error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := config.Routes[0].backendURLs(); fmt.Sprint(got) != fmt.Sprint(test.backends) {
+				t.Errorf("got the backends %v, want %v", got, test.backends)
+			}
+		})
+	}
+}
+
+func TestEmptyRoutes(t *testing.T) {
+	for name, config := range map[string]string{
+		"missing routes": `port: "8000"`,
+		"null routes":    "routes:\n",
+		"empty routes":   "routes: []\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadConfig(writeTestConfig(t, "config.yaml", config))
+			if err == nil || !strings.Contains(err.Error(), "no route is configured, add routes or a defaultRoute") {
+				t.Errorf("got the error %v, want the empty routes rejected", err)
+			}
+		})
+	}
+
+	t.Run("allowEmptyRoutes", func(t *testing.T) {
+		hook := logtest.NewGlobal()
+		defer logrus.StandardLogger().ReplaceHooks(logrus.LevelHooks{})
+		config := loadTestConfig(t, "allowEmptyRoutes: true\nroutes: []\n")
+		if entry := hook.LastEntry(); entry == nil || entry.Level != logrus.WarnLevel || !strings.Contains(entry.Message, "No route is configured") {
+			t.Errorf("no warning logged for the empty routes: %v", entry)
+		}
+		gateway, _ := startTestGateway(t, config)
+		if resp, _ := get(t, gateway.URL+"/tweets", nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("got %d, want 404", resp.StatusCode)
+		}
+	})
+}
+
+func TestCheckConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		output []string
+		err    string
+	}{
+		{
+			name: "valid",
+			config: `
+port: "8000"
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888/tweets"
+    reqsPerSec: 10
+    burst: 5
+`,
+			output: []string{"is valid", "http://127.0.0.1:8000/tweets => http://localhost:8888/tweets - ratelimit: 10 - burst: 5"},
+		},
+		{
+			name: "invalid",
+			config: `
+routes:
+  - frontend: "tweets"
+    backend: "http://localhost:8888"
+`,
+			err: `frontend "tweets" must start with /`,
+		},
+		{
+			name: "missing certificate",
+			config: `
+tls:
+  certFile: "/missing/cert.pem"
+  keyFile: "/missing/key.pem"
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`,
+			err: "tls err",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output strings.Builder
+			err := checkConfig(writeTestConfig(t, "config.yaml", test.config), &output)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got the error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range test.output {
+				if !strings.Contains(output.String(), line) {
+					t.Errorf("the output does not contain %q:\n%s", line, output.String())
+				}
+			}
+		})
+	}
+}
+
+func TestMethodFilter(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    methods: ["GET", "POST"]
+`, okBackend(t)))
+
+	tests := []struct {
+		method string
+		status int
+		allow  string
+	}{
+		{method: http.MethodGet, status: http.StatusOK},
+		{method: http.MethodPost, status: http.StatusOK},
+		{method: http.MethodDelete, status: http.StatusMethodNotAllowed, allow: "GET, POST"},
+		{method: http.MethodPut, status: http.StatusMethodNotAllowed, allow: "GET, POST"},
+	}
+	for _, test := range tests {
+		t.Run(test.method, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, gateway.URL+"/tweets", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, _ := do(t, req)
+			if resp.StatusCode != test.status || resp.Header.Get("Allow") != test.allow {
+				t.Errorf("got %d with Allow %q, want %d with Allow %q", resp.StatusCode, resp.Header.Get("Allow"), test.status, test.allow)
+			}
+		})
+	}
+}
+
+func TestLoadGatewayInvalidQuota(t *testing.T) {
+	burst := -1
+	tests := []struct {
+		name string
+		item GatewayItem
+		err  string
+	}{
+		{name: "negative burst", item: GatewayItem{Frontend: "/tweets", Backend: "http://localhost:8888", MaxReqPerSec: 1, MaxBurst: &burst}, err: "route /tweets: invalid RateQuota"},
+		{name: "invalid rate", item: GatewayItem{Frontend: "/tweets", Backend: "http://localhost:8888", Rate: "ten/s"}, err: `route /tweets: rate "ten/s" must start with a positive count`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The configuration is not validated, so that the invalid quota reaches the rate limiter
+			config := Configuration{Routes: []GatewayItem{test.item}}
+			store, err := NewStore(config.Store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			_, err = buildHandler(ctx, config, store, NewHTTPClient(config.Transport), NewRegistry(), &drainState{})
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("got the error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultMaintenanceEndpointPath = "/maintenance"
+	defaultMaintenanceContentType  = "application/json"
+	defaultMaintenanceBody         = `{"status":503,"error":"Service Unavailable"}`
+)
+
+// MaintenanceConfiguration takes a route offline, its requests are answered 503 without calling the backend
+type MaintenanceConfiguration struct {
+	Enabled     bool   `yaml:"enabled"`
+	Body        string `yaml:"body"`
+	ContentType string `yaml:"contentType"`
+}
+
+func (config *MaintenanceConfiguration) contentType() string {
+	if config == nil || config.ContentType == "" {
+		return defaultMaintenanceContentType
+	}
+	return config.ContentType
+}
+
+func (config *MaintenanceConfiguration) body() string {
+	if config == nil || config.Body == "" {
+		return defaultMaintenanceBody
+	}
+	return config.Body
+}
+
+type MaintenanceEndpointConfiguration struct {
+	Path      string                  `yaml:"path"`
+	BasicAuth *BasicAuthConfiguration `yaml:"basicAuth"`
+	APIKey    *APIKeyConfiguration    `yaml:"apiKey"`
+}
+
+// maintenanceEndpointPath returns the path of the maintenance endpoint, or an empty string when it is disabled
+func (config Configuration) maintenanceEndpointPath() string {
+	if config.MaintenanceEndpoint == nil {
+		return ""
+	}
+	if config.MaintenanceEndpoint.Path == "" {
+		return defaultMaintenanceEndpointPath
+	}
+	return config.MaintenanceEndpoint.Path
+}
+
+func validateMaintenanceEndpoint(config Configuration) []string {
+	var problems []string
+	endpoint := config.MaintenanceEndpoint
+	if endpoint == nil {
+		return problems
+	}
+	path := config.maintenanceEndpointPath()
+	if !strings.HasPrefix(path, "/") {
+		problems = append(problems, fmt.Sprintf("maintenanceEndpoint.path %q must start with /", endpoint.Path))
+	}
+	if path == livenessPath || path == readinessPath || path == config.configEndpointPath() || path == config.statsPath() || (config.metricsOnGateway() && path == config.metricsPath()) {
+		problems = append(problems, fmt.Sprintf("maintenanceEndpoint.path %q is reserved by the gateway", endpoint.Path))
+	}
+	// The endpoint takes routes offline, it is never served anonymously
+	if endpoint.BasicAuth == nil && endpoint.APIKey == nil {
+		problems = append(problems, "maintenanceEndpoint requires basicAuth or apiKey")
+	}
+	for _, problem := range append(validateBasicAuth(endpoint.BasicAuth), validateAPIKey(endpoint.APIKey)...) {
+		problems = append(problems, "maintenanceEndpoint: "+problem)
+	}
+	return problems
+}
+
+type maintenanceRoute struct {
+	label    string
+	frontend string
+	enabled  atomic.Bool
+}
+
+// Maintenance holds the maintenance mode of the routes, enabled by the configuration or at runtime
+// by the maintenance endpoint. A reload applies the configuration again.
+type Maintenance struct {
+	routes []*maintenanceRoute
+	byKey  map[string]*maintenanceRoute
+}
+
+func NewMaintenance(items []GatewayItem) *Maintenance {
+	maintenance := &Maintenance{byKey: make(map[string]*maintenanceRoute, len(items))}
+	for _, item := range items {
+		route := &maintenanceRoute{label: item.Label, frontend: item.Frontend}
+		route.enabled.Store(item.Maintenance != nil && item.Maintenance.Enabled)
+		maintenance.routes = append(maintenance.routes, route)
+		maintenance.byKey[item.matchKey()] = route
+	}
+	return maintenance
+}
+
+func (m *Maintenance) route(item GatewayItem) *maintenanceRoute {
+	if m == nil {
+		return nil
+	}
+	return m.byKey[item.matchKey()]
+}
+
+// set changes the maintenance mode of the routes of the frontend, it returns false when no route has this frontend
+func (m *Maintenance) set(frontend string, enabled bool) bool {
+	found := false
+	for _, route := range m.routes {
+		if route.frontend == frontend {
+			route.enabled.Store(enabled)
+			found = true
+		}
+	}
+	return found
+}
+
+type maintenanceStatus struct {
+	Label       string `json:"label"`
+	Frontend    string `json:"frontend"`
+	Maintenance bool   `json:"maintenance"`
+}
+
+func (m *Maintenance) status() []maintenanceStatus {
+	statuses := make([]maintenanceStatus, 0, len(m.routes))
+	for _, route := range m.routes {
+		statuses = append(statuses, maintenanceStatus{Label: route.label, Frontend: route.frontend, Maintenance: route.enabled.Load()})
+	}
+	return statuses
+}
+
+// MaintenanceHandler answers 503 while the route is in maintenance
+func MaintenanceHandler(route *maintenanceRoute, response *MaintenanceConfiguration, next http.Handler) http.Handler {
+	if route == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !route.enabled.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", response.contentType())
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, response.body())
+	})
+}
+
+// MaintenanceEndpointHandler lists the maintenance mode of the routes, and changes it with
+// POST requests like ?route=/tweets&enabled=true, once the client is authenticated
+func MaintenanceEndpointHandler(config Configuration, maintenance *Maintenance) http.Handler {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPost:
+			frontend := r.URL.Query().Get("route")
+			enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+			if frontend == "" || err != nil {
+				http.Error(w, "route and enabled query params are required", http.StatusBadRequest)
+				return
+			}
+			if !maintenance.set(frontend, enabled) {
+				http.Error(w, fmt.Sprintf("no route with frontend %s", frontend), http.StatusNotFound)
+				return
+			}
+			logrus.WithFields(logrus.Fields{"frontend": frontend, "maintenance": enabled}).Warn("Maintenance mode changed")
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := json.MarshalIndent(map[string]interface{}{"routes": maintenance.status()}, "", "  ")
+		if err != nil {
+			logrus.Errorf("Failed to encode the maintenance mode: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+	return BasicAuthHandler(config.MaintenanceEndpoint.BasicAuth, APIKeyHandler(config.MaintenanceEndpoint.APIKey, handler))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	var calls atomic.Int32
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte("ok"))
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+maintenanceEndpoint:
+  apiKey:
+    keys: ["admin-key"]
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+  - frontend: "/users"
+    backend: "%[1]s"
+    label: "users"
+    maintenance:
+      enabled: true
+      body: "back soon"
+      contentType: "text/plain"
+`, backend.URL))
+	admin := http.Header{"X-API-Key": {"admin-key"}}
+	toggle := func(query string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, gateway.URL+"/maintenance?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-API-Key", "admin-key")
+		return do(t, req)
+	}
+	expect := func(path string, status int, body string) {
+		t.Helper()
+		if resp, got := get(t, gateway.URL+path, nil); resp.StatusCode != status || got != body {
+			t.Errorf("%s: got %d %q, want %d %q", path, resp.StatusCode, got, status, body)
+		}
+	}
+
+	expect("/tweets", http.StatusOK, "ok")
+	expect("/users", http.StatusServiceUnavailable, "back soon")
+	if calls.Load() != 1 {
+		t.Errorf("the backend got %d requests, want the route in maintenance to bypass it", calls.Load())
+	}
+
+	if resp, _ := toggle("route=/tweets&enabled=true"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("enabling the maintenance: got %d", resp.StatusCode)
+	}
+	expect("/tweets", http.StatusServiceUnavailable, `{"status":503,"error":"Service Unavailable"}`)
+	resp, body := toggle("route=/users&enabled=false")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("disabling the maintenance: got %d", resp.StatusCode)
+	}
+	expect("/users", http.StatusOK, "ok")
+
+	var status struct {
+		Routes []maintenanceStatus `json:"routes"`
+	}
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, body)
+	}
+	want := []maintenanceStatus{{"tweets", "/tweets", true}, {"users", "/users", false}}
+	if fmt.Sprint(status.Routes) != fmt.Sprint(want) {
+		t.Errorf("got the maintenance modes %v, want %v", status.Routes, want)
+	}
+	if resp, _ := get(t, gateway.URL+"/maintenance", admin); resp.StatusCode != http.StatusOK {
+		t.Errorf("listing the maintenance modes: got %d", resp.StatusCode)
+	}
+}
+
+func TestMaintenanceEndpointErrors(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(`
+maintenanceEndpoint:
+  apiKey:
+    keys: ["admin-key"]
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+`, okBackend(t)))
+
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		key    string
+		status int
+	}{
+		{"no API key", http.MethodPost, "route=/tweets&enabled=true", "", http.StatusUnauthorized},
+		{"missing enabled", http.MethodPost, "route=/tweets", "admin-key", http.StatusBadRequest},
+		{"unknown route", http.MethodPost, "route=/users&enabled=true", "admin-key", http.StatusNotFound},
+		{"unsupported method", http.MethodDelete, "", "admin-key", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, gateway.URL+"/maintenance?"+test.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.key != "" {
+				req.Header.Set("X-API-Key", test.key)
+			}
+			if resp, _ := do(t, req); resp.StatusCode != test.status {
+				t.Errorf("got %d, want %d", resp.StatusCode, test.status)
+			}
+		})
+	}
+	if resp, _ := get(t, gateway.URL+"/tweets", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("the failed requests changed the maintenance mode, got %d", resp.StatusCode)
+	}
+}
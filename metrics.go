@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const defaultMetricsPath = "/metrics"
+
+func (config Configuration) metricsPath() string {
+	if config.MetricsPath == "" {
+		return defaultMetricsPath
+	}
+	return config.MetricsPath
+}
+
+// metricsEnabled tells whether the metrics endpoint is served, either globally enabled or enabled by a route
+func (config Configuration) metricsEnabled() bool {
+	if config.Metrics {
+		return true
+	}
+	for _, item := range config.routes() {
+		if item.metricsEnabled(config.Metrics) {
+			return true
+		}
+	}
+	return false
+}
+
+// metricsOnGateway tells whether the metrics are served by the gateway listener
+func (config Configuration) metricsOnGateway() bool {
+	return config.metricsEnabled() && config.MetricsAddress == ""
+}
+
+// NewRegistry returns the registry of the gateway metrics, with the Go runtime and process collectors
+func NewRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	registerBuildInfo(registry)
+	return registry
+}
+
+// metricsHandler serves the metrics of the registry, with the metrics of the handler itself
+func metricsHandler(registry *prometheus.Registry) http.Handler {
+	return promhttp.InstrumentMetricHandler(registry, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+}
+
+// NewMetricsServer returns the dedicated metrics listener, or nil when the metrics are served by the gateway listener
+func NewMetricsServer(config Configuration, registry *prometheus.Registry) *http.Server {
+	if !config.metricsEnabled() || config.MetricsAddress == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(config.metricsPath(), metricsHandler(registry))
+	return &http.Server{
+		Handler: mux,
+		Addr:    config.MetricsAddress,
+	}
+}
+
+var metricLabelPattern = regexp.MustCompile(`\W`)
+
+// RouteMetrics gathers the collectors of a route. A nil RouteMetrics records nothing.
+type RouteMetrics struct {
+	requestsTotal       prometheus.Counter
+	requestsRateLimited prometheus.Counter
+	requestsDenied      prometheus.Counter
+	requestsInFlight    prometheus.Gauge
+	responsesTotal      *prometheus.CounterVec
+	responseTime        *ResponseTime
+	backendsLatency     *prometheus.GaugeVec
+	requestBytes        prometheus.Counter
+	responseBytes       prometheus.Counter
+}
+
+// metricLabel is the prefix of the metrics of the route label
+func metricLabel(label string) string {
+	return strings.ToLower(metricLabelPattern.ReplaceAllString(label, ""))
+}
+
+// validateMetricLabels checks that the routes with metrics do not share their metrics names
+func validateMetricLabels(config Configuration) []string {
+	var problems []string
+	names := make(map[string]string)
+	for index, item := range config.routes() {
+		if !item.metricsEnabled(config.Metrics) {
+			continue
+		}
+		route := fmt.Sprintf("routes[%d] (%s)", index, item.Frontend)
+		if index == len(config.Routes) {
+			route = "defaultRoute"
+		}
+
+		name := metricLabel(item.Label)
+		if name != "" && name[0] >= '0' && name[0] <= '9' {
+			problems = append(problems, fmt.Sprintf("%s: label %q cannot start with a digit, it prefixes the route metrics", route, item.Label))
+		}
+		if previous, exists := names[name]; exists {
+			problems = append(problems, fmt.Sprintf("%s: label %q has the same metrics as %s, use another label", route, item.Label, previous))
+		} else {
+			names[name] = route
+		}
+	}
+	return problems
+}
+
+func NewRouteMetrics(registry *prometheus.Registry, label string, buckets []float64) *RouteMetrics {
+	metricLabel := metricLabel(label)
+
+	return &RouteMetrics{
+		requestsTotal: registerCollector(registry, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_requests_total", metricLabel),
+			Help: fmt.Sprintf("The total number of requests received by the %s endpoint.", metricLabel),
+		})),
+		requestsRateLimited: registerCollector(registry, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_requests_rate_limited_total", metricLabel),
+			Help: fmt.Sprintf("The total number of requests rejected by the rate limiter of the %s endpoint.", metricLabel),
+		})),
+		// The name of the counter before it was renamed, kept so that the existing dashboards still work
+		requestsDenied: registerCollector(registry, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_requests_denied", metricLabel),
+			Help: fmt.Sprintf("Deprecated, use %s_requests_rate_limited_total. The total number of denied requests received by the %s endpoint.", metricLabel, metricLabel),
+		})),
+		requestsInFlight: registerCollector(registry, prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("%s_requests_in_flight", metricLabel),
+			Help: fmt.Sprintf("The number of requests of the %s endpoint currently being served.", metricLabel),
+		})),
+		responsesTotal: registerCollector(registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_responses_total", metricLabel),
+			Help: fmt.Sprintf("The total number of responses of the %s endpoint by status class.", metricLabel),
+		}, []string{"class"})),
+		responseTime: NewResponseTime(registry, metricLabel, buckets),
+		backendsLatency: registerCollector(registry, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("%s_backend_latency_ewma_seconds", metricLabel),
+			Help: fmt.Sprintf("The moving average of the time the backends of the %s endpoint take to answer the response headers.", metricLabel),
+		}, []string{"backend"})),
+		requestBytes: registerCollector(registry, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_request_bytes_total", metricLabel),
+			Help: fmt.Sprintf("The total number of bytes of the request bodies sent to the backends of the %s endpoint.", metricLabel),
+		})),
+		responseBytes: registerCollector(registry, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_response_bytes_total", metricLabel),
+			Help: fmt.Sprintf("The total number of bytes of the response bodies returned to the clients of the %s endpoint.", metricLabel),
+		})),
+	}
+}
+
+// started records an incoming request, the returned func must be called once it is served
+func (m *RouteMetrics) started() func() {
+	if m == nil {
+		return func() {}
+	}
+	m.requestsTotal.Inc()
+	m.requestsInFlight.Inc()
+	return m.requestsInFlight.Dec
+}
+
+func (m *RouteMetrics) rateLimited() {
+	if m == nil {
+		return
+	}
+	m.requestsRateLimited.Inc()
+	m.requestsDenied.Inc()
+}
+
+// countingReader counts the bytes read from the request body
+type countingReader struct {
+	io.Reader
+	count int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+// transferred records the bytes of the request body read from the client and of the response body written to it
+func (m *RouteMetrics) transferred(body *countingReader, rec *statusRecorder) {
+	if m == nil {
+		return
+	}
+	if body != nil {
+		m.requestBytes.Add(float64(body.count))
+	}
+	m.responseBytes.Add(float64(rec.written))
+}
+
+func (m *RouteMetrics) completed(r *http.Request, status int, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	m.responsesTotal.WithLabelValues(statusClass(status)).Inc()
+	m.responseTime.Collect(r.Method, r.RequestURI, strconv.Itoa(status), float64(elapsed.Milliseconds()))
+}
+
+func validateBuckets(buckets []float64) []string {
+	var problems []string
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			problems = append(problems, fmt.Sprintf("metricsBuckets must be in increasing order, got %v", buckets))
+			break
+		}
+	}
+	return problems
+}
+
+// statusClass returns the class of the status code, like 2xx or 5xx
+func statusClass(status int) string {
+	return fmt.Sprintf("%dxx", status/100)
+}
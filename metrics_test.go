@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetricsOnDedicatedListener(t *testing.T) {
+	port := freePort(t)
+	metricsAddress := "127.0.0.1:" + freePort(t)
+	config := loadTestConfig(t, fmt.Sprintf(`
+port: "%s"
+metrics: true
+metricsPath: "/internal/metrics"
+metricsAddress: "%s"
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+`, port, metricsAddress, okBackend(t)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, done := runTestServer(t, config, ctx)
+	defer func() {
+		cancel()
+		<-done
+	}()
+	waitListening(t, metricsAddress)
+
+	gateway := "http://127.0.0.1:" + port
+	get(t, gateway+"/tweets", nil)
+
+	resp, body := get(t, "http://"+metricsAddress+"/internal/metrics", nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "tweets_requests_total 1") {
+		t.Errorf("dedicated listener: got %d without tweets_requests_total 1:\n%s", resp.StatusCode, body)
+	}
+	if resp, _ := get(t, gateway+"/internal/metrics", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("gateway listener: got %d for the metrics path, want 404", resp.StatusCode)
+	}
+}
+
+func TestMetricsOnGateway(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(`
+metrics: true
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+`, okBackend(t)))
+
+	get(t, gateway.URL+"/tweets", nil)
+	resp, body := get(t, gateway.URL+defaultMetricsPath, nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "tweets_requests_total 1") {
+		t.Errorf("got %d without tweets_requests_total 1:\n%s", resp.StatusCode, body)
+	}
+}
+
+func TestRequestsInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+	gateway, registry := startTestGateway(t, loadTestConfig(t, fmt.Sprintf(`
+metrics: true
+routes:
+  - frontend: "/slow"
+    backend: "%s"
+    label: "slow"
+`, backend.URL)))
+
+	const concurrent = 3
+	done := make(chan struct{})
+	for i := 0; i < concurrent; i++ {
+		go func() {
+			if resp, err := http.Get(gateway.URL + "/slow"); err == nil {
+				resp.Body.Close()
+			}
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < concurrent; i++ {
+		<-started
+	}
+	if got := metricValue(t, registry, "slow_requests_in_flight", nil); got != concurrent {
+		t.Errorf("slow_requests_in_flight = %v while the requests are held, want %d", got, concurrent)
+	}
+
+	close(release)
+	for i := 0; i < concurrent; i++ {
+		<-done
+	}
+	// The gauge is decreased once the handler returns, which can follow the end of the response
+	deadline := time.Now().Add(time.Second)
+	for metricValue(t, registry, "slow_requests_in_flight", nil) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := metricValue(t, registry, "slow_requests_in_flight", nil); got != 0 {
+		t.Errorf("slow_requests_in_flight = %v once served, want 0", got)
+	}
+}
+
+func TestResponsesByClass(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	gateway, registry := startTestGateway(t, loadTestConfig(t, fmt.Sprintf(`
+metrics: true
+routes:
+  - frontend: "/api/"
+    backend: "%s/"
+    label: "api"
+`, backend.URL)))
+
+	for _, path := range []string{"/api/ok", "/api/ok", "/api/missing", "/api/broken"} {
+		get(t, gateway.URL+path, nil)
+	}
+	for class, want := range map[string]float64{"2xx": 2, "4xx": 1, "5xx": 1} {
+		if got := metricValue(t, registry, "api_responses_total", map[string]string{"class": class}); got != want {
+			t.Errorf("api_responses_total{class=%q} = %v, want %v", class, got, want)
+		}
+	}
+}
+
+func TestRouteMetricsOverride(t *testing.T) {
+	tests := []struct {
+		global bool
+		route  string
+		want   bool
+	}{
+		{global: true, route: "", want: true},
+		{global: true, route: "metrics: true", want: true},
+		{global: true, route: "metrics: false", want: false},
+		{global: false, route: "", want: false},
+		{global: false, route: "metrics: true", want: true},
+		{global: false, route: "metrics: false", want: false},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("global %t route %q", test.global, test.route), func(t *testing.T) {
+			gateway, registry := startTestGateway(t, loadTestConfig(t, fmt.Sprintf(`
+metrics: %t
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+    %s
+`, test.global, okBackend(t), test.route)))
+
+			get(t, gateway.URL+"/tweets", nil)
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			registered := false
+			for _, family := range families {
+				if family.GetName() == "tweets_requests_total" {
+					registered = true
+				}
+			}
+			if registered != test.want {
+				t.Errorf("tweets_requests_total registered: %t, want %t", registered, test.want)
+			}
+		})
+	}
+}
+
+// histogramBuckets returns the upper bounds of the buckets of the histogram
+func histogramBuckets(t *testing.T, registry *prometheus.Registry, name string) []float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name || len(family.GetMetric()) == 0 {
+			continue
+		}
+		var bounds []float64
+		for _, bucket := range family.GetMetric()[0].GetHistogram().GetBucket() {
+			bounds = append(bounds, bucket.GetUpperBound())
+		}
+		return bounds
+	}
+	t.Fatalf("no %s histogram", name)
+	return nil
+}
+
+func TestResponseTimeBuckets(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	responseTime := NewResponseTime(registry, "tweets", []float64{1, 2.5, 10})
+	responseTime.Collect(http.MethodGet, "/tweets", "200", 2)
+	if got := histogramBuckets(t, registry, "tweets_http_request_duration_ms"); fmt.Sprint(got) != "[1 2.5 10]" {
+		t.Errorf("got the buckets %v, want [1 2.5 10]", got)
+	}
+
+	registry = prometheus.NewRegistry()
+	NewResponseTime(registry, "users", nil).Collect(http.MethodGet, "/users", "200", 2)
+	if got := histogramBuckets(t, registry, "users_http_request_duration_ms"); fmt.Sprint(got) != fmt.Sprint(defaultResponseTimeBuckets) {
+		t.Errorf("got the buckets %v, want the defaults %v", got, defaultResponseTimeBuckets)
+	}
+}
+
+func TestRouteBucketsOverrideGlobal(t *testing.T) {
+	gateway, registry := startTestGateway(t, loadTestConfig(t, fmt.Sprintf(`
+metrics: true
+metricsBuckets: [50, 100]
+routes:
+  - frontend: "/tweets"
+    backend: "%[1]s"
+    label: "tweets"
+    metricsBuckets: [0.5, 1, 5]
+  - frontend: "/users"
+    backend: "%[1]s"
+    label: "users"
+`, okBackend(t))))
+
+	get(t, gateway.URL+"/tweets", nil)
+	get(t, gateway.URL+"/users", nil)
+	if got := histogramBuckets(t, registry, "tweets_http_request_duration_ms"); fmt.Sprint(got) != "[0.5 1 5]" {
+		t.Errorf("tweets buckets = %v, want the route ones [0.5 1 5]", got)
+	}
+	if got := histogramBuckets(t, registry, "users_http_request_duration_ms"); fmt.Sprint(got) != "[50 100]" {
+		t.Errorf("users buckets = %v, want the global ones [50 100]", got)
+	}
+}
+
+func TestBucketsValidation(t *testing.T) {
+	assertProblems(t, validateTestConfig(t, `
+metricsBuckets: [10, 5]
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`), "metricsBuckets must be in increasing order, got [10 5]")
+}
+
+func TestDuplicateMetricLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		problems []string
+	}{
+		{
+			name: "same label",
+			config: `
+metrics: true
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    label: "api"
+  - frontend: "/users"
+    backend: "http://localhost:8888"
+    label: "api"
+`,
+			problems: []string{`routes[1] (/users): label "api" has the same metrics as routes[0] (/tweets), use another label`},
+		},
+		{
+			name: "labels with the same metrics name",
+			config: `
+metrics: true
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    label: "my-api"
+  - frontend: "/users"
+    backend: "http://localhost:8888"
+    label: "My API"
+`,
+			problems: []string{`label "My API" has the same metrics as routes[0] (/tweets)`},
+		},
+		{
+			name: "same label without metrics on one route",
+			config: `
+metrics: true
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    label: "api"
+  - frontend: "/users"
+    backend: "http://localhost:8888"
+    label: "api"
+    metrics: false
+`,
+		},
+		{
+			name: "label starting with a digit",
+			config: `
+metrics: true
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    label: "2fa"
+`,
+			problems: []string{`label "2fa" cannot start with a digit`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assertProblems(t, validateTestConfig(t, test.config), test.problems...)
+		})
+	}
+}
+
+func TestIsolatedRegistries(t *testing.T) {
+	config := loadTestConfig(t, fmt.Sprintf(`
+metrics: true
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+`, okBackend(t)))
+	first, firstRegistry := startTestGateway(t, config)
+	_, secondRegistry := startTestGateway(t, config)
+
+	get(t, first.URL+"/tweets", nil)
+	get(t, first.URL+"/tweets", nil)
+	if got := metricValue(t, firstRegistry, "tweets_requests_total", nil); got != 2 {
+		t.Errorf("first registry: tweets_requests_total = %v, want 2", got)
+	}
+	if got := metricValue(t, secondRegistry, "tweets_requests_total", nil); got != 0 {
+		t.Errorf("second registry: tweets_requests_total = %v, want 0", got)
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if strings.HasPrefix(family.GetName(), "tweets_") {
+			t.Errorf("%s was registered in the default registry", family.GetName())
+		}
+	}
+}
+
+func TestRegisterCollectorReusesExisting(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	first := registerCollector(registry, prometheus.NewCounter(prometheus.CounterOpts{Name: "tweets_total"}))
+	second := registerCollector(registry, prometheus.NewCounter(prometheus.CounterOpts{Name: "tweets_total"}))
+	if first != second {
+		t.Error("the collector registered again was not the existing one")
+	}
+}
+
+func TestBytesProxied(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(strings.Repeat("r", 5000)))
+	})
+	flaky, calls := flakyBackend(t)
+	gateway, registry := startTestGateway(t, loadTestConfig(t, fmt.Sprintf(`
+metrics: true
+routes:
+  - frontend: "/upload"
+    backend: "%s"
+    label: "upload"
+  - frontend: "/retried"
+    backend: "%s"
+    label: "retried"
+    retry:
+      attempts: 2
+      backoff: 1ms
+      methods: ["PUT"]
+`, backend.URL, flaky)))
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Post(gateway.URL+"/upload", "text/plain", strings.NewReader(strings.Repeat("q", 3000)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if got := metricValue(t, registry, "upload_request_bytes_total", nil); got != 6000 {
+		t.Errorf("upload_request_bytes_total = %v, want 6000", got)
+	}
+	if got := metricValue(t, registry, "upload_response_bytes_total", nil); got != 10000 {
+		t.Errorf("upload_response_bytes_total = %v, want 10000", got)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, gateway.URL+"/retried", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, body := do(t, req); body != "PUT payload" || calls.Load() != 2 {
+		t.Fatalf("got %q after %d attempts, want the retried PUT", body, calls.Load())
+	}
+	// The body read from the client is counted once, whatever the number of attempts
+	if got := metricValue(t, registry, "retried_request_bytes_total", nil); got != 7 {
+		t.Errorf("retried_request_bytes_total = %v, want 7", got)
+	}
+	if got := metricValue(t, registry, "retried_response_bytes_total", nil); got != float64(len("PUT payload")) {
+		t.Errorf("retried_response_bytes_total = %v, want %d", got, len("PUT payload"))
+	}
+}
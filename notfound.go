@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultNotFoundContentType = "application/json"
+	defaultNotFoundBody        = `{"status":404,"error":"Not Found"}`
+)
+
+// NotFoundResponseConfiguration is the response answered to the requests matching no route
+type NotFoundResponseConfiguration struct {
+	Body        string `yaml:"body"`
+	ContentType string `yaml:"contentType"`
+}
+
+func (config *NotFoundResponseConfiguration) contentType() string {
+	if config == nil || config.ContentType == "" {
+		return defaultNotFoundContentType
+	}
+	return config.ContentType
+}
+
+func (config *NotFoundResponseConfiguration) body() string {
+	if config == nil || config.Body == "" {
+		return defaultNotFoundBody
+	}
+	return config.Body
+}
+
+func writeNotFound(w http.ResponseWriter, response *NotFoundResponseConfiguration) {
+	w.Header().Set("Content-Type", response.contentType())
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusNotFound)
+	io.WriteString(w, response.body())
+}
+
+// UnmatchedHandler answers the paths matching no route and counts them
+func UnmatchedHandler(registry *prometheus.Registry, response *NotFoundResponseConfiguration) http.Handler {
+	unmatched := registerCollector(registry, prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ice_flow_limiter_unmatched_requests_total",
+		Help: "The total number of requests matching no route.",
+	}))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unmatched.Inc()
+		writeNotFound(w, response)
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestUnmatchedRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		contentType string
+		body        string
+	}{
+		{"default body", ``, "application/json", `{"status":404,"error":"Not Found"}`},
+		{"custom body", `
+notFoundResponse:
+  body: '{"code":"route_not_found"}'
+`, "application/json", `{"code":"route_not_found"}`},
+		{"custom content type", `
+notFoundResponse:
+  body: 'no route'
+  contentType: "text/plain"
+`, "text/plain", `no route`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gateway, registry := startTestGateway(t, loadTestConfig(t, fmt.Sprintf(`%s
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+`, test.config, okBackend(t))))
+
+			for i := 0; i < 2; i++ {
+				resp, body := get(t, gateway.URL+"/unknown", nil)
+				if resp.StatusCode != http.StatusNotFound || body != test.body {
+					t.Fatalf("got %d %q, want 404 %q", resp.StatusCode, body, test.body)
+				}
+				if got := resp.Header.Get("Content-Type"); got != test.contentType {
+					t.Errorf("Content-Type = %q, want %q", got, test.contentType)
+				}
+			}
+			get(t, gateway.URL+"/tweets", nil)
+			if got := metricValue(t, registry, "ice_flow_limiter_unmatched_requests_total", nil); got != 2 {
+				t.Errorf("counted %v unmatched requests, want 2", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kataras/requestid"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	errNoBackend          = errors.New("no backend available")
+	errServiceUnavailable = errors.New("backend answered 503 Service Unavailable")
+)
+
+type RewriteConfiguration struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+type proxyErrorKey struct{}
+
+// proxyError holds the failure reported by the reverse proxy for a request
+type proxyError struct {
+	status int
+	err    error
+}
+
+// routeTransport sends each attempt of a request to the next backend of the route,
+// retrying failed attempts when the route allows it.
+type routeTransport struct {
+	item     GatewayItem
+	balancer Balancer
+	base     http.RoundTripper
+	rewrite  *regexp.Regexp
+}
+
+func (t *routeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	// Retried requests need a body that can be sent again
+	attempts := 1
+	if t.item.Retry.allows(req.Method) && (req.Body == nil || req.GetBody != nil) {
+		attempts += t.item.Retry.Attempts
+	}
+
+	var resp *http.Response
+	var err error
+	var delay time.Duration
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			logrus.WithFields(logrus.Fields{
+				"label":     t.item.Label,
+				"method":    req.Method,
+				"uri":       req.URL.RequestURI(),
+				"requestid": requestid.Get(req),
+				"attempt":   attempt,
+			}).Warnf("Retrying request after error %v", err.Error())
+			if t.item.Retry.wait(ctx, attempt-1, delay) != nil {
+				break
+			}
+			delay = 0
+		}
+
+		target := t.balancer.Next(req)
+		if target == nil {
+			return nil, errNoBackend
+		}
+
+		out := req.Clone(ctx)
+		if attempt > 0 && req.GetBody != nil {
+			if out.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		rewriteBackendURL(out, t.item, t.rewrite, target)
+
+		if !target.breaker.acquire() {
+			err = errCircuitOpen
+			continue
+		}
+
+		target.active.Add(1)
+		sent := time.Now()
+		resp, err = t.base.RoundTrip(out)
+		if err != nil {
+			target.active.Add(-1)
+			// A failed attempt counts as a slow response, so that the least time balancing moves away from the backend
+			if ctx.Err() == nil {
+				target.observeLatency(time.Since(sent) + failedAttemptLatencyPenalty)
+			}
+		} else {
+			target.observeLatency(time.Since(sent))
+			resp.Body = target.track(resp.Body)
+		}
+		// Failures of the client itself do not count against the backend
+		if ctx.Err() == nil {
+			target.breaker.record(err == nil && resp.StatusCode < http.StatusInternalServerError)
+		} else {
+			target.breaker.abort()
+		}
+
+		// The last response is answered to the client, whatever its status
+		if err == nil && attempt < attempts-1 {
+			var retry bool
+			if delay, retry = t.item.Retry.retryAfter(resp); retry {
+				resp.Body.Close()
+				resp, err = nil, errServiceUnavailable
+				continue
+			}
+			if t.buffersResponse(out, resp) {
+				if bodyErr := t.item.Retry.bufferResponse(resp); bodyErr != nil {
+					resp, err = nil, fmt.Errorf("response body err: %w", bodyErr)
+					continue
+				}
+			}
+		}
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	return resp, err
+}
+
+// buffersResponse reports whether the body of the response is read before it is answered, the streamed
+// responses are flushed to the client as they are received and cannot be retried
+func (t *routeTransport) buffersResponse(req *http.Request, resp *http.Response) bool {
+	if t.item.Streaming || t.item.Protocol == grpcProtocol || req.Method == http.MethodHead {
+		return false
+	}
+	return resp.StatusCode != http.StatusSwitchingProtocols && !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+}
+
+// rewriteBackendURL points the outgoing request to the target backend
+func rewriteBackendURL(req *http.Request, item GatewayItem, rewrite *regexp.Regexp, target *upstream) {
+	path := target.url.Path
+
+	// Manage path, the part of the path after the frontend prefix is appended to the backend,
+	// unless the route rewrites the request path
+	if rewrite != nil && rewrite.MatchString(req.URL.Path) {
+		path = joinURLPath(path, rewrite.ReplaceAllString(req.URL.Path, item.Rewrite.Replacement))
+	} else if !item.stripPrefix() {
+		path = joinURLPath(path, req.URL.Path)
+	} else if suffix := strings.TrimPrefix(req.URL.Path, item.Frontend); suffix != req.URL.Path {
+		path = joinURLPath(path, suffix)
+	}
+
+	req.URL = &url.URL{
+		Scheme:   target.url.Scheme,
+		User:     target.url.User,
+		Host:     target.url.Host,
+		Path:     path,
+		RawQuery: joinRawQuery(target.url.RawQuery, req.URL.RawQuery),
+	}
+
+	// By default the Host header is the host of the backend
+	if item.HostHeader != "" {
+		req.Host = item.HostHeader
+	} else if !item.PreserveHost {
+		req.Host = ""
+	}
+}
+
+// Hop-by-hop headers, as listed in RFC 7230, section 6.1
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopByHopHeaders removes the hop-by-hop headers and the headers listed in the Connection header
+func removeHopByHopHeaders(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+// newDirector filters the headers and query params sent to the backend.
+// Hop-by-hop headers are removed before the headers filter, so that a header listed
+// in the Connection header is never forwarded because the filter dropped Connection.
+func newDirector(item GatewayItem) func(req *http.Request) {
+	return func(req *http.Request) {
+		prior := req.Header.Values("X-Forwarded-For")
+		upgrade := req.Header.Get("Upgrade")
+		webSocket := item.WebSocket && isWebSocketRequest(req)
+
+		removeHopByHopHeaders(req.Header)
+
+		// Manage headers
+		for k := range req.Header {
+			if !isParamAuthorized(k, item.Headers) {
+				req.Header.Del(k)
+			}
+		}
+		// The request ID is always forwarded, whatever the headers filter
+		if id := requestid.Get(req); id != "" && item.requestIDHeader != "" {
+			req.Header.Set(item.requestIDHeader, id)
+		}
+		// The upgrade headers are only forwarded to websocket routes, whatever the headers filter
+		if webSocket {
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", upgrade)
+		}
+
+		// The reverse proxy appends the client IP to the chain
+		if len(prior) > 0 {
+			req.Header.Set("X-Forwarded-For", strings.Join(prior, ", "))
+		} else {
+			req.Header.Del("X-Forwarded-For")
+		}
+		proto := "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+		req.Header.Set("X-Forwarded-Proto", proto)
+		req.Header.Set("X-Forwarded-Host", req.Host)
+		// The backend continues the trace of the request, whatever the headers filter
+		injectTraceContext(req)
+
+		// Manage query parms
+		if len(item.QueryParams) > 0 {
+			query := url.Values{}
+			for k, v := range req.URL.Query() {
+				if isParamAuthorized(k, item.QueryParams) {
+					query[k] = v
+				}
+			}
+			req.URL.RawQuery = query.Encode()
+		}
+	}
+}
+
+// newResponseModifier sets the route response headers on the backend response.
+// A header already answered by the backend is kept unless the route overrides it.
+// The CORS headers of the backend are dropped when the gateway handles CORS for the route.
+func newResponseModifier(item GatewayItem) func(resp *http.Response) error {
+	return func(resp *http.Response) error {
+		// The request ID is already set on the response by the gateway
+		if item.requestIDHeader != "" {
+			resp.Header.Del(item.requestIDHeader)
+		}
+		if item.CORS != nil {
+			for name := range resp.Header {
+				if strings.HasPrefix(name, "Access-Control-") {
+					resp.Header.Del(name)
+				}
+			}
+		}
+		// The backend instance is only revealed when the route asks for it
+		if item.UpstreamHeader != "" && resp.Request != nil {
+			resp.Header.Set(item.UpstreamHeader, resp.Request.URL.Host)
+		}
+		for name, value := range item.ResponseHeaders {
+			if item.OverrideResponseHeaders || resp.Header.Get(name) == "" {
+				resp.Header.Set(name, value)
+			}
+		}
+		return nil
+	}
+}
+
+// proxyErrorHandler answers the failures of the reverse proxy and keeps them for the route logs and metrics
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	status := upstreamErrorStatus(err)
+	if errors.Is(err, errNoBackend) || errors.Is(err, errCircuitOpen) || errors.Is(err, errServiceUnavailable) {
+		status = http.StatusServiceUnavailable
+	} else if errors.Is(r.Context().Err(), context.Canceled) {
+		status = statusClientClosedRequest
+	}
+
+	if holder, ok := r.Context().Value(proxyErrorKey{}).(*proxyError); ok {
+		holder.status = status
+		holder.err = err
+	}
+	// Once the response headers are sent, the failure can only be logged
+	if rec, ok := w.(*statusRecorder); ok && rec.status != 0 {
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// NewReverseProxy builds the proxy of the route, the health checks of its backends run until the context is canceled
+func NewReverseProxy(ctx context.Context, item GatewayItem, client *http.Client, routeMetrics *RouteMetrics) (*httputil.ReverseProxy, error) {
+	upstreams, err := newUpstreams(item, routeMetrics)
+	if err != nil {
+		return nil, err
+	}
+	balancer := NewBalancer(item, upstreams)
+
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if item.Transport != nil {
+		base = newRouteTransport(ctx, *item.Transport)
+	}
+	if item.UpstreamTLS != nil {
+		if base, err = newUpstreamTLSTransport(ctx, base, *item.UpstreamTLS); err != nil {
+			return nil, err
+		}
+	}
+	if item.Protocol == grpcProtocol {
+		base = newGRPCTransport(base)
+	}
+	StartHealthChecks(ctx, item, upstreams, base)
+	if item.FollowRedirects {
+		base = newRedirectTransport(base)
+	}
+
+	var rewrite *regexp.Regexp
+	if item.Rewrite != nil {
+		if rewrite, err = regexp.Compile(item.Rewrite.Pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	// gRPC streams and streaming routes are flushed to the client as soon as data is received.
+	// Server-sent events are always flushed by the reverse proxy.
+	var flushInterval time.Duration
+	if item.Protocol == grpcProtocol || item.Streaming {
+		flushInterval = -1
+	}
+
+	return &httputil.ReverseProxy{
+		FlushInterval:  flushInterval,
+		Director:       newDirector(item),
+		Transport:      &routeTransport{item: item, balancer: balancer, base: base, rewrite: rewrite},
+		ModifyResponse: newResponseModifier(item),
+		ErrorHandler:   proxyErrorHandler,
+		ErrorLog:       log.New(logrus.StandardLogger().WriterLevel(logrus.WarnLevel), "", 0),
+	}, nil
+}
@@ -0,0 +1,865 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+// echoedRequest is the request received by the echo backend
+type echoedRequest struct {
+	Host     string      `json:"host"`
+	Path     string      `json:"path"`
+	RawQuery string      `json:"rawQuery"`
+	Header   http.Header `json:"header"`
+}
+
+// echoBackend answers the request it received as JSON
+func echoBackend(t *testing.T) string {
+	t.Helper()
+	return newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(echoedRequest{Host: r.Host, Path: r.URL.Path, RawQuery: r.URL.RawQuery, Header: r.Header})
+	}).URL
+}
+
+func getEchoed(t *testing.T, url string, header http.Header) echoedRequest {
+	t.Helper()
+	resp, body := get(t, url, header)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: got %d, want 200: %s", url, resp.StatusCode, body)
+	}
+	var echoed echoedRequest
+	if err := json.Unmarshal([]byte(body), &echoed); err != nil {
+		t.Fatalf("decode the echoed request %q: %v", body, err)
+	}
+	return echoed
+}
+
+func TestProxyQueryAndPathSuffix(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/api/"
+    backend: "%s/v1"
+    label: "api"
+  - frontend: "/search/"
+    backend: "%s/search?lang=en"
+    label: "search"
+`, echoBackend(t), echoBackend(t)))
+
+	tests := []struct {
+		url       string
+		wantPath  string
+		wantQuery string
+	}{
+		{url: "/api/tweets/42?sort=desc&page=2", wantPath: "/v1/tweets/42", wantQuery: "sort=desc&page=2"},
+		{url: "/api/", wantPath: "/v1", wantQuery: ""},
+		{url: "/api/a%20b", wantPath: "/v1/a b", wantQuery: ""},
+		{url: "/search/tweets?q=go", wantPath: "/search/tweets", wantQuery: "lang=en&q=go"},
+	}
+	for _, test := range tests {
+		echoed := getEchoed(t, gateway.URL+test.url, nil)
+		if echoed.Path != test.wantPath || echoed.RawQuery != test.wantQuery {
+			t.Errorf("GET %s: backend got %s?%s, want %s?%s", test.url, echoed.Path, echoed.RawQuery, test.wantPath, test.wantQuery)
+		}
+	}
+}
+
+func TestProxyStripPrefix(t *testing.T) {
+	backend := echoBackend(t)
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/strip/"
+    backend: "%s/base"
+    label: "strip"
+  - frontend: "/keep/"
+    backend: "%s/base"
+    label: "keep"
+    stripPrefix: false
+`, backend, backend))
+
+	if echoed := getEchoed(t, gateway.URL+"/strip/a/b", nil); echoed.Path != "/base/a/b" {
+		t.Errorf("stripped prefix: backend got %s, want /base/a/b", echoed.Path)
+	}
+	if echoed := getEchoed(t, gateway.URL+"/keep/a/b", nil); echoed.Path != "/base/keep/a/b" {
+		t.Errorf("kept prefix: backend got %s, want /base/keep/a/b", echoed.Path)
+	}
+}
+
+func TestProxyForwardedHeaders(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/api/"
+    backend: "%s"
+    label: "api"
+`, echoBackend(t)))
+
+	tests := []struct {
+		name  string
+		prior []string
+		want  string
+	}{
+		{name: "fresh request", want: "127.0.0.1"},
+		{name: "forwarded request", prior: []string{"203.0.113.7"}, want: "203.0.113.7, 127.0.0.1"},
+		{name: "several forwarded headers", prior: []string{"203.0.113.7", "198.51.100.1"}, want: "203.0.113.7, 198.51.100.1, 127.0.0.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			echoed := getEchoed(t, gateway.URL+"/api/", http.Header{"X-Forwarded-For": test.prior})
+			if got := echoed.Header.Get("X-Forwarded-For"); got != test.want {
+				t.Errorf("X-Forwarded-For = %q, want %q", got, test.want)
+			}
+			if got := echoed.Header.Get("X-Forwarded-Proto"); got != "http" {
+				t.Errorf("X-Forwarded-Proto = %q, want http", got)
+			}
+		})
+	}
+}
+
+func TestProxyForwardedProtoHTTPS(t *testing.T) {
+	handler, _ := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+routes:
+  - frontend: "/api/"
+    backend: "%s"
+    label: "api"
+`, echoBackend(t))))
+	gateway := httptest.NewTLSServer(handler)
+	defer gateway.Close()
+
+	resp, err := gateway.Client().Get(gateway.URL + "/api/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var echoed echoedRequest
+	if err := json.NewDecoder(resp.Body).Decode(&echoed); err != nil {
+		t.Fatal(err)
+	}
+	if got := echoed.Header.Get("X-Forwarded-Proto"); got != "https" {
+		t.Errorf("X-Forwarded-Proto = %q, want https", got)
+	}
+}
+
+func TestRouteTimeout(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	gateway, registry := startTestGateway(t, loadTestConfig(t, fmt.Sprintf(`
+metrics: true
+routes:
+  - frontend: "/slow"
+    backend: "%s"
+    label: "slow"
+    timeout: 100ms
+`, backend.URL)))
+
+	start := time.Now()
+	resp, _ := get(t, gateway.URL+"/slow", nil)
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("got %d, want 504", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("the timeout fired after %v", elapsed)
+	}
+	if got := metricValue(t, registry, "slow_http_request_duration_ms", map[string]string{"code": "504"}); got != 1 {
+		t.Errorf("got %v observations with code 504, want 1", got)
+	}
+	if got := metricValue(t, registry, "slow_responses_total", map[string]string{"class": "5xx"}); got != 1 {
+		t.Errorf("got %v 5xx responses, want 1", got)
+	}
+}
+
+func TestRouteTimeoutBoundsSlowBody(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 20; i++ {
+			io.WriteString(w, ".")
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(25 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	gateway, registry := startTestGateway(t, loadTestConfig(t, fmt.Sprintf(`
+metrics: true
+routes:
+  - frontend: "/slow"
+    backend: "%s"
+    label: "slow"
+    timeout: 200ms
+  - frontend: "/stream"
+    backend: "%[1]s"
+    label: "stream"
+    timeout: 200ms
+    streaming: true
+`, backend.URL)))
+
+	start := time.Now()
+	resp, err := http.Get(gateway.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err == nil {
+		t.Errorf("read the whole body of %d bytes, want it cut by the timeout", len(body))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the body was cut after %v, want about 200ms", elapsed)
+	}
+	if got := metricValue(t, registry, "slow_http_request_duration_ms", map[string]string{"code": "504"}); got != 1 {
+		t.Errorf("got %v observations with code 504, want 1", got)
+	}
+
+	// The streaming routes are not bounded by the timeout
+	if _, body := get(t, gateway.URL+"/stream", nil); len(body) != 20 {
+		t.Errorf("the stream was cut after %d bytes, want 20", len(body))
+	}
+}
+
+// closedAddress returns the URL of a port no server listens on
+func closedAddress(t *testing.T) string {
+	t.Helper()
+	backend := httptest.NewServer(http.NotFoundHandler())
+	backend.Close()
+	return backend.URL
+}
+
+func TestUnreachableBackend(t *testing.T) {
+	gateway, registry := startTestGateway(t, loadTestConfig(t, fmt.Sprintf(`
+metrics: true
+routes:
+  - frontend: "/down"
+    backend: "%s"
+    label: "down"
+`, closedAddress(t))))
+
+	resp, _ := get(t, gateway.URL+"/down", nil)
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("got %d, want 502", resp.StatusCode)
+	}
+	if got := metricValue(t, registry, "down_http_request_duration_ms", map[string]string{"code": "502"}); got != 1 {
+		t.Errorf("got %v observations with code 502, want 1", got)
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%d", len(body))
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/upload"
+    backend: "%s"
+    maxBodyBytes: 10
+`, backend.URL))
+
+	tests := []struct {
+		name    string
+		body    io.Reader
+		chunked bool
+		status  int
+	}{
+		{name: "within the limit", body: strings.NewReader("0123456789"), status: http.StatusOK},
+		{name: "content length over the limit", body: strings.NewReader("0123456789a"), status: http.StatusRequestEntityTooLarge},
+		{name: "chunked over the limit", body: io.MultiReader(strings.NewReader("01234"), strings.NewReader("56789a")), chunked: true, status: http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, gateway.URL+"/upload", test.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.chunked {
+				req.ContentLength = -1
+			}
+			resp, body := do(t, req)
+			if resp.StatusCode != test.status {
+				t.Errorf("got %d %q, want %d", resp.StatusCode, body, test.status)
+			}
+		})
+	}
+}
+
+func TestClientCancelCancelsUpstream(t *testing.T) {
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(canceled)
+		case <-time.After(5 * time.Second):
+		}
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/slow"
+    backend: "%s"
+`, backend.URL))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gateway.URL+"/slow", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		_, err := http.DefaultClient.Do(req)
+		errs <- err
+	}()
+
+	<-started
+	cancel()
+	if err := <-errs; err == nil {
+		t.Fatal("the canceled request succeeded")
+	}
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the upstream request was not canceled with the client")
+	}
+}
+
+func TestStreamedRequestBody(t *testing.T) {
+	type received struct {
+		size             int64
+		digest           [sha256.Size]byte
+		contentLength    int64
+		transferEncoding []string
+	}
+	firstChunk := make(chan struct{})
+	results := make(chan received, 1)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		hash := sha256.New()
+		buf := make([]byte, 32<<10)
+		var size int64
+		notified := false
+		for {
+			n, err := r.Body.Read(buf)
+			hash.Write(buf[:n])
+			size += int64(n)
+			if size > 0 && !notified && r.ContentLength == -1 {
+				close(firstChunk)
+				notified = true
+			}
+			if err != nil {
+				break
+			}
+		}
+		result := received{size: size, contentLength: r.ContentLength, transferEncoding: r.TransferEncoding}
+		copy(result.digest[:], hash.Sum(nil))
+		results <- result
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/upload"
+    backend: "%s"
+`, backend.URL))
+	chunk := bytes.Repeat([]byte("0123456789abcdef"), 64<<10)
+
+	t.Run("chunked", func(t *testing.T) {
+		reader, writer := io.Pipe()
+		hash := sha256.New()
+		go func() {
+			hash.Write(chunk)
+			writer.Write(chunk)
+			// The rest of the body is only sent once the backend received the first chunk
+			select {
+			case <-firstChunk:
+			case <-time.After(5 * time.Second):
+				writer.CloseWithError(fmt.Errorf("the backend did not receive the first chunk"))
+				return
+			}
+			for i := 0; i < 7; i++ {
+				hash.Write(chunk)
+				writer.Write(chunk)
+			}
+			writer.Close()
+		}()
+		resp, err := http.Post(gateway.URL+"/upload", "application/octet-stream", reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		got := <-results
+		if got.size != int64(8*len(chunk)) || !bytes.Equal(got.digest[:], hash.Sum(nil)) {
+			t.Errorf("the backend received %d bytes, want the %d bytes sent", got.size, 8*len(chunk))
+		}
+		if got.contentLength != -1 || fmt.Sprint(got.transferEncoding) != "[chunked]" {
+			t.Errorf("got Content-Length %d and Transfer-Encoding %v, want a chunked body", got.contentLength, got.transferEncoding)
+		}
+	})
+
+	t.Run("content length", func(t *testing.T) {
+		resp, err := http.Post(gateway.URL+"/upload", "application/octet-stream", bytes.NewReader(chunk))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		got := <-results
+		if want := sha256.Sum256(chunk); got.digest != want || got.contentLength != int64(len(chunk)) || got.transferEncoding != nil {
+			t.Errorf("got %d bytes with Content-Length %d and Transfer-Encoding %v, want the %d bytes with their length",
+				got.size, got.contentLength, got.transferEncoding, len(chunk))
+		}
+	})
+}
+
+func TestProxyLargeResponse(t *testing.T) {
+	payload := strings.Repeat("0123456789abcdef", 1<<18)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		// Without Content-Length the response is chunked
+		for i := 0; i < len(payload); i += 1 << 16 {
+			io.WriteString(w, payload[i:i+1<<16])
+		}
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/large"
+    backend: "%s"
+`, backend.URL))
+
+	resp, body := get(t, gateway.URL+"/large", nil)
+	if resp.StatusCode != http.StatusOK || body != payload {
+		t.Errorf("got %d with %d bytes, want 200 with the %d bytes of the backend", resp.StatusCode, len(body), len(payload))
+	}
+}
+
+func TestProxyTrailers(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		io.WriteString(w, "body")
+		w.Header().Set("X-Checksum", "abc")
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/trailers"
+    backend: "%s"
+`, backend.URL))
+
+	resp, body := get(t, gateway.URL+"/trailers", nil)
+	if body != "body" || resp.Trailer.Get("X-Checksum") != "abc" {
+		t.Errorf("got %q with the trailers %v, want body with X-Checksum: abc", body, resp.Trailer)
+	}
+}
+
+func TestProxyStreaming(t *testing.T) {
+	release := make(chan struct{})
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+		io.WriteString(w, "second\n")
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/stream"
+    backend: "%s"
+    streaming: true
+`, backend.URL))
+
+	resp, err := http.Get(gateway.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	// The first chunk arrives while the backend is still writing the response
+	if line, err := reader.ReadString('\n'); err != nil || line != "first\n" {
+		t.Fatalf("first chunk: got %q, %v", line, err)
+	}
+	close(release)
+	if line, err := reader.ReadString('\n'); err != nil || line != "second\n" {
+		t.Fatalf("second chunk: got %q, %v", line, err)
+	}
+}
+
+func TestServerSentEvents(t *testing.T) {
+	release := make(chan struct{})
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+		io.WriteString(w, "data: second\n\n")
+	})
+	// Neither the compression nor the retry buffer may hold the events back
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/events"
+    backend: "%s"
+    compression:
+      minBytes: 1
+    retry:
+      attempts: 2
+`, backend.URL))
+
+	// The client asks for gzip and transparently decompresses the events
+	resp, err := http.Get(gateway.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if !resp.Uncompressed {
+		t.Errorf("the events were not compressed")
+	}
+	reader := bufio.NewReader(resp.Body)
+	for _, want := range []string{"data: first\n", "\n"} {
+		if line, err := reader.ReadString('\n'); err != nil || line != want {
+			t.Fatalf("first event: got %q, %v, want %q", line, err, want)
+		}
+	}
+	close(release)
+	if line, err := reader.ReadString('\n'); err != nil || line != "data: second\n" {
+		t.Fatalf("second event: got %q, %v", line, err)
+	}
+}
+
+func TestHopByHopHeaders(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "X-Backend-Hop")
+		w.Header().Set("X-Backend-Hop", "secret")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("Proxy-Authenticate", "Basic")
+		w.Header().Set("X-Backend", "kept")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(echoedRequest{Header: r.Header})
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+`, backend.URL))
+
+	resp, body := get(t, gateway.URL+"/tweets", http.Header{
+		"Connection":          {"X-Client-Hop"},
+		"X-Client-Hop":        {"secret"},
+		"Keep-Alive":          {"timeout=5"},
+		"Proxy-Authorization": {"Basic Zm9vOmJhcg=="},
+		"X-Client":            {"kept"},
+	})
+	var echoed echoedRequest
+	if err := json.Unmarshal([]byte(body), &echoed); err != nil {
+		t.Fatalf("decode the echoed request %q: %v", body, err)
+	}
+
+	for _, name := range []string{"Connection", "X-Client-Hop", "Keep-Alive", "Proxy-Authorization"} {
+		if values, ok := echoed.Header[name]; ok {
+			t.Errorf("the upstream request has the hop-by-hop header %s: %q", name, values)
+		}
+	}
+	if echoed.Header.Get("X-Client") != "kept" {
+		t.Errorf("the upstream request lost X-Client")
+	}
+	for _, name := range []string{"X-Backend-Hop", "Keep-Alive", "Proxy-Authenticate"} {
+		if values, ok := resp.Header[name]; ok {
+			t.Errorf("the client response has the hop-by-hop header %s: %q", name, values)
+		}
+	}
+	if resp.Header.Get("X-Backend") != "kept" {
+		t.Errorf("the client response lost X-Backend")
+	}
+}
+
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	header := http.Header{
+		"Connection":        {"close, X-Listed", "X-Other"},
+		"X-Listed":          {"a"},
+		"X-Other":           {"b"},
+		"Transfer-Encoding": {"chunked"},
+		"Upgrade":           {"h2c"},
+		"Content-Type":      {"text/plain"},
+	}
+	removeHopByHopHeaders(header)
+	if len(header) != 1 || header.Get("Content-Type") != "text/plain" {
+		t.Errorf("got the headers %v, want only Content-Type", header)
+	}
+}
+
+func TestResponseHeaders(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Gateway", "backend")
+		io.WriteString(w, "ok")
+	})
+	tests := []struct {
+		name     string
+		override bool
+		gateway  string
+	}{
+		{name: "kept backend header", override: false, gateway: "backend"},
+		{name: "overridden backend header", override: true, gateway: "ice-flow"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    overrideResponseHeaders: %t
+    responseHeaders:
+      X-Gateway: "ice-flow"
+      X-Frame-Options: "DENY"
+`, backend.URL, test.override))
+
+			resp, _ := get(t, gateway.URL+"/tweets", nil)
+			if got := resp.Header.Get("X-Gateway"); got != test.gateway {
+				t.Errorf("X-Gateway = %q, want %q", got, test.gateway)
+			}
+			if got := resp.Header.Get("X-Frame-Options"); got != "DENY" {
+				t.Errorf("X-Frame-Options = %q, want the injected DENY", got)
+			}
+		})
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/v1/"
+    backend: "%s"
+    rewrite:
+      pattern: "^/v1/users/([0-9]+)/(.*)$"
+      replacement: "/internal/$2/$1"
+`, echoBackend(t)))
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/v1/users/42/tweets", want: "/internal/tweets/42"},
+		{path: "/v1/users/42/tweets?page=2", want: "/internal/tweets/42"},
+		// Without match the path is forwarded as usual, without the frontend prefix
+		{path: "/v1/search", want: "/search"},
+	}
+	for _, test := range tests {
+		if echoed := getEchoed(t, gateway.URL+test.path, nil); echoed.Path != test.want {
+			t.Errorf("%s was forwarded to %q, want %q", test.path, echoed.Path, test.want)
+		}
+	}
+}
+
+func TestRewriteValidation(t *testing.T) {
+	assertProblems(t, validateTestConfig(t, `
+routes:
+  - frontend: "/v1/"
+    backend: "http://localhost:8888"
+    rewrite:
+      pattern: "^/v1/(.*"
+`), `rewrite.pattern "^/v1/(.*" is not a valid regular expression`)
+}
+
+func TestBackendClosesMidBody(t *testing.T) {
+	payload := strings.Repeat("0123456789abcdef", 1<<12)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(payload)))
+		io.WriteString(w, payload[:len(payload)/2])
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	})
+	gateway, registry := startTestGateway(t, loadTestConfig(t, fmt.Sprintf(`
+metrics: true
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+`, backend.URL)))
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(logrus.LevelHooks{})
+
+	resp, err := http.Get(gateway.URL + "/tweets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || err == nil {
+		t.Fatalf("got %d with the error %v, want the 200 of the backend to be aborted", resp.StatusCode, err)
+	}
+	// The stream is cut, no error response is appended after the headers
+	if !strings.HasPrefix(payload, string(body)) {
+		t.Errorf("the %d bytes received are not the beginning of the backend body", len(body))
+	}
+
+	aborted := false
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Response aborted, the backend body could not be copied" {
+			aborted = true
+		}
+	}
+	if !aborted {
+		t.Error("the aborted response was not logged")
+	}
+	if got := metricValue(t, registry, "tweets_responses_total", map[string]string{"class": "2xx"}); got != 1 {
+		t.Errorf("tweets_responses_total{class=\"2xx\"} = %v, want 1", got)
+	}
+}
+
+func TestHostHeader(t *testing.T) {
+	backend := echoBackend(t)
+	backendHost := strings.TrimPrefix(backend, "http://")
+	tests := []struct {
+		name   string
+		option string
+		want   string
+	}{
+		{name: "backend host by default", want: backendHost},
+		{name: "configured host", option: `hostHeader: "users.internal"`, want: "users.internal"},
+		{name: "preserved host", option: "preserveHost: true", want: "gateway.example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/users"
+    backend: "%s"
+    %s
+`, backend, test.option))
+
+			req, err := http.NewRequest(http.MethodGet, gateway.URL+"/users", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = "gateway.example.com"
+			_, body := do(t, req)
+			var echoed echoedRequest
+			if err := json.Unmarshal([]byte(body), &echoed); err != nil {
+				t.Fatal(err)
+			}
+			if echoed.Host != test.want {
+				t.Errorf("the backend got the Host %q, want %q", echoed.Host, test.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamHeader(t *testing.T) {
+	hosts := map[string]string{}
+	var urls []interface{}
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		})
+		hosts[name] = strings.TrimPrefix(backend.URL, "http://")
+		urls = append(urls, backend.URL)
+	}
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backends: ["%s", "%s", "%s"]
+    upstreamHeader: "X-Upstream"
+  - frontend: "/hidden"
+    backend: "%[1]s"
+`, urls...))
+
+	seen := map[string]bool{}
+	for i := 0; i < 6; i++ {
+		resp, body := get(t, gateway.URL+"/tweets", nil)
+		if got := resp.Header.Get("X-Upstream"); got != hosts[body] {
+			t.Errorf("request %d: X-Upstream = %q, want the host %q of backend %s", i, got, hosts[body], body)
+		}
+		seen[body] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("the requests were served by %v, want the 3 backends", seen)
+	}
+
+	if resp, _ := get(t, gateway.URL+"/hidden", nil); resp.Header.Get("X-Upstream") != "" {
+		t.Errorf("X-Upstream is set on a route without upstreamHeader")
+	}
+}
+
+func TestUpstreamHeaderValidation(t *testing.T) {
+	err := validateTestConfig(t, `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    upstreamHeader: "X Upstream"
+`)
+	assertProblems(t, err, `upstreamHeader "X Upstream" is not a valid header name`)
+}
+
+func TestBackendRedirects(t *testing.T) {
+	var loops atomic.Int32
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		case "/loop":
+			loops.Add(1)
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			fmt.Fprintf(w, "%s referer=%q", r.URL.Path, r.Header.Get("Referer"))
+		}
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/passthrough/"
+    backend: "%s/"
+  - frontend: "/follow/"
+    backend: "%[1]s/"
+    followRedirects: true
+`, backend.URL))
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	send := func(path string, referer string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, gateway.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if referer != "" {
+			req.Header.Set("Referer", referer)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	if resp, _ := send("/passthrough/old", ""); resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/new" {
+		t.Errorf("passthrough: got %d to %q, want the 302 to /new", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if resp, body := send("/follow/old", ""); resp.StatusCode != http.StatusOK || body != `/new referer=""` {
+		t.Errorf("follow: got %d %q, want the final response without the backend referer", resp.StatusCode, body)
+	}
+	if _, body := send("/follow/old", "https://example.com/page"); body != `/new referer="https://example.com/page"` {
+		t.Errorf("follow: got %q, want the referer of the client", body)
+	}
+	if resp, _ := send("/follow/loop", ""); resp.StatusCode != http.StatusFound || loops.Load() != maxFollowedRedirects+1 {
+		t.Errorf("redirect loop: got %d after %d requests, want the 302 after %d", resp.StatusCode, loops.Load(), maxFollowedRedirects+1)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/throttled/throttled/v2"
+)
+
+const defaultRateLimitContentType = "text/plain; charset=utf-8"
+
+// RateLimitResponseConfiguration is the response answered to rate limited requests
+type RateLimitResponseConfiguration struct {
+	Body        string `yaml:"body"`
+	ContentType string `yaml:"contentType"`
+}
+
+func (config RateLimitResponseConfiguration) contentType() string {
+	if config.ContentType == "" {
+		return defaultRateLimitContentType
+	}
+	return config.ContentType
+}
+
+var rateUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// parseRate parses a rate like 10/s, 100/m, 5000/h or 1/500ms,
+// it returns the number of requests and the period they are allowed in.
+func parseRate(value string) (int, time.Duration, error) {
+	countValue, periodValue, found := strings.Cut(value, "/")
+	if !found {
+		return 0, 0, fmt.Errorf("rate %q must be written as count/period, like 100/m", value)
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(countValue))
+	if err != nil || count <= 0 {
+		return 0, 0, fmt.Errorf("rate %q must start with a positive count", value)
+	}
+
+	periodValue = strings.TrimSpace(periodValue)
+	period, known := rateUnits[periodValue]
+	if !known {
+		period, err = time.ParseDuration(periodValue)
+		if err != nil || period <= 0 {
+			return 0, 0, fmt.Errorf("rate %q must end with s, m, h or a positive duration", value)
+		}
+	}
+	return count, period, nil
+}
+
+// rateLimited tells whether the requests of the route are rate limited
+func (item GatewayItem) rateLimited() bool {
+	return item.Rate != "" || item.MaxReqPerSec > 0
+}
+
+// rateQuota returns the rate of the route, from rate or reqsPerSec
+func (item GatewayItem) rateQuota() (throttled.RateQuota, error) {
+	if item.Rate == "" {
+		return throttled.RateQuota{MaxRate: throttled.PerSec(item.MaxReqPerSec), MaxBurst: item.burst()}, nil
+	}
+	count, period, err := parseRate(item.Rate)
+	if err != nil {
+		return throttled.RateQuota{}, err
+	}
+	return throttled.RateQuota{MaxRate: throttled.PerDuration(count, period), MaxBurst: item.burst()}, nil
+}
+
+// rateLimit describes the rate of the route
+func (item GatewayItem) rateLimit() string {
+	if item.Rate != "" {
+		return item.Rate
+	}
+	return strconv.Itoa(item.MaxReqPerSec)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/throttled/throttled/v2"
+)
+
+func okBackend(t *testing.T) string {
+	t.Helper()
+	return newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}).URL
+}
+
+func TestRateLimitByRemoteAddr(t *testing.T) {
+	handler, _ := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+    reqsPerSec: 1
+    burst: 0
+    varyBy:
+      remoteAddr: true
+`, okBackend(t))))
+
+	if status := serve(handler, http.MethodGet, "/tweets", "10.0.0.1:1234").Code; status != http.StatusOK {
+		t.Fatalf("first request of 10.0.0.1: got %d, want 200", status)
+	}
+	if status := serve(handler, http.MethodGet, "/tweets", "10.0.0.1:5678").Code; status != http.StatusTooManyRequests {
+		t.Fatalf("second request of 10.0.0.1: got %d, want 429", status)
+	}
+	if status := serve(handler, http.MethodGet, "/tweets", "10.0.0.2:1234").Code; status != http.StatusOK {
+		t.Fatalf("first request of 10.0.0.2: got %d, want 200, the addresses share a bucket", status)
+	}
+}
+
+func TestRateLimitSharedWithoutRemoteAddr(t *testing.T) {
+	handler, _ := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+    reqsPerSec: 1
+    burst: 0
+`, okBackend(t))))
+
+	serve(handler, http.MethodGet, "/tweets", "10.0.0.1:1234")
+	if status := serve(handler, http.MethodGet, "/tweets", "10.0.0.2:1234").Code; status != http.StatusTooManyRequests {
+		t.Fatalf("request of 10.0.0.2: got %d, want 429 from the shared bucket", status)
+	}
+}
+
+func TestRateLimitByMethod(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   bool
+		statuses []int
+	}{
+		{"method and path", true, []int{200, 429, 200, 429, 200}},
+		{"path only", false, []int{200, 429, 429, 429, 200}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler, _ := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets/"
+    backend: "%s"
+    reqsPerSec: 1
+    burst: 0
+    varyBy:
+      path: true
+      method: %v
+`, okBackend(t), test.method)))
+
+			var statuses []int
+			for _, request := range []struct{ method, path string }{
+				{http.MethodGet, "/tweets/1"},
+				{http.MethodGet, "/tweets/1"},
+				{http.MethodPost, "/tweets/1"},
+				{http.MethodPost, "/tweets/1"},
+				{http.MethodGet, "/tweets/2"},
+			} {
+				statuses = append(statuses, serve(handler, request.method, request.path, "10.0.0.1:1234").Code)
+			}
+			if fmt.Sprint(statuses) != fmt.Sprint(test.statuses) {
+				t.Errorf("got %v, want %v", statuses, test.statuses)
+			}
+		})
+	}
+}
+
+func TestRateLimitedCounter(t *testing.T) {
+	handler, registry := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+metrics: true
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+    reqsPerSec: 1
+    burst: 1
+`, okBackend(t))))
+
+	for i := 0; i < 5; i++ {
+		serve(handler, http.MethodGet, "/tweets", "10.0.0.1:1234")
+	}
+	if got := metricValue(t, registry, "tweets_requests_rate_limited_total", nil); got != 3 {
+		t.Errorf("tweets_requests_rate_limited_total = %v, want 3", got)
+	}
+	if got := metricValue(t, registry, "tweets_requests_denied", nil); got != 3 {
+		t.Errorf("the deprecated tweets_requests_denied = %v, want 3", got)
+	}
+	if got := metricValue(t, registry, "tweets_requests_total", nil); got != 2 {
+		t.Errorf("tweets_requests_total = %v, want the 2 forwarded requests", got)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	handler, _ := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    rate: "2/m"
+    burst: 2
+`, okBackend(t))))
+
+	var remaining []string
+	for i := 0; i < 3; i++ {
+		rec := serve(handler, http.MethodGet, "/tweets", "10.0.0.1:1234")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: got %d, want 200", i, rec.Code)
+		}
+		if limit := rec.Header().Get("X-RateLimit-Limit"); limit != "3" {
+			t.Errorf("request %d: X-RateLimit-Limit = %q, want 3", i, limit)
+		}
+		remaining = append(remaining, rec.Header().Get("X-RateLimit-Remaining"))
+	}
+	if want := []string{"2", "1", "0"}; fmt.Sprint(remaining) != fmt.Sprint(want) {
+		t.Errorf("X-RateLimit-Remaining = %v, want %v", remaining, want)
+	}
+
+	rec := serve(handler, http.MethodGet, "/tweets", "10.0.0.1:1234")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit: got %d, want 429", rec.Code)
+	}
+	if retryAfter := rec.Header().Get("Retry-After"); retryAfter == "" || retryAfter == "0" {
+		t.Errorf("Retry-After = %q on the 429, want the seconds until the next request", retryAfter)
+	}
+}
+
+func TestDefaultBurst(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   string
+		allowed int
+	}{
+		{name: "omitted burst", limit: "reqsPerSec: 5", allowed: 6},
+		{name: "omitted burst of a rate", limit: `rate: "3/m"`, allowed: 4},
+		{name: "explicit zero burst", limit: "reqsPerSec: 5\n    burst: 0", allowed: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler, _ := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    %s
+`, okBackend(t), test.limit)))
+
+			allowed := 0
+			for i := 0; i < 10; i++ {
+				if serve(handler, http.MethodGet, "/tweets", "10.0.0.1:1234").Code == http.StatusOK {
+					allowed++
+				}
+			}
+			if allowed != test.allowed {
+				t.Errorf("%d requests were allowed at once, want %d", allowed, test.allowed)
+			}
+		})
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value  string
+		count  int
+		period time.Duration
+		err    string
+	}{
+		{value: "10/s", count: 10, period: time.Second},
+		{value: "100/m", count: 100, period: time.Minute},
+		{value: "5000/h", count: 5000, period: time.Hour},
+		{value: "1/500ms", count: 1, period: 500 * time.Millisecond},
+		{value: " 20 / m ", count: 20, period: time.Minute},
+		{value: "100", err: "must be written as count/period"},
+		{value: "0/s", err: "must start with a positive count"},
+		{value: "ten/s", err: "must start with a positive count"},
+		{value: "10/d", err: "must end with s, m, h or a positive duration"},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			count, period, err := parseRate(test.value)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got the error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil || count != test.count || period != test.period {
+				t.Errorf("parseRate(%q) = %d, %v, %v, want %d, %v", test.value, count, period, err, test.count, test.period)
+			}
+		})
+	}
+}
+
+func TestReqsPerSecStillLimits(t *testing.T) {
+	item := GatewayItem{MaxReqPerSec: 4}
+	quota, err := item.rateQuota()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota.MaxRate != throttled.PerSec(4) || quota.MaxBurst != 4 {
+		t.Errorf("got the quota %+v, want 4/s with a burst of 4", quota)
+	}
+}
+
+func TestRateLimitResponse(t *testing.T) {
+	handler, _ := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+rateLimitResponse:
+  body: '{"error":"rate limited"}'
+  contentType: "application/json"
+routes:
+  - frontend: "/tweets"
+    backend: "%[1]s"
+    reqsPerSec: 1
+    burst: 0
+    rateLimitResponse:
+      body: "Too many tweets, slow down"
+  - frontend: "/users"
+    backend: "%[1]s"
+    reqsPerSec: 1
+    burst: 0
+`, okBackend(t))))
+
+	tests := []struct {
+		path        string
+		body        string
+		contentType string
+	}{
+		{path: "/tweets", body: "Too many tweets, slow down", contentType: "text/plain; charset=utf-8"},
+		{path: "/users", body: `{"error":"rate limited"}`, contentType: "application/json"},
+	}
+	for _, test := range tests {
+		serve(handler, http.MethodGet, test.path, "10.0.0.1:1234")
+		rec := serve(handler, http.MethodGet, test.path, "10.0.0.1:1234")
+		if rec.Code != http.StatusTooManyRequests || rec.Body.String() != test.body || rec.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("%s: got %d %q as %q, want 429 %q as %q", test.path, rec.Code, rec.Body.String(), rec.Header().Get("Content-Type"), test.body, test.contentType)
+		}
+	}
+}
+
+func TestDefaultRateLimitResponse(t *testing.T) {
+	handler, _ := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    reqsPerSec: 1
+    burst: 0
+`, okBackend(t))))
+
+	serve(handler, http.MethodGet, "/tweets", "10.0.0.1:1234")
+	rec := serve(handler, http.MethodGet, "/tweets", "10.0.0.1:1234")
+	if rec.Body.String() != "Too Many Requests\n" {
+		t.Errorf("got the body %q, want the default one", rec.Body.String())
+	}
+}
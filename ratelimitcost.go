@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/kataras/requestid"
+	"github.com/sirupsen/logrus"
+	"github.com/throttled/throttled/v2"
+)
+
+const rateLimitCostHeader = "X-RateLimit-Cost"
+
+// rateLimitCost debits the bucket of the request with the cost answered by the backend
+type rateLimitCost struct {
+	limiter throttled.RateLimiter
+	varyBy  *routeVaryBy
+	burst   int
+}
+
+// debit takes the tokens of the cost beyond the one already taken by the request. When the bucket
+// does not hold enough tokens, it is emptied, so that the next requests are limited.
+func (c *rateLimitCost) debit(r *http.Request, cost int) {
+	if cost <= 1 {
+		return
+	}
+	key := c.varyBy.Key(r)
+	limited, _, err := c.limiter.RateLimit(key, cost-1)
+	// The remaining tokens are taken one by one, the bucket never holds more than burst+1 tokens
+	if err == nil && limited {
+		for taken := 0; taken <= c.burst && taken < cost-1; taken++ {
+			if limited, _, err = c.limiter.RateLimit(key, 1); limited || err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		logrus.WithField("requestid", requestid.Get(r)).Errorf("Failed to debit the rate limit cost: %v", err)
+	}
+}
+
+// costWriter reads the cost header of the backend response, the header is not sent to the client
+type costWriter struct {
+	http.ResponseWriter
+	request     *http.Request
+	cost        *rateLimitCost
+	wroteHeader bool
+}
+
+func (w *costWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if value := w.Header().Get(rateLimitCostHeader); value != "" {
+			w.Header().Del(rateLimitCostHeader)
+			if cost, err := strconv.Atoi(value); err == nil {
+				w.cost.debit(w.request, cost)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *costWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *costWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *costWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack lets websocket sessions take over the connection through the writer
+func (w *costWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// RateLimitCostHandler applies the X-RateLimit-Cost header of the backend responses to the rate limiter of the route
+func RateLimitCostHandler(item GatewayItem, limiter throttled.RateLimiter, varyBy *routeVaryBy, next http.Handler) http.Handler {
+	if !item.RateLimitCost {
+		return next
+	}
+	cost := &rateLimitCost{limiter: limiter, varyBy: varyBy, burst: item.burst()}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&costWriter{ResponseWriter: w, request: r, cost: cost}, r)
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestRateLimitCost(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		cost    string
+		allowed int
+	}{
+		{"no cost", true, "", 5},
+		{"cost of one", true, "1", 5},
+		{"cost of three", true, "3", 3},
+		{"cost beyond the burst", true, "10", 0},
+		{"invalid cost", true, "abc", 5},
+		{"disabled", false, "3", 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				if cost := r.URL.Query().Get("cost"); cost != "" {
+					w.Header().Set(rateLimitCostHeader, cost)
+				}
+			})
+			handler, _ := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    reqsPerSec: 1
+    burst: 5
+    rateLimitCost: %t
+    varyBy:
+      remoteAddr: true
+`, backend.URL, test.enabled)))
+
+			// The bucket holds 6 tokens, the first request takes the cost answered by the backend
+			first := serve(handler, http.MethodGet, "/tweets?cost="+test.cost, "10.0.0.1:1234")
+			if first.Code != http.StatusOK {
+				t.Fatalf("first request: got %d, want 200", first.Code)
+			}
+			if value := first.Header().Get(rateLimitCostHeader); test.enabled && value != "" {
+				t.Errorf("the cost header %q was sent to the client", value)
+			}
+			allowed := 0
+			for serve(handler, http.MethodGet, "/tweets", "10.0.0.1:1234").Code == http.StatusOK {
+				allowed++
+				if allowed > 6 {
+					t.Fatalf("the requests are not limited")
+				}
+			}
+			if allowed != test.allowed {
+				t.Errorf("got %d requests allowed after the cost, want %d", allowed, test.allowed)
+			}
+			// The cost only debits the bucket of the request
+			if status := serve(handler, http.MethodGet, "/tweets", "10.0.0.2:1234").Code; status != http.StatusOK {
+				t.Errorf("request of another address: got %d, want 200", status)
+			}
+		})
+	}
+}
+
+func TestRateLimitCostValidation(t *testing.T) {
+	err := validateTestConfig(t, `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    rateLimitCost: true
+`)
+	assertProblems(t, err, "rateLimitCost requires a rate limit")
+}
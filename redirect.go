@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+)
+
+const maxFollowedRedirects = 10
+
+// redirectTransport follows the redirects answered by the backends of the route, so that the client
+// gets the final response. The redirect is answered to the client after maxFollowedRedirects.
+type redirectTransport struct {
+	client *http.Client
+}
+
+func newRedirectTransport(base http.RoundTripper) *redirectTransport {
+	return &redirectTransport{client: &http.Client{
+		Transport: base,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxFollowedRedirects {
+				return http.ErrUseLastResponse
+			}
+			// The backend URLs are not sent to the next backend
+			if referer := via[0].Header.Get("Referer"); referer != "" {
+				req.Header.Set("Referer", referer)
+			} else {
+				req.Header.Del("Referer")
+			}
+			return nil
+		},
+	}}
+}
+
+func (t *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	out := *req
+	out.RequestURI = ""
+	return t.client.Do(&out)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/throttled/throttled/v2"
+)
+
+type handlerBox struct {
+	http.Handler
+	stop context.CancelFunc
+}
+
+// reloadableHandler serves requests with the routes of the last loaded configuration
+type reloadableHandler struct {
+	current  atomic.Value
+	registry *prometheus.Registry
+	drain    *drainState
+}
+
+// Swap serves the next handler, and stops the background work of the previous one
+func (h *reloadableHandler) Swap(next http.Handler, stop context.CancelFunc) {
+	if previous, ok := h.current.Swap(handlerBox{next, stop}).(handlerBox); ok && previous.stop != nil {
+		previous.stop()
+	}
+}
+
+// Load builds the routes of the configuration and swaps them in, the current routes are kept on error.
+// A panic while building the routes is returned as an error, so that a reload never stops the gateway.
+func (h *reloadableHandler) Load(config Configuration, store throttled.GCRAStore, client *http.Client) (err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("build err: %v", recovered)
+		}
+		if err != nil {
+			cancel()
+		}
+	}()
+
+	handler, err := buildHandler(ctx, config, store, client, h.registry, h.drain)
+	if err != nil {
+		return err
+	}
+	h.Swap(handler, cancel)
+	return nil
+}
+
+// Stop stops the background work of the current handler
+func (h *reloadableHandler) Stop() {
+	if current, ok := h.current.Load().(handlerBox); ok && current.stop != nil {
+		current.stop()
+	}
+}
+
+func (h *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.current.Load().(handlerBox).ServeHTTP(w, r)
+}
+
+// registerCollector registers the collector, or returns the one already registered
+// under the same name so routes can be rebuilt when the configuration is reloaded.
+func registerCollector[T prometheus.Collector](registry *prometheus.Registry, collector T) T {
+	if err := registry.Register(collector); err != nil {
+		var alreadyRegistered prometheus.AlreadyRegisteredError
+		if errors.As(err, &alreadyRegistered) {
+			if existing, ok := alreadyRegistered.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return collector
+}
+
+type reloadMetrics struct {
+	reloads     *prometheus.CounterVec
+	lastSuccess prometheus.Gauge
+}
+
+func newReloadMetrics(registry *prometheus.Registry) *reloadMetrics {
+	return &reloadMetrics{
+		reloads: registerCollector(registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ice_flow_limiter_config_reloads_total",
+			Help: "The total number of configuration reloads by result.",
+		}, []string{"result"})),
+		lastSuccess: registerCollector(registry, prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ice_flow_limiter_config_last_reload_success_timestamp_seconds",
+			Help: "The time of the last successful configuration load.",
+		})),
+	}
+}
+
+func (m *reloadMetrics) record(err error) {
+	if err != nil {
+		m.reloads.WithLabelValues("failure").Inc()
+		return
+	}
+	m.reloads.WithLabelValues("success").Inc()
+	m.lastSuccess.SetToCurrentTime()
+}
+
+// changedMetricsBuckets returns the labels of the routes whose histogram buckets changed. The reload
+// reuses the registered histograms, they keep the buckets they were created with.
+func changedMetricsBuckets(current Configuration, next Configuration) []string {
+	registered := make(map[string][]float64)
+	for _, item := range current.routes() {
+		if item.metricsEnabled(current.Metrics) {
+			registered[metricLabel(item.Label)] = responseTimeBuckets(item.MetricsBuckets)
+		}
+	}
+	var labels []string
+	for _, item := range next.routes() {
+		buckets, ok := registered[metricLabel(item.Label)]
+		if ok && item.metricsEnabled(next.Metrics) && !reflect.DeepEqual(buckets, responseTimeBuckets(item.MetricsBuckets)) {
+			labels = append(labels, item.Label)
+		}
+	}
+	return labels
+}
+
+// reloadConfig loads the configuration file again and swaps the routes in place.
+// The listener, the rate limit store and the upstream connections are kept, so the
+// rate limit counters of unchanged routes are preserved.
+func reloadConfig(path string, current Configuration, handler *reloadableHandler, store throttled.GCRAStore, client *http.Client) (Configuration, error) {
+	next, err := loadConfig(path)
+	if err != nil {
+		return current, err
+	}
+
+	if next.Port != current.Port || next.Host != current.Host || !reflect.DeepEqual(next.Store, current.Store) || next.Transport != current.Transport || next.TLS != current.TLS {
+		logrus.Warn("Changes to port, host, store, transport and tls are only applied after a restart")
+	}
+	if next.Timeouts.Read != current.Timeouts.Read || next.Timeouts.ReadHeader != current.Timeouts.ReadHeader ||
+		next.Timeouts.Write != current.Timeouts.Write || next.Timeouts.Idle != current.Timeouts.Idle || next.MaxHeaderBytes != current.MaxHeaderBytes {
+		logrus.Warn("Changes to the server timeouts and maxHeaderBytes are only applied after a restart")
+	}
+	if next.Tracing != current.Tracing {
+		logrus.Warn("Changes to tracing are only applied after a restart")
+	}
+	if next.grpcEnabled() && !current.grpcEnabled() {
+		logrus.Warn("HTTP/2 cleartext connections for grpc routes are only accepted after a restart")
+	}
+	if next.MetricsAddress != current.MetricsAddress || (next.MetricsAddress != "" && (next.metricsEnabled() != current.metricsEnabled() || next.MetricsPath != current.MetricsPath)) {
+		logrus.Warn("Changes to the metrics listener are only applied after a restart")
+	}
+
+	if labels := changedMetricsBuckets(current, next); len(labels) > 0 {
+		logrus.WithField("routes", labels).Warn("Changes to metricsBuckets are only applied after a restart")
+	}
+
+	if err := handler.Load(next, store, client); err != nil {
+		return current, err
+	}
+	if err := setupLogging(next.Log); err != nil {
+		logrus.Errorf("Failed to apply the log configuration: %v", err)
+	}
+	logrus.WithFields(logrus.Fields{
+		"path":   path,
+		"routes": len(next.Routes),
+	}).Info("Configuration reloaded")
+	return next, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+const reloadTestConfig = `
+port: "%s"
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+    reqsPerSec: 1
+    burst: %d
+`
+
+// waitReloads waits until the server counted the reloads with the result
+func waitReloads(t *testing.T, server *Server, result string, count float64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if metricValue(t, server.handler.registry, "ice_flow_limiter_config_reloads_total", map[string]string{"result": result}) >= count {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no %s reload counted", result)
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	backend := okBackend(t)
+	port := freePort(t)
+	path := writeTestConfig(t, "config.yaml", fmt.Sprintf(reloadTestConfig, port, backend, 0))
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, _ := runTestServer(t, config, ctx)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go handleSignals(signals, server, path, cancel, func(int) {})
+
+	url := "http://127.0.0.1:" + port + "/tweets"
+	get(t, url, nil)
+	if resp, _ := get(t, url, nil); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second request before the reload: got %d, want 429", resp.StatusCode)
+	}
+
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(reloadTestConfig, port, backend, 5)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	waitReloads(t, server, "success", 1)
+
+	for index := 0; index < 5; index++ {
+		if resp, _ := get(t, url, nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d after the reload: got %d, want 200 with the new burst", index, resp.StatusCode)
+		}
+	}
+}
+
+func TestReloadKeepsCountersOfUnchangedRoutes(t *testing.T) {
+	backend := okBackend(t)
+	port := freePort(t)
+	path := writeTestConfig(t, "config.yaml", fmt.Sprintf(reloadTestConfig, port, backend, 0))
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, _ := runTestServer(t, config, ctx)
+
+	url := "http://127.0.0.1:" + port + "/tweets"
+	get(t, url, nil)
+	if err := server.Reload(path); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := get(t, url, nil); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("request after the reload: got %d, want 429 from the preserved counter", resp.StatusCode)
+	}
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"syntax error", "routes: [\n"},
+		{"unknown field", `
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    reqsPerSecond: 1
+`},
+		{"invalid route", `
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    rateLimitCost: true
+`},
+		{"no routes", "routes: []\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := okBackend(t)
+			port := freePort(t)
+			path := writeTestConfig(t, "config.yaml", fmt.Sprintf(reloadTestConfig, port, backend, 0))
+			config, err := loadConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			server, _ := runTestServer(t, config, ctx)
+
+			url := "http://127.0.0.1:" + port + "/tweets"
+			get(t, url, nil)
+			invalid := test.config
+			if strings.Contains(invalid, "%s") {
+				invalid = fmt.Sprintf(invalid, backend)
+			}
+			if err := os.WriteFile(path, []byte(invalid), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := server.Reload(path); err == nil {
+				t.Fatal("got no error reloading the invalid configuration")
+			}
+			if got := metricValue(t, server.handler.registry, "ice_flow_limiter_config_reloads_total", map[string]string{"result": "failure"}); got != 1 {
+				t.Errorf("got %v failed reloads counted, want 1", got)
+			}
+
+			// The old routes are still served, with their rate limit counters
+			if resp, _ := get(t, url, nil); resp.StatusCode != http.StatusTooManyRequests {
+				t.Errorf("request after the failed reload: got %d, want 429 from the old route", resp.StatusCode)
+			}
+			// The next reload compares against the configuration still served
+			if err := os.WriteFile(path, []byte(fmt.Sprintf(reloadTestConfig, port, backend, 5)), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := server.Reload(path); err != nil {
+				t.Fatalf("reloading the fixed configuration: %v", err)
+			}
+			if resp, _ := get(t, url, nil); resp.StatusCode != http.StatusOK {
+				t.Errorf("request after the fixed reload: got %d, want 200", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestReloadFailureIsLogged(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(logrus.LevelHooks{})
+
+	backend := okBackend(t)
+	port := freePort(t)
+	path := writeTestConfig(t, "config.yaml", fmt.Sprintf(reloadTestConfig, port, backend, 0))
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, _ := runTestServer(t, config, ctx)
+
+	signals := make(chan os.Signal, 1)
+	defer close(signals)
+	go handleSignals(signals, server, path, cancel, func(int) {})
+	if err := os.WriteFile(path, []byte("routes: [\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signals <- syscall.SIGHUP
+	waitReloads(t, server, "failure", 1)
+
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.ErrorLevel && strings.HasPrefix(entry.Message, "Configuration reload failed, keeping the current configuration") {
+			if ctx.Err() != nil {
+				t.Error("the failed reload stopped the gateway")
+			}
+			return
+		}
+	}
+	t.Error("the failed reload was not logged")
+}
+
+func TestChangedMetricsBuckets(t *testing.T) {
+	current := loadTestConfig(t, `
+metrics: true
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    label: "tweets"
+  - frontend: "/users"
+    backend: "http://localhost:8888"
+    label: "users"
+    metricsBuckets: [10, 100]
+`)
+	next := loadTestConfig(t, `
+metrics: true
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    label: "tweets"
+    metricsBuckets: [5, 50]
+  - frontend: "/users"
+    backend: "http://localhost:8888"
+    label: "users"
+    metricsBuckets: [10, 100]
+  - frontend: "/search"
+    backend: "http://localhost:8888"
+    label: "search"
+    metricsBuckets: [5, 50]
+`)
+	if labels := changedMetricsBuckets(current, next); fmt.Sprint(labels) != "[tweets]" {
+		t.Errorf("got %v, want only the registered route with new buckets", labels)
+	}
+}
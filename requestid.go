@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/kataras/requestid"
+)
+
+const defaultRequestIDHeader = "X-Request-Id"
+
+func (config Configuration) requestIDHeader() string {
+	if config.RequestIDHeader == "" {
+		return defaultRequestIDHeader
+	}
+	return config.RequestIDHeader
+}
+
+// newRequestIDGenerator reuses the request ID sent by the client in the header, or generates a new one.
+// The ID is set on the incoming request so it is forwarded to the backend, and echoed on the response.
+func newRequestIDGenerator(header string) requestid.Generator {
+	return func(w http.ResponseWriter, r *http.Request) string {
+		id := r.Header.Get(header)
+		if id == "" {
+			uid, err := uuid.NewRandom()
+			if err != nil {
+				return ""
+			}
+			id = uid.String()
+			r.Header.Set(header, id)
+		}
+		w.Header().Set(header, id)
+		return id
+	}
+}
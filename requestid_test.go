@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		sent   string
+	}{
+		{name: "generated", header: defaultRequestIDHeader},
+		{name: "passed through", header: defaultRequestIDHeader, sent: "client-id-1"},
+		{name: "generated in a custom header", header: "X-Correlation-Id"},
+		{name: "passed through a custom header", header: "X-Correlation-Id", sent: "client-id-2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+`, echoBackend(t))
+			if test.header != defaultRequestIDHeader {
+				config = fmt.Sprintf("requestIdHeader: %q\n", test.header) + config
+			}
+			gateway := newTestGateway(t, config)
+
+			header := http.Header{}
+			if test.sent != "" {
+				header.Set(test.header, test.sent)
+			}
+			resp, body := get(t, gateway.URL+"/tweets", header)
+			id := resp.Header.Get(test.header)
+			if test.sent != "" && id != test.sent {
+				t.Errorf("the response has the ID %q, want the one of the client %q", id, test.sent)
+			}
+			if _, err := uuid.Parse(id); test.sent == "" && err != nil {
+				t.Errorf("the generated ID %q is not a UUID", id)
+			}
+			var echoed echoedRequest
+			if err := json.Unmarshal([]byte(body), &echoed); err != nil {
+				t.Fatal(err)
+			}
+			if forwarded := echoed.Header.Get(test.header); forwarded != id {
+				t.Errorf("the backend got the ID %q, want %q", forwarded, id)
+			}
+		})
+	}
+}
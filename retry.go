@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryBackoff                = 100 * time.Millisecond
+	defaultRetryMaxBackoff             = 5 * time.Second
+	defaultRetryMaxBufferBytes         = 1 << 20
+	defaultRetryMaxResponseBufferBytes = 1 << 20
+	defaultRetryMaxRetryAfter          = 5 * time.Second
+)
+
+var defaultRetryMethods = []string{"GET", "HEAD"}
+
+type RetryConfiguration struct {
+	Attempts int           `yaml:"attempts"`
+	Backoff  time.Duration `yaml:"backoff"`
+	// The doubled backoff never exceeds this delay
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+	Methods    []string      `yaml:"methods"`
+	// Request bodies larger than this are streamed to the backend, without retry
+	MaxBufferBytes int64 `yaml:"maxBufferBytes"`
+	// Response bodies larger than this are streamed to the client, a failure while they are copied is not retried
+	MaxResponseBufferBytes int64 `yaml:"maxResponseBufferBytes"`
+	// Retry the 503 responses, waiting as asked by their Retry-After header up to MaxRetryAfter
+	Unavailable   bool          `yaml:"unavailable"`
+	MaxRetryAfter time.Duration `yaml:"maxRetryAfter"`
+}
+
+func (config RetryConfiguration) maxBufferBytes() int64 {
+	if config.MaxBufferBytes <= 0 {
+		return defaultRetryMaxBufferBytes
+	}
+	return config.MaxBufferBytes
+}
+
+func (config RetryConfiguration) maxResponseBufferBytes() int64 {
+	if config.MaxResponseBufferBytes <= 0 {
+		return defaultRetryMaxResponseBufferBytes
+	}
+	return config.MaxResponseBufferBytes
+}
+
+// bufferResponse reads the body of the response up to the buffer limit, so that a backend failing while it
+// sends the body can be retried. The beginning of a larger body is kept and the rest is streamed.
+func (config RetryConfiguration) bufferResponse(resp *http.Response) error {
+	limit := config.maxResponseBufferBytes()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		resp.Body.Close()
+		return err
+	}
+	if int64(len(body)) > limit {
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	} else {
+		resp.Body = readCloser{bytes.NewReader(body), resp.Body}
+	}
+	return nil
+}
+
+// allows reports whether requests with this method can be sent again to the backend
+func (config RetryConfiguration) allows(method string) bool {
+	if config.Attempts <= 0 {
+		return false
+	}
+
+	methods := config.Methods
+	if len(methods) == 0 {
+		methods = defaultRetryMethods
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter reports whether the response asks to send the request again, and the delay to wait before
+func (config RetryConfiguration) retryAfter(resp *http.Response) (time.Duration, bool) {
+	if !config.Unavailable || resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	var delay time.Duration
+	value := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+	}
+	if delay > valueOrDefault(config.MaxRetryAfter, defaultRetryMaxRetryAfter) {
+		return 0, false
+	}
+	return delay, true
+}
+
+// maxBackoff defaults to 5s, or to the backoff when it is larger
+func (config RetryConfiguration) maxBackoff() time.Duration {
+	if config.MaxBackoff > 0 {
+		return config.MaxBackoff
+	}
+	if config.Backoff > defaultRetryMaxBackoff {
+		return config.Backoff
+	}
+	return defaultRetryMaxBackoff
+}
+
+// backoff doubles the backoff on each retry, up to the max backoff
+func (config RetryConfiguration) backoff(retry int) time.Duration {
+	delay := valueOrDefault(config.Backoff, defaultRetryBackoff)
+	maxBackoff := config.maxBackoff()
+	// Doubling one step at a time cannot overflow, the delay stops growing once it reaches the max
+	for i := 0; i < retry && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		return maxBackoff
+	}
+	return delay
+}
+
+// wait sleeps before the given retry, for the given delay or the backoff of the retry
+func (config RetryConfiguration) wait(ctx context.Context, retry int, delay time.Duration) error {
+	if delay <= 0 {
+		delay = config.backoff(retry)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func validateRetry(config RetryConfiguration) []string {
+	var problems []string
+	if config.Attempts < 0 {
+		problems = append(problems, fmt.Sprintf("retry attempts must be positive, got %d", config.Attempts))
+	}
+	if config.Backoff < 0 {
+		problems = append(problems, fmt.Sprintf("retry backoff must be positive, got %v", config.Backoff))
+	}
+	if config.MaxBackoff < 0 {
+		problems = append(problems, fmt.Sprintf("retry maxBackoff must be positive, got %v", config.MaxBackoff))
+	} else if config.MaxBackoff > 0 && config.Backoff > config.MaxBackoff {
+		problems = append(problems, fmt.Sprintf("retry backoff %v is larger than maxBackoff %v", config.Backoff, config.MaxBackoff))
+	}
+	if config.MaxBufferBytes < 0 {
+		problems = append(problems, fmt.Sprintf("retry maxBufferBytes must be positive, got %d", config.MaxBufferBytes))
+	}
+	if config.MaxResponseBufferBytes < 0 {
+		problems = append(problems, fmt.Sprintf("retry maxResponseBufferBytes must be positive, got %d", config.MaxResponseBufferBytes))
+	}
+	if config.MaxRetryAfter < 0 {
+		problems = append(problems, fmt.Sprintf("retry maxRetryAfter must be positive, got %v", config.MaxRetryAfter))
+	}
+	return problems
+}
+
+// readCloser reads from a reader and closes the original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
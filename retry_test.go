@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyBackend drops the connection of its first request and echoes the body of the next ones
+func flakyBackend(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", r.Method, body)
+	})
+	return backend.URL, &calls
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		want   string
+		calls  int32
+	}{
+		{name: "get retried", method: http.MethodGet, status: http.StatusOK, want: "GET ", calls: 2},
+		{name: "put body sent again", method: http.MethodPut, body: "tweet", status: http.StatusOK, want: "PUT tweet", calls: 2},
+		{name: "post not retried", method: http.MethodPost, body: "tweet", status: http.StatusBadGateway, calls: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend, calls := flakyBackend(t)
+			gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    retry:
+      attempts: 2
+      backoff: 1ms
+      methods: ["GET", "PUT"]
+`, backend))
+
+			req, err := http.NewRequest(test.method, gateway.URL+"/tweets", strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, body := do(t, req)
+			if resp.StatusCode != test.status || (test.want != "" && body != test.want) {
+				t.Errorf("got %d %q, want %d %q", resp.StatusCode, body, test.status, test.want)
+			}
+			if got := calls.Load(); got != test.calls {
+				t.Errorf("the backend got %d requests, want %d", got, test.calls)
+			}
+		})
+	}
+}
+
+func TestRetryValidation(t *testing.T) {
+	assertProblems(t, validateTestConfig(t, `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    retry:
+      attempts: -1
+      maxBackoff: -1s
+`), "retry attempts must be positive, got -1", "retry maxBackoff must be positive, got -1s")
+
+	assertProblems(t, validateTestConfig(t, `
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+    retry:
+      attempts: 1
+      backoff: 2s
+      maxBackoff: 1s
+`), "retry backoff 2s is larger than maxBackoff 1s")
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		config RetryConfiguration
+		retry  int
+		want   time.Duration
+	}{
+		{RetryConfiguration{}, 0, 100 * time.Millisecond},
+		{RetryConfiguration{}, 3, 800 * time.Millisecond},
+		{RetryConfiguration{}, 6, defaultRetryMaxBackoff},
+		{RetryConfiguration{}, 100, defaultRetryMaxBackoff},
+		{RetryConfiguration{Backoff: time.Second, MaxBackoff: 3 * time.Second}, 1, 2 * time.Second},
+		{RetryConfiguration{Backoff: time.Second, MaxBackoff: 3 * time.Second}, 2, 3 * time.Second},
+		{RetryConfiguration{Backoff: time.Minute}, 2, time.Minute},
+	}
+	for _, test := range tests {
+		if got := test.config.backoff(test.retry); got != test.want {
+			t.Errorf("backoff %v up to %v, retry %d: got %v, want %v", test.config.Backoff, test.config.MaxBackoff, test.retry, got, test.want)
+		}
+	}
+}
+
+// truncatingBackend announces the length of the body but drops the connection in the middle of its first response
+func truncatingBackend(t *testing.T, body string) (string, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		if calls.Add(1) > 1 {
+			io.WriteString(w, body)
+			return
+		}
+		io.WriteString(w, body[:len(body)/2])
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	})
+	return backend.URL, &calls
+}
+
+func TestRetryResponseBuffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		complete bool
+		calls    int32
+	}{
+		{name: "body within the buffer is retried", body: strings.Repeat("a", 64), complete: true, calls: 2},
+		{name: "body over the buffer is streamed", body: strings.Repeat("b", 4096), complete: false, calls: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend, calls := truncatingBackend(t, test.body)
+			gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    retry:
+      attempts: 2
+      backoff: 1ms
+      maxResponseBufferBytes: 1024
+`, backend))
+
+			// The aborted response can fail before its headers reach the client
+			var body []byte
+			resp, err := http.Get(gateway.URL + "/tweets")
+			if err == nil {
+				body, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+			if complete := err == nil && string(body) == test.body; complete != test.complete {
+				t.Errorf("got %d of the %d bytes with the error %v, complete: %t, want %t", len(body), len(test.body), err, complete, test.complete)
+			}
+			if got := calls.Load(); got != test.calls {
+				t.Errorf("the backend got %d requests, want %d", got, test.calls)
+			}
+		})
+	}
+}
+
+func TestBufferResponse(t *testing.T) {
+	config := RetryConfiguration{MaxResponseBufferBytes: 8}
+	for _, body := range []string{"short", "longer than the buffer"} {
+		source := &closeRecorder{Reader: strings.NewReader(body)}
+		resp := &http.Response{Body: source}
+		if err := config.bufferResponse(resp); err != nil {
+			t.Fatal(err)
+		}
+		read, err := io.ReadAll(resp.Body)
+		if err != nil || string(read) != body {
+			t.Errorf("got %q, %v, want the whole body %q", read, err, body)
+		}
+		resp.Body.Close()
+		if !source.closed {
+			t.Errorf("the body of the backend was not closed")
+		}
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestRetryUnavailable(t *testing.T) {
+	tests := []struct {
+		name        string
+		unavailable bool
+		retryAfter  string
+		status      int
+		calls       int32
+		wait        time.Duration
+	}{
+		{name: "retried after Retry-After", unavailable: true, retryAfter: "1", status: http.StatusOK, calls: 2, wait: time.Second},
+		{name: "retried without Retry-After", unavailable: true, status: http.StatusOK, calls: 2},
+		{name: "Retry-After over the max wait", unavailable: true, retryAfter: "10", status: http.StatusServiceUnavailable, calls: 1},
+		{name: "unavailable retries disabled", retryAfter: "1", status: http.StatusServiceUnavailable, calls: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls atomic.Int32
+			backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					if test.retryAfter != "" {
+						w.Header().Set("Retry-After", test.retryAfter)
+					}
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				io.WriteString(w, "ok")
+			})
+			gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    retry:
+      attempts: 2
+      backoff: 1ms
+      unavailable: %t
+      maxRetryAfter: 2s
+`, backend.URL, test.unavailable))
+
+			start := time.Now()
+			resp, _ := get(t, gateway.URL+"/tweets", nil)
+			elapsed := time.Since(start)
+			if resp.StatusCode != test.status {
+				t.Errorf("got %d, want %d", resp.StatusCode, test.status)
+			}
+			if got := calls.Load(); got != test.calls {
+				t.Errorf("the backend got %d requests, want %d", got, test.calls)
+			}
+			if elapsed < test.wait {
+				t.Errorf("the retry was sent after %v, want %v as asked by Retry-After", elapsed, test.wait)
+			}
+		})
+	}
+}
+
+func TestRetryAfterDate(t *testing.T) {
+	config := RetryConfiguration{Unavailable: true, MaxRetryAfter: time.Minute}
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+	resp.Header.Set("Retry-After", time.Now().Add(30*time.Second).UTC().Format(http.TimeFormat))
+	delay, retry := config.retryAfter(resp)
+	if !retry || delay <= 28*time.Second || delay > 30*time.Second {
+		t.Errorf("retryAfter = %v, %t, want about 30s", delay, retry)
+	}
+
+	resp.StatusCode = http.StatusBadGateway
+	if _, retry := config.retryAfter(resp); retry {
+		t.Error("a 502 is retried as unavailable")
+	}
+}
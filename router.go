@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	exactMatch  = "exact"
+	prefixMatch = "prefix"
+)
+
+func validateMatch(item GatewayItem) []string {
+	var problems []string
+	switch item.Match {
+	case "", exactMatch, prefixMatch:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown match %q, expected %q or %q", item.Match, exactMatch, prefixMatch))
+	}
+	return problems
+}
+
+const (
+	addTrailingSlash    = "add"
+	removeTrailingSlash = "remove"
+)
+
+// TrailingSlashConfiguration redirects the requests of a route to the path with, or without, a trailing slash
+type TrailingSlashConfiguration struct {
+	Redirect string `yaml:"redirect"`
+	Status   int    `yaml:"status"`
+}
+
+func (config TrailingSlashConfiguration) status() int {
+	if config.Status == 0 {
+		return http.StatusPermanentRedirect
+	}
+	return config.Status
+}
+
+func validateTrailingSlash(config *TrailingSlashConfiguration) []string {
+	var problems []string
+	if config == nil {
+		return problems
+	}
+	switch config.Redirect {
+	case addTrailingSlash, removeTrailingSlash:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown trailingSlash.redirect %q, expected %q or %q", config.Redirect, addTrailingSlash, removeTrailingSlash))
+	}
+	switch config.status() {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		problems = append(problems, fmt.Sprintf("trailingSlash.status must be 301, 302, 307 or 308, got %d", config.Status))
+	}
+	return problems
+}
+
+// TrailingSlashHandler redirects the paths of the route that do not have the expected trailing slash
+func TrailingSlashHandler(config *TrailingSlashConfiguration, next http.Handler) http.Handler {
+	if config == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.EscapedPath()
+		hasSlash := strings.HasSuffix(path, "/")
+		var location string
+		if config.Redirect == addTrailingSlash && !hasSlash {
+			location = path + "/"
+		} else if config.Redirect == removeTrailingSlash && hasSlash && path != "/" {
+			location = strings.TrimSuffix(path, "/")
+		}
+		if location == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.RawQuery != "" {
+			location += "?" + r.URL.RawQuery
+		}
+		w.Header().Set("Location", location)
+		w.WriteHeader(config.status())
+	})
+}
+
+// prefixMatch tells whether the route matches the paths under its frontend.
+// By default, like http.ServeMux, a frontend ending with / is a prefix and the others are exact.
+func (item GatewayItem) prefixMatch() bool {
+	switch item.Match {
+	case exactMatch:
+		return false
+	case prefixMatch:
+		return true
+	}
+	return strings.HasSuffix(item.Frontend, "/")
+}
+
+// matchKey identifies the paths matched by the route, two routes with the same key conflict.
+// A prefix matches the frontend with and without its trailing slash.
+func (item GatewayItem) matchKey() string {
+	if item.prefixMatch() {
+		return strings.TrimSuffix(item.Frontend, "/") + "/*"
+	}
+	return item.Frontend
+}
+
+// Router sends each request to the route of its path: an exact route first,
+// then the longest prefix route. A prefix matches whole path segments only,
+// /api matches /api and /api/users but not /apis.
+// The exact routes redirecting the trailing slash also get the path with the slash toggled,
+// unless another route matches it.
+type Router struct {
+	exact    map[string]http.Handler
+	aliases  map[string]http.Handler
+	prefixes []prefixRoute
+	fallback http.Handler
+}
+
+type prefixRoute struct {
+	base    string
+	handler http.Handler
+}
+
+// NewRouter returns a router answering the unmatched paths with the fallback handler
+func NewRouter(fallback http.Handler) *Router {
+	return &Router{exact: make(map[string]http.Handler), aliases: make(map[string]http.Handler), fallback: fallback}
+}
+
+func (router *Router) Handle(item GatewayItem, handler http.Handler) {
+	if !item.prefixMatch() {
+		router.exact[item.Frontend] = handler
+		if item.TrailingSlash != nil && item.Frontend != "/" {
+			alias := item.Frontend + "/"
+			if strings.HasSuffix(item.Frontend, "/") {
+				alias = strings.TrimSuffix(item.Frontend, "/")
+			}
+			router.aliases[alias] = handler
+		}
+		return
+	}
+	router.prefixes = append(router.prefixes, prefixRoute{base: strings.TrimSuffix(item.Frontend, "/"), handler: handler})
+	sort.SliceStable(router.prefixes, func(i, j int) bool {
+		return len(router.prefixes[i].base) > len(router.prefixes[j].base)
+	})
+}
+
+func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if handler, ok := router.exact[path]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	for _, route := range router.prefixes {
+		if path == route.base || strings.HasPrefix(path, route.base+"/") {
+			route.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	if handler, ok := router.aliases[path]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	router.fallback.ServeHTTP(w, r)
+}
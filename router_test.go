@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestDefaultRouteLimitsUnmatchedPaths(t *testing.T) {
+	handler, _ := buildTestHandler(t, loadTestConfig(t, fmt.Sprintf(`
+defaultRoute:
+  reqsPerSec: 1
+  burst: 1
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+`, okBackend(t))))
+
+	statuses := []int{
+		serve(handler, http.MethodGet, "/wp-admin", "10.0.0.1:1234").Code,
+		serve(handler, http.MethodGet, "/.env", "10.0.0.1:1234").Code,
+		serve(handler, http.MethodGet, "/phpmyadmin", "10.0.0.1:1234").Code,
+	}
+	if want := []int{http.StatusNotFound, http.StatusNotFound, http.StatusTooManyRequests}; fmt.Sprint(statuses) != fmt.Sprint(want) {
+		t.Errorf("unmatched paths: got %v, want %v", statuses, want)
+	}
+	// Each client has its own bucket, and the matched routes are not limited by the default route
+	if status := serve(handler, http.MethodGet, "/wp-admin", "10.0.0.2:1234").Code; status != http.StatusNotFound {
+		t.Errorf("unmatched path of another client: got %d, want 404", status)
+	}
+	if status := serve(handler, http.MethodGet, "/tweets", "10.0.0.1:1234").Code; status != http.StatusOK {
+		t.Errorf("matched route: got %d, want 200", status)
+	}
+}
+
+func TestDefaultRouteBackend(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(`
+defaultRoute:
+  reqsPerSec: 10
+  backend: "%s"
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:1"
+`, echoBackend(t)))
+
+	if echoed := getEchoed(t, gateway.URL+"/users/1", nil); echoed.Path != "/users/1" {
+		t.Errorf("the default backend got the path %q, want /users/1", echoed.Path)
+	}
+}
+
+func TestNotFoundWithoutDefaultRoute(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+`, okBackend(t)))
+
+	resp, body := get(t, gateway.URL+"/users", nil)
+	if resp.StatusCode != http.StatusNotFound || body != `{"status":404,"error":"Not Found"}` {
+		t.Errorf("got %d %q, want the JSON 404", resp.StatusCode, body)
+	}
+}
+
+func TestRouterMatching(t *testing.T) {
+	router := NewRouter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	for _, item := range []GatewayItem{
+		{Frontend: "/api"},
+		{Frontend: "/static/"},
+		{Frontend: "/users", Match: prefixMatch},
+		{Frontend: "/users/admin"},
+		{Frontend: "/exact/", Match: exactMatch},
+		{Frontend: "/docs", TrailingSlash: &TrailingSlashConfiguration{Redirect: addTrailingSlash}},
+	} {
+		name := item.Frontend
+		router.Handle(item, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+
+	tests := []struct {
+		path  string
+		route string
+	}{
+		{"/api", "/api"},
+		{"/api/", ""},
+		{"/api/users", ""},
+		{"/static/", "/static/"},
+		{"/static", "/static/"},
+		{"/static/css/site.css", "/static/"},
+		{"/users", "/users"},
+		{"/users/", "/users"},
+		{"/users/1", "/users"},
+		{"/usersx", ""},
+		{"/users/admin", "/users/admin"},
+		{"/users/admin/1", "/users"},
+		{"/exact/", "/exact/"},
+		{"/exact/page", ""},
+		{"/docs", "/docs"},
+		{"/docs/", "/docs"},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			rec := serve(router, http.MethodGet, test.path, "")
+			if test.route == "" {
+				if rec.Code != http.StatusNotFound {
+					t.Errorf("matched the route %q, want no route", rec.Body.String())
+				}
+				return
+			}
+			if rec.Body.String() != test.route {
+				t.Errorf("matched the route %q, want %q", rec.Body.String(), test.route)
+			}
+		})
+	}
+}
+
+func TestTrailingSlashRedirect(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/docs/"
+    backend: "%s"
+    match: "exact"
+    trailingSlash:
+      redirect: add
+  - frontend: "/api"
+    backend: "%[1]s"
+    match: "prefix"
+    trailingSlash:
+      redirect: remove
+      status: 301
+`, okBackend(t)))
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/docs/", http.StatusOK, ""},
+		{"/docs?page=2", http.StatusPermanentRedirect, "/docs/?page=2"},
+		{"/api/users", http.StatusOK, ""},
+		{"/api/users/", http.StatusMovedPermanently, "/api/users"},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			resp, err := client.Get(gateway.URL + test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.status || resp.Header.Get("Location") != test.location {
+				t.Errorf("got %d %q, want %d %q", resp.StatusCode, resp.Header.Get("Location"), test.status, test.location)
+			}
+		})
+	}
+}
+
+func TestMatchValidation(t *testing.T) {
+	err := validateTestConfig(t, `
+routes:
+  - frontend: "/users"
+    backend: "http://localhost:8888"
+    match: "regexp"
+  - frontend: "/api"
+    backend: "http://localhost:8888"
+    match: "prefix"
+  - frontend: "/api/"
+    backend: "http://localhost:8888"
+  - frontend: "/docs"
+    backend: "http://localhost:8888"
+    trailingSlash:
+      redirect: "toggle"
+      status: 303
+`)
+	assertProblems(t, err,
+		`unknown match "regexp", expected "exact" or "prefix"`,
+		"routes[2] (/api/): frontend already used by routes[1]",
+		`unknown trailingSlash.redirect "toggle"`,
+		"trailingSlash.status must be 301, 302, 307 or 308, got 303")
+}
+
+func TestTrailingSlashWithoutRedirect(t *testing.T) {
+	var urls []interface{}
+	for _, name := range []string{"docs", "assets", "files"} {
+		name := name
+		urls = append(urls, newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}).URL)
+	}
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/docs/"
+    backend: "%s"
+    match: "exact"
+  - frontend: "/assets"
+    backend: "%s"
+    trailingSlash:
+      redirect: add
+      status: 307
+  - frontend: "/assets/"
+    backend: "%s"
+`, urls...))
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/docs/", http.StatusOK, "docs"},
+		// Without trailingSlash, the toggled path matches no route
+		{"/docs", http.StatusNotFound, ""},
+		{"/assets", http.StatusTemporaryRedirect, ""},
+		// The redirect does not shadow the route matching the toggled path
+		{"/assets/", http.StatusOK, "files"},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			resp, err := client.Get(gateway.URL + test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != test.status || (test.body != "" && string(body) != test.body) {
+				t.Errorf("got %d %q, want %d %q", resp.StatusCode, body, test.status, test.body)
+			}
+		})
+	}
+}
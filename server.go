@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/throttled/throttled/v2"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Server runs the gateway listener, and the metrics listener when metrics have a dedicated address
+type Server struct {
+	store      throttled.GCRAStore
+	client     *http.Client
+	handler    *reloadableHandler
+	srv        *http.Server
+	metricsSrv *http.Server
+	tracer     *sdktrace.TracerProvider
+	reloads    *reloadMetrics
+
+	mu     sync.Mutex
+	config Configuration
+}
+
+// NewServer builds the routes of the configuration, the listeners are started by Run
+func NewServer(config Configuration) (*Server, error) {
+	store, err := NewStore(config.Store)
+	if err != nil {
+		return nil, err
+	}
+	client := NewHTTPClient(config.Transport)
+	tracer, err := NewTracerProvider(context.Background(), config.Tracing)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := NewRegistry()
+	handler := &reloadableHandler{registry: registry, drain: &drainState{}}
+	if err := handler.Load(config, store, client); err != nil {
+		return nil, err
+	}
+	reloads := newReloadMetrics(registry)
+	reloads.lastSuccess.SetToCurrentTime()
+
+	srv := newHTTPServer(config, handler)
+	if config.TLS.enabled() {
+		srv.TLSConfig, err = NewTLSConfig(config.TLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Server{
+		store:      store,
+		client:     client,
+		handler:    handler,
+		srv:        srv,
+		metricsSrv: NewMetricsServer(config, registry),
+		reloads:    reloads,
+		tracer:     tracer,
+		config:     config,
+	}, nil
+}
+
+// Handler serves the routes of the last loaded configuration
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// Reload loads the configuration file again, the current configuration is kept when it is invalid
+func (s *Server) Reload(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := reloadConfig(path, s.config, s.handler, s.store, s.client)
+	s.config = config
+	s.reloads.record(err)
+	return err
+}
+
+// Run serves until the context is canceled, then waits for the in-flight requests up to the shutdown timeout.
+// It returns the error of a listener that failed.
+func (s *Server) Run(ctx context.Context) error {
+	// The TLS configuration is only applied at startup, it is read before a reload can change it
+	s.mu.Lock()
+	tlsConfig := s.config.TLS
+	s.mu.Unlock()
+
+	errs := make(chan error, 2)
+	go func() {
+		if tlsConfig.enabled() {
+			errs <- s.srv.ListenAndServeTLS(tlsConfig.CertFile, tlsConfig.KeyFile)
+		} else {
+			errs <- s.srv.ListenAndServe()
+		}
+	}()
+	if s.metricsSrv != nil {
+		go func() {
+			errs <- s.metricsSrv.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-errs:
+		s.srv.Close()
+		s.close()
+		return err
+	case <-ctx.Done():
+	}
+
+	// The load balancers see the gateway unready and stop sending new requests before the listener is closed
+	s.handler.drain.draining.Store(true)
+	s.mu.Lock()
+	drainDelay := s.config.Timeouts.Drain
+	shutdownTimeout := s.config.Timeouts.shutdown()
+	s.mu.Unlock()
+	if drainDelay > 0 {
+		logrus.WithField("delay", drainDelay.String()).Info("Draining, the readiness probe fails until the shutdown")
+		time.Sleep(drainDelay)
+	}
+	logrus.WithField("timeout", shutdownTimeout.String()).Info("Shutting down, waiting for in-flight requests")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.srv.Shutdown(shutdownCtx); err != nil {
+		logrus.Errorf("Graceful shutdown failed %v", err)
+	}
+	s.close()
+	return nil
+}
+
+// close stops the metrics listener and the background work of the routes, then exports the last spans
+func (s *Server) close() {
+	if s.metricsSrv != nil {
+		s.metricsSrv.Close()
+	}
+	s.handler.Stop()
+	if s.tracer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := s.tracer.Shutdown(ctx); err != nil {
+			logrus.Errorf("Failed to export the last spans %v", err)
+		}
+	}
+}
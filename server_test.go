@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// freePort returns a port of the loopback interface that no listener uses
+func freePort(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+// waitListening waits until the address accepts connections
+func waitListening(t *testing.T, address string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if conn, err := net.Dial("tcp", address); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s is not listening", address)
+}
+
+// runTestServer runs the server of the configuration, the returned channel gets the result of Run
+func runTestServer(t *testing.T, config Configuration, ctx context.Context) (*Server, <-chan error) {
+	t.Helper()
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+	waitListening(t, net.JoinHostPort("127.0.0.1", config.Port))
+	return server, done
+}
+
+func TestShutdownWaitsForInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		io.WriteString(w, "done")
+	})
+	port := freePort(t)
+	config := loadTestConfig(t, fmt.Sprintf(`
+port: "%s"
+routes:
+  - frontend: "/slow"
+    backend: "%s"
+    label: "slow"
+`, port, backend.URL))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, done := runTestServer(t, config, ctx)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go handleSignals(signals, server, "", cancel, func(int) { t.Error("exited on the first signal") })
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://127.0.0.1:" + port + "/slow")
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		results <- result{body: string(body), err: err}
+	}()
+
+	<-started
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-results
+	if got.err != nil || got.body != "done" {
+		t.Fatalf("in-flight request: got %q, %v, want it to complete", got.body, got.err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server did not stop after the signal")
+	}
+	if _, err := http.Get("http://127.0.0.1:" + port + "/slow"); err == nil {
+		t.Fatal("the listener still accepts requests after the shutdown")
+	}
+}
+
+func TestReadinessFailsOnShutdownSignal(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		io.WriteString(w, "done")
+	})
+	port := freePort(t)
+	config := loadTestConfig(t, fmt.Sprintf(`
+port: "%s"
+timeouts:
+  drain: 500ms
+routes:
+  - frontend: "/slow"
+    backend: "%s"
+`, port, backend.URL))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, done := runTestServer(t, config, ctx)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go handleSignals(signals, server, "", cancel, func(int) { t.Error("exited on the first signal") })
+
+	gateway := "http://127.0.0.1:" + port
+	if resp, _ := get(t, gateway+readinessPath, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("readiness before the signal: got %d, want 200", resp.StatusCode)
+	}
+	inFlight := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(gateway + "/slow")
+		if err != nil {
+			inFlight <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		inFlight <- string(body)
+	}()
+	<-started
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		resp, body := get(t, gateway+readinessPath, nil)
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			if !strings.Contains(body, "draining, 1 requests in flight") {
+				t.Errorf("readiness body %q, want the draining reason with the in-flight request", body)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("readiness still answers %d after the signal", resp.StatusCode)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The listener keeps serving while the load balancers stop sending traffic
+	if resp, _ := get(t, gateway+livenessPath, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("liveness while draining: got %d, want 200", resp.StatusCode)
+	}
+	close(release)
+	if body := <-inFlight; body != "done" {
+		t.Errorf("in-flight request: got %q, want it to complete", body)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server did not stop after the drain delay")
+	}
+}
+
+func TestSecondSignalExits(t *testing.T) {
+	signals := make(chan os.Signal, 2)
+	canceled := false
+	exitCode := -1
+	signals <- syscall.SIGTERM
+	signals <- syscall.SIGINT
+	close(signals)
+
+	handleSignals(signals, nil, "", func() { canceled = true }, func(code int) { exitCode = code })
+	if !canceled {
+		t.Error("the first signal did not cancel the context")
+	}
+	if exitCode != 1 {
+		t.Errorf("exit code = %d, want 1 on the second signal", exitCode)
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts string
+		want     [4]time.Duration
+	}{
+		{
+			name: "defaults",
+			want: [4]time.Duration{defaultReadTimeout, defaultReadHeaderTimeout, defaultWriteTimeout, defaultIdleTimeout},
+		},
+		{
+			name: "configured",
+			timeouts: `
+timeouts:
+  read: 30s
+  readHeader: 2s
+  write: 1m
+  idle: 2m
+`,
+			want: [4]time.Duration{30 * time.Second, 2 * time.Second, time.Minute, 2 * time.Minute},
+		},
+		{
+			name: "partially configured",
+			timeouts: `
+timeouts:
+  write: 5m
+`,
+			want: [4]time.Duration{defaultReadTimeout, defaultReadHeaderTimeout, 5 * time.Minute, defaultIdleTimeout},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := loadTestConfig(t, test.timeouts+`
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`)
+			srv := newHTTPServer(config, http.NotFoundHandler())
+			got := [4]time.Duration{srv.ReadTimeout, srv.ReadHeaderTimeout, srv.WriteTimeout, srv.IdleTimeout}
+			if got != test.want {
+				t.Errorf("read, readHeader, write and idle timeouts = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestServerAddress(t *testing.T) {
+	tests := []struct {
+		host    string
+		address string
+		display string
+	}{
+		{host: "", address: ":8000", display: "127.0.0.1"},
+		{host: "0.0.0.0", address: "0.0.0.0:8000", display: "127.0.0.1"},
+		{host: "10.0.0.5", address: "10.0.0.5:8000", display: "10.0.0.5"},
+		{host: "::1", address: "[::1]:8000", display: "::1"},
+	}
+	for _, test := range tests {
+		config := Configuration{Host: test.host, Port: "8000"}
+		if got := newHTTPServer(config, http.NotFoundHandler()).Addr; got != test.address {
+			t.Errorf("host %q: listens on %q, want %q", test.host, got, test.address)
+		}
+		if got := config.displayHost(); got != test.display {
+			t.Errorf("host %q: displayed as %q, want %q", test.host, got, test.display)
+		}
+	}
+}
+
+func TestServerBindsConfiguredHost(t *testing.T) {
+	port := freePort(t)
+	config := loadTestConfig(t, fmt.Sprintf(`
+host: "127.0.0.1"
+port: "%s"
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+`, port, okBackend(t)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, done := runTestServer(t, config, ctx)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	if resp, body := get(t, "http://127.0.0.1:"+port+"/tweets", nil); resp.StatusCode != http.StatusOK || body != "ok" {
+		t.Errorf("got %d %q on the configured host, want 200 ok", resp.StatusCode, body)
+	}
+}
+
+func TestRunUntilCanceled(t *testing.T) {
+	port := freePort(t)
+	config := loadTestConfig(t, fmt.Sprintf(`
+port: "%s"
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+`, port, okBackend(t)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	server, done := runTestServer(t, config, ctx)
+
+	if resp, body := get(t, "http://127.0.0.1:"+port+"/tweets", nil); resp.StatusCode != http.StatusOK || body != "ok" {
+		t.Errorf("listener: got %d %q, want 200 ok", resp.StatusCode, body)
+	}
+	// The handler can be served without the listener as well
+	if rec := serve(server.Handler(), http.MethodGet, "/tweets", ""); rec.Code != http.StatusOK {
+		t.Errorf("handler: got %d, want 200", rec.Code)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run returned %v after the cancel, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the cancel")
+	}
+	if _, err := net.Dial("tcp", "127.0.0.1:"+port); err == nil {
+		t.Error("the listener still accepts connections after Run returned")
+	}
+}
+
+func TestRunListenerError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	server, err := NewServer(loadTestConfig(t, fmt.Sprintf(`
+host: "127.0.0.1"
+port: "%s"
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`, port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Run(context.Background()); err == nil {
+		t.Error("Run returned nil while the port is already used")
+	}
+}
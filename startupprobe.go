@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	tcpStartupProbe  = "tcp"
+	httpStartupProbe = "http"
+
+	defaultStartupProbeTimeout = 2 * time.Second
+)
+
+// StartupProbeConfiguration checks that the backends can be reached before the gateway serves traffic.
+// Unreachable backends are logged, the startup only fails when the probe is required.
+type StartupProbeConfiguration struct {
+	Enabled  bool          `yaml:"enabled"`
+	Type     string        `yaml:"type"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Required bool          `yaml:"required"`
+}
+
+func validateStartupProbe(config StartupProbeConfiguration) []string {
+	var problems []string
+	switch config.Type {
+	case "", tcpStartupProbe, httpStartupProbe:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown startupProbe.type %q, expected %q or %q", config.Type, tcpStartupProbe, httpStartupProbe))
+	}
+	if config.Timeout < 0 {
+		problems = append(problems, fmt.Sprintf("startupProbe.timeout must be positive, got %v", config.Timeout))
+	}
+	return problems
+}
+
+type probedBackend struct {
+	item    GatewayItem
+	backend *url.URL
+	err     error
+}
+
+// ProbeBackends tries every backend of the routes once, it returns an error for the unreachable backends
+// when the probe is required
+func ProbeBackends(ctx context.Context, config Configuration) error {
+	probe := config.StartupProbe
+	if !probe.Enabled {
+		return nil
+	}
+	timeout := valueOrDefault(probe.Timeout, defaultStartupProbeTimeout)
+
+	var backends []*probedBackend
+	seen := make(map[string]bool)
+	for _, item := range config.routes() {
+		for _, backend := range item.backendURLs() {
+			if seen[backend] {
+				continue
+			}
+			seen[backend] = true
+			backendUrl, err := url.Parse(backend)
+			if err != nil {
+				return err
+			}
+			backends = append(backends, &probedBackend{item: item, backend: backendUrl})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, backend := range backends {
+		wg.Add(1)
+		go func(backend *probedBackend) {
+			defer wg.Done()
+			if probe.Type == httpStartupProbe {
+				backend.err = probeBackendHTTP(ctx, config.Transport, backend.item, backend.backend, timeout)
+			} else {
+				backend.err = probeBackendTCP(ctx, backend.backend, timeout)
+			}
+		}(backend)
+	}
+	wg.Wait()
+
+	var unreachable []string
+	for _, backend := range backends {
+		if backend.err == nil {
+			continue
+		}
+		unreachable = append(unreachable, backend.backend.String())
+		logrus.WithFields(logrus.Fields{
+			"label":   backend.item.Label,
+			"backend": backend.backend.String(),
+		}).Warnf("Backend unreachable at startup: %v", backend.err)
+	}
+	logrus.WithFields(logrus.Fields{
+		"backends":    len(backends),
+		"unreachable": len(unreachable),
+	}).Info("Startup probe done")
+
+	if len(unreachable) > 0 && probe.Required {
+		return fmt.Errorf("startup probe err: unreachable backends %s", strings.Join(unreachable, ", "))
+	}
+	return nil
+}
+
+func probeBackendTCP(ctx context.Context, backend *url.URL, timeout time.Duration) error {
+	port := backend.Port()
+	if port == "" {
+		port = "80"
+		if backend.Scheme == "https" {
+			port = "443"
+		}
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(backend.Hostname(), port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// probeBackendHTTP sends a HEAD request to the backend, with the TLS configuration of the route.
+// Whatever its status, a response proves the backend is reachable.
+func probeBackendHTTP(ctx context.Context, config TransportConfiguration, item GatewayItem, backend *url.URL, timeout time.Duration) error {
+	if item.Transport != nil {
+		config = *item.Transport
+	}
+	transport := newTransport(config)
+	defer transport.CloseIdleConnections()
+	if item.UpstreamTLS != nil {
+		tlsConfig, err := item.UpstreamTLS.tlsConfig()
+		if err != nil {
+			return err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, backend.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Host = item.HostHeader
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestStartupProbe(t *testing.T) {
+	var heads atomic.Int32
+	reachable := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads.Add(1)
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}).URL
+	unreachable := closedAddress(t)
+
+	tests := []struct {
+		name     string
+		probe    string
+		backends []string
+		err      string
+		warned   []string
+	}{
+		{"tcp reachable", "{enabled: true}", []string{reachable}, "", nil},
+		{"tcp unreachable", "{enabled: true}", []string{reachable, unreachable}, "", []string{unreachable}},
+		{"http reachable", "{enabled: true, type: http}", []string{reachable}, "", nil},
+		{"http unreachable", "{enabled: true, type: http}", []string{unreachable}, "", []string{unreachable}},
+		{"required", "{enabled: true, required: true}", []string{reachable, unreachable},
+			"startup probe err: unreachable backends " + unreachable, []string{unreachable}},
+		{"disabled", "{enabled: false}", []string{unreachable}, "", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			routes := ""
+			for index, backend := range test.backends {
+				routes += fmt.Sprintf("  - frontend: \"/route%d\"\n    backend: \"%s\"\n    label: \"route%d\"\n", index, backend, index)
+			}
+			config := loadTestConfig(t, fmt.Sprintf("startupProbe: %s\nroutes:\n%s", test.probe, routes))
+			hook := logtest.NewGlobal()
+			defer logrus.StandardLogger().ReplaceHooks(logrus.LevelHooks{})
+
+			err := ProbeBackends(context.Background(), config)
+			if test.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("got the error %v, want %q", err, test.err)
+			}
+
+			var warned []string
+			for _, entry := range hook.AllEntries() {
+				if entry.Level == logrus.WarnLevel && strings.HasPrefix(entry.Message, "Backend unreachable at startup") {
+					warned = append(warned, entry.Data["backend"].(string))
+				}
+			}
+			if fmt.Sprint(warned) != fmt.Sprint(test.warned) {
+				t.Errorf("warned about %v, want %v", warned, test.warned)
+			}
+		})
+	}
+	if heads.Load() == 0 {
+		t.Errorf("the http probe did not send a HEAD request")
+	}
+}
+
+func TestStartupProbeValidation(t *testing.T) {
+	err := validateTestConfig(t, `
+startupProbe:
+  enabled: true
+  type: "icmp"
+  timeout: -1s
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`)
+	assertProblems(t, err, `unknown startupProbe.type "icmp", expected "tcp" or "http"`, "startupProbe.timeout must be positive, got -1s")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultStatsPath = "/stats"
+
+type StatsConfiguration struct {
+	Path      string                  `yaml:"path"`
+	BasicAuth *BasicAuthConfiguration `yaml:"basicAuth"`
+	APIKey    *APIKeyConfiguration    `yaml:"apiKey"`
+}
+
+// statsPath returns the path of the stats endpoint, or an empty string when it is disabled
+func (config Configuration) statsPath() string {
+	if config.Stats == nil {
+		return ""
+	}
+	if config.Stats.Path == "" {
+		return defaultStatsPath
+	}
+	return config.Stats.Path
+}
+
+func validateStats(config Configuration) []string {
+	var problems []string
+	stats := config.Stats
+	if stats == nil {
+		return problems
+	}
+	path := config.statsPath()
+	if !strings.HasPrefix(path, "/") {
+		problems = append(problems, fmt.Sprintf("stats.path %q must start with /", stats.Path))
+	}
+	if path == livenessPath || path == readinessPath || path == config.configEndpointPath() || (config.metricsOnGateway() && path == config.metricsPath()) {
+		problems = append(problems, fmt.Sprintf("stats.path %q is reserved by the gateway", stats.Path))
+	}
+	// The stats are read from the route metrics
+	if !config.metricsEnabled() {
+		problems = append(problems, "stats requires metrics")
+	}
+	for _, problem := range append(validateBasicAuth(stats.BasicAuth), validateAPIKey(stats.APIKey)...) {
+		problems = append(problems, "stats: "+problem)
+	}
+	return problems
+}
+
+// RouteStats is the snapshot of the metrics of a route
+type RouteStats struct {
+	Label       string `json:"label"`
+	Frontend    string `json:"frontend"`
+	Requests    uint64 `json:"requests"`
+	RateLimited uint64 `json:"rateLimited"`
+	Errors      uint64 `json:"errors"`
+	InFlight    int64  `json:"inFlight"`
+}
+
+// routeStats reads the metrics of the routes in the registry, the routes without metrics are skipped
+func routeStats(registry *prometheus.Registry, items []GatewayItem, metrics bool) ([]RouteStats, error) {
+	families, err := registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*dto.MetricFamily, len(families))
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+	value := func(name string, class string) float64 {
+		family, ok := byName[name]
+		if !ok {
+			return 0
+		}
+		var total float64
+		for _, metric := range family.GetMetric() {
+			if class != "" && !hasLabel(metric, "class", class) {
+				continue
+			}
+			switch {
+			case metric.Counter != nil:
+				total += metric.Counter.GetValue()
+			case metric.Gauge != nil:
+				total += metric.Gauge.GetValue()
+			}
+		}
+		return total
+	}
+
+	stats := []RouteStats{}
+	for _, item := range items {
+		if !item.metricsEnabled(metrics) {
+			continue
+		}
+		name := metricLabel(item.Label)
+		stats = append(stats, RouteStats{
+			Label:       item.Label,
+			Frontend:    item.Frontend,
+			Requests:    uint64(value(name+"_requests_total", "")),
+			RateLimited: uint64(value(name+"_requests_rate_limited_total", "")),
+			Errors:      uint64(value(name+"_responses_total", statusClass(http.StatusInternalServerError))),
+			InFlight:    int64(value(name+"_requests_in_flight", "")),
+		})
+	}
+	return stats, nil
+}
+
+func hasLabel(metric *dto.Metric, name string, value string) bool {
+	for _, label := range metric.GetLabel() {
+		if label.GetName() == name {
+			return label.GetValue() == value
+		}
+	}
+	return false
+}
+
+// StatsHandler serves a JSON snapshot of the route metrics, for the environments without a Prometheus server
+func StatsHandler(config Configuration, registry *prometheus.Registry) http.Handler {
+	items := config.routes()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats, err := routeStats(registry, items, config.Metrics)
+		if err != nil {
+			logrus.Errorf("Failed to gather the metrics: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		body, err := json.MarshalIndent(map[string]interface{}{"routes": stats}, "", "  ")
+		if err != nil {
+			logrus.Errorf("Failed to encode the stats: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+	return BasicAuthHandler(config.Stats.BasicAuth, APIKeyHandler(config.Stats.APIKey, handler))
+}
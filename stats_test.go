@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestStatsEndpoint(t *testing.T) {
+	held := make(chan struct{})
+	release := make(chan struct{})
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/hold":
+			close(held)
+			<-release
+		}
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+metrics: true
+stats:
+  apiKey:
+    keys: ["admin-key"]
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+    reqsPerSec: 1
+    burst: 1
+  - frontend: "/fail"
+    backend: "%[1]s/fail"
+    label: "fail"
+  - frontend: "/hold"
+    backend: "%[1]s/hold"
+    label: "hold"
+  - frontend: "/hidden"
+    backend: "%[1]s"
+    label: "hidden"
+    metrics: false
+`, backend.URL))
+
+	for i := 0; i < 5; i++ {
+		get(t, gateway.URL+"/tweets", nil)
+	}
+	for i := 0; i < 3; i++ {
+		get(t, gateway.URL+"/fail", nil)
+	}
+	done := make(chan struct{})
+	go func() {
+		if resp, err := http.Get(gateway.URL + "/hold"); err == nil {
+			resp.Body.Close()
+		}
+		close(done)
+	}()
+	<-held
+	defer func() {
+		close(release)
+		<-done
+	}()
+
+	if resp, _ := get(t, gateway.URL+"/stats", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got %d without the API key, want 401", resp.StatusCode)
+	}
+	resp, body := get(t, gateway.URL+"/stats", http.Header{"X-API-Key": {"admin-key"}})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("got %d %q, want the JSON stats", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	var stats struct {
+		Routes []RouteStats `json:"routes"`
+	}
+	if err := json.Unmarshal([]byte(body), &stats); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, body)
+	}
+
+	want := []RouteStats{
+		{Label: "tweets", Frontend: "/tweets", Requests: 2, RateLimited: 3},
+		{Label: "fail", Frontend: "/fail", Requests: 3, Errors: 3},
+		{Label: "hold", Frontend: "/hold", Requests: 1, InFlight: 1},
+	}
+	if fmt.Sprint(stats.Routes) != fmt.Sprint(want) {
+		t.Errorf("got the stats %+v, want %+v", stats.Routes, want)
+	}
+}
+
+func TestStatsValidation(t *testing.T) {
+	err := validateTestConfig(t, `
+stats:
+  path: "/healthz"
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`)
+	assertProblems(t, err, `stats.path "/healthz" is reserved by the gateway`, "stats requires metrics")
+}
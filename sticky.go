@@ -0,0 +1,74 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"net/http"
+)
+
+const stickyBalancing = "sticky"
+
+// StickyConfiguration selects the client identifier of the sticky balancing, the client IP by default
+type StickyConfiguration struct {
+	Cookie string `yaml:"cookie"`
+	Header string `yaml:"header"`
+}
+
+// key identifies the client of the request, the client IP is used when the cookie or header is missing
+func (config *StickyConfiguration) key(r *http.Request) string {
+	if config != nil && config.Cookie != "" {
+		if cookie, err := r.Cookie(config.Cookie); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
+	}
+	if config != nil && config.Header != "" {
+		if value := r.Header.Get(config.Header); value != "" {
+			return value
+		}
+	}
+	return clientIP(r)
+}
+
+// stickyBalancer sends a client to the same upstream with weighted rendezvous hashing.
+// When the upstream of a client is down, the client moves to its next upstream in the
+// rendezvous order, and the other clients keep their upstream.
+type stickyBalancer struct {
+	upstreams []*upstream
+	config    *StickyConfiguration
+}
+
+func (b *stickyBalancer) Next(r *http.Request) *upstream {
+	key := b.config.key(r)
+
+	var best *upstream
+	bestScore := 0.0
+	for _, u := range b.upstreams {
+		if !u.available() {
+			continue
+		}
+		if score := rendezvousScore(key, u); best == nil || score > bestScore {
+			best, bestScore = u, score
+		}
+	}
+	return best
+}
+
+func rendezvousScore(key string, u *upstream) float64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	hash.Write([]byte{0})
+	hash.Write([]byte(u.url.String()))
+
+	// The last bytes written barely change the high bits of FNV, they are mixed
+	// as in the murmur3 finalizer before taking a uniform value in (0, 1)
+	h := hash.Sum64()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	uniform := (float64(h>>11) + 0.5) / (1 << 53)
+
+	// The score of an upstream grows with its weight
+	return float64(u.weight) / -math.Log(uniform)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestStickySessions(t *testing.T) {
+	var urls []interface{}
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		urls = append(urls, newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}).URL)
+	}
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/cart"
+    balancing: "sticky"
+    sticky:
+      cookie: "session_id"
+    backends: ["%s", "%s", "%s"]
+`, urls...))
+
+	served := map[string]bool{}
+	for client := 0; client < 20; client++ {
+		header := http.Header{"Cookie": {fmt.Sprintf("session_id=client-%d", client)}}
+		_, first := get(t, gateway.URL+"/cart", header)
+		for i := 0; i < 5; i++ {
+			if _, body := get(t, gateway.URL+"/cart", header); body != first {
+				t.Fatalf("client %d moved from backend %s to %s", client, first, body)
+			}
+		}
+		served[first] = true
+	}
+	if len(served) < 2 {
+		t.Errorf("the 20 clients were all sent to the backends %v", served)
+	}
+}
+
+func TestStickyKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *StickyConfiguration
+		header http.Header
+		want   string
+	}{
+		{"client IP by default", nil, nil, "192.0.2.1"},
+		{"cookie", &StickyConfiguration{Cookie: "session_id"}, http.Header{"Cookie": {"session_id=abc"}}, "abc"},
+		{"missing cookie", &StickyConfiguration{Cookie: "session_id"}, nil, "192.0.2.1"},
+		{"header", &StickyConfiguration{Header: "X-User-Id"}, http.Header{"X-User-Id": {"42"}}, "42"},
+		{"missing header", &StickyConfiguration{Header: "X-User-Id"}, nil, "192.0.2.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/cart", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			if test.header != nil {
+				r.Header = test.header
+			}
+			if got := test.config.key(r); got != test.want {
+				t.Errorf("key = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStickyFallsBackWhenDown(t *testing.T) {
+	var upstreams []*upstream
+	for _, host := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		upstreams = append(upstreams, &upstream{url: &url.URL{Scheme: "http", Host: host}, weight: 1})
+	}
+	balancer := &stickyBalancer{upstreams: upstreams, config: &StickyConfiguration{Header: "X-User-Id"}}
+	request := func(client int) *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "/cart", nil)
+		r.Header.Set("X-User-Id", fmt.Sprint(client))
+		return r
+	}
+
+	before := map[int]*upstream{}
+	for client := 0; client < 30; client++ {
+		before[client] = balancer.Next(request(client))
+	}
+	upstreams[0].down.Store(true)
+	for client := 0; client < 30; client++ {
+		got := balancer.Next(request(client))
+		if got == upstreams[0] {
+			t.Fatalf("client %d was sent to the down upstream", client)
+		}
+		if before[client] != upstreams[0] && got != before[client] {
+			t.Errorf("client %d moved from %s to %s while its upstream is up", client, before[client].url, got.url)
+		}
+	}
+}
+
+func TestStickyValidation(t *testing.T) {
+	err := validateTestConfig(t, `
+routes:
+  - frontend: "/cart"
+    backend: "http://localhost:8888"
+    balancing: "sticky"
+    sticky:
+      cookie: "session_id"
+      header: "X-User-Id"
+  - frontend: "/orders"
+    backend: "http://localhost:8888"
+    sticky:
+      cookie: "session_id"
+`)
+	assertProblems(t, err,
+		"sticky.cookie and sticky.header cannot be used at the same time",
+		"sticky requires the sticky balancing")
+}
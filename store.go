@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-redis/redis"
+	"github.com/throttled/throttled/v2"
+	"github.com/throttled/throttled/v2/store/goredisstore"
+	"github.com/throttled/throttled/v2/store/memstore"
+)
+
+const (
+	memoryStore = "memory"
+	redisStore  = "redis"
+
+	defaultRedisKeyPrefix = "ice-flow-limiter:"
+)
+
+type RedisConfiguration struct {
+	Address   string `yaml:"address"`
+	Password  string `yaml:"password"`
+	DB        int    `yaml:"db"`
+	KeyPrefix string `yaml:"keyPrefix"`
+}
+
+type StoreConfiguration struct {
+	Type  string             `yaml:"type"`
+	Redis RedisConfiguration `yaml:"redis"`
+}
+
+func NewStore(config StoreConfiguration) (throttled.GCRAStore, error) {
+	switch config.Type {
+	case "", memoryStore:
+		return memstore.New(65536)
+	case redisStore:
+		client := redis.NewClient(&redis.Options{
+			Addr:     config.Redis.Address,
+			Password: config.Redis.Password,
+			DB:       config.Redis.DB,
+		})
+		if err := client.Ping().Err(); err != nil {
+			return nil, fmt.Errorf("redis store %s is unreachable: %w", config.Redis.Address, err)
+		}
+
+		keyPrefix := config.Redis.KeyPrefix
+		if keyPrefix == "" {
+			keyPrefix = defaultRedisKeyPrefix
+		}
+		return goredisstore.New(client, keyPrefix)
+	default:
+		return nil, fmt.Errorf("unknown store type %q, expected %q or %q", config.Type, memoryStore, redisStore)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedisStoreSharesCounters(t *testing.T) {
+	redis := miniredis.RunT(t)
+	config := loadTestConfig(t, fmt.Sprintf(`
+store:
+  type: redis
+  redis:
+    address: "%s"
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+    reqsPerSec: 1
+    burst: 1
+`, redis.Addr(), okBackend(t)))
+
+	// Two gateways behind the same load balancer
+	first, _ := buildTestHandler(t, config)
+	second, _ := buildTestHandler(t, config)
+
+	for index, handler := range []http.Handler{first, second} {
+		if status := serve(handler, http.MethodGet, "/tweets", "").Code; status != http.StatusOK {
+			t.Fatalf("request %d: got %d, want 200", index, status)
+		}
+	}
+	if status := serve(first, http.MethodGet, "/tweets", "").Code; status != http.StatusTooManyRequests {
+		t.Fatalf("third request: got %d, want 429 once the shared burst is used", status)
+	}
+	if len(redis.Keys()) == 0 {
+		t.Fatal("no rate limit key stored in redis")
+	}
+}
+
+func TestRedisStoreUnreachable(t *testing.T) {
+	redis := miniredis.RunT(t)
+	address := redis.Addr()
+	redis.Close()
+
+	_, err := NewStore(StoreConfiguration{Type: redisStore, Redis: RedisConfiguration{Address: address}})
+	if err == nil {
+		t.Fatal("expected an error for an unreachable redis")
+	}
+}
+
+func TestUnknownStore(t *testing.T) {
+	if _, err := NewStore(StoreConfiguration{Type: "memcached"}); err == nil {
+		t.Fatal("expected an error for an unknown store type")
+	}
+}
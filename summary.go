@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RateLimitSummary periodically logs, for each rate limited route, the number of allowed and rejected requests
+type RateLimitSummary struct {
+	interval time.Duration
+	mu       sync.Mutex
+	counters []*rateLimitCounter
+}
+
+type rateLimitCounter struct {
+	label    string
+	frontend string
+	allowed  atomic.Int64
+	rejected atomic.Int64
+}
+
+// NewRateLimitSummary returns the summary logged every interval, or nil when the interval is not set
+func NewRateLimitSummary(interval time.Duration) *RateLimitSummary {
+	if interval <= 0 {
+		return nil
+	}
+	return &RateLimitSummary{interval: interval}
+}
+
+// route returns the counter of a route
+func (s *RateLimitSummary) route(item GatewayItem) *rateLimitCounter {
+	if s == nil {
+		return nil
+	}
+	counter := &rateLimitCounter{label: item.Label, frontend: item.Frontend}
+	s.mu.Lock()
+	s.counters = append(s.counters, counter)
+	s.mu.Unlock()
+	return counter
+}
+
+// Start logs the summary every interval until the context is canceled, the last summary is logged on cancellation
+func (s *RateLimitSummary) Start(ctx context.Context) {
+	if s == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				s.log()
+				return
+			case <-ticker.C:
+				s.log()
+			}
+		}
+	}()
+}
+
+// log writes one line per route that received requests since the previous summary
+func (s *RateLimitSummary) log() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, counter := range s.counters {
+		allowed := counter.allowed.Swap(0)
+		rejected := counter.rejected.Swap(0)
+		if allowed == 0 && rejected == 0 {
+			continue
+		}
+		logrus.WithFields(logrus.Fields{
+			"label":    counter.label,
+			"frontend": counter.frontend,
+			"allowed":  allowed,
+			"rejected": rejected,
+			"interval": s.interval.String(),
+		}).Info("Rate limit summary")
+	}
+}
+
+func (c *rateLimitCounter) countAllowed(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.allowed.Add(1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *rateLimitCounter) countRejected(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.rejected.Add(1)
+		next.ServeHTTP(w, r)
+	})
+}
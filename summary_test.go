@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestRateLimitSummary(t *testing.T) {
+	backend := okBackend(t)
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(logrus.LevelHooks{})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+rateLimitSummaryInterval: 50ms
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+    label: "tweets"
+    reqsPerSec: 1
+    burst: 2
+  - frontend: "/idle"
+    backend: "%[1]s"
+    label: "idle"
+    reqsPerSec: 1
+`, backend))
+
+	for i := 0; i < 5; i++ {
+		get(t, gateway.URL+"/tweets", nil)
+	}
+
+	var allowed, rejected int64
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && allowed+rejected < 5 {
+		time.Sleep(50 * time.Millisecond)
+		allowed, rejected = 0, 0
+		for _, entry := range hook.AllEntries() {
+			if entry.Message != "Rate limit summary" {
+				continue
+			}
+			if entry.Data["label"] != "tweets" {
+				t.Fatalf("summary logged for the route %v without traffic", entry.Data["label"])
+			}
+			if entry.Data["frontend"] != "/tweets" || entry.Data["interval"] != "50ms" {
+				t.Errorf("unexpected summary fields %v", entry.Data)
+			}
+			allowed += entry.Data["allowed"].(int64)
+			rejected += entry.Data["rejected"].(int64)
+		}
+	}
+	if allowed != 3 || rejected != 2 {
+		t.Errorf("summaries counted %d allowed and %d rejected requests, want 3 and 2", allowed, rejected)
+	}
+}
+
+func TestRateLimitSummaryDisabled(t *testing.T) {
+	if summary := NewRateLimitSummary(0); summary != nil {
+		t.Errorf("got a summary without interval")
+	}
+	err := validateTestConfig(t, `
+rateLimitSummaryInterval: -1s
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`)
+	assertProblems(t, err, "rateLimitSummaryInterval must be positive, got -1s")
+}
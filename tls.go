@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+type TLSConfiguration struct {
+	CertFile   string `yaml:"certFile"`
+	KeyFile    string `yaml:"keyFile"`
+	MinVersion string `yaml:"minVersion"`
+}
+
+func (config TLSConfiguration) enabled() bool {
+	return config.CertFile != "" || config.KeyFile != ""
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	if v, ok := tlsVersions[version]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q, expected one of 1.0, 1.1, 1.2, 1.3", version)
+}
+
+func validateTLS(config TLSConfiguration) []string {
+	var problems []string
+	if !config.enabled() {
+		return problems
+	}
+	if config.CertFile == "" || config.KeyFile == "" {
+		problems = append(problems, "tls requires both certFile and keyFile")
+	}
+	if _, err := parseTLSVersion(config.MinVersion); err != nil {
+		problems = append(problems, fmt.Sprintf("tls: %v", err))
+	}
+	return problems
+}
+
+func NewTLSConfig(config TLSConfiguration) (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(config.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{MinVersion: minVersion}, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert writes a certificate of 127.0.0.1 and its key, and returns their paths with the certificate
+func writeSelfSignedCert(t *testing.T) (string, string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ice-flow-limiter"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestTLSTermination(t *testing.T) {
+	certFile, keyFile, cert := writeSelfSignedCert(t)
+	port := freePort(t)
+	config := loadTestConfig(t, fmt.Sprintf(`
+port: "%s"
+tls:
+  certFile: "%s"
+  keyFile: "%s"
+  minVersion: "1.3"
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+`, port, certFile, keyFile, okBackend(t)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, done := runTestServer(t, config, ctx)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	url := fmt.Sprintf("https://127.0.0.1:%s/tweets", port)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("HTTPS request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" || resp.TLS == nil {
+		t.Errorf("HTTPS request: got %d %q, want 200 ok over TLS", resp.StatusCode, body)
+	}
+
+	legacy := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS12}}}
+	if resp, err := legacy.Get(url); err == nil {
+		resp.Body.Close()
+		t.Error("a TLS 1.2 client was accepted with minVersion 1.3")
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+		err     bool
+	}{
+		{version: "", want: tls.VersionTLS12},
+		{version: "1.0", want: tls.VersionTLS10},
+		{version: "1.3", want: tls.VersionTLS13},
+		{version: "2.0", err: true},
+	}
+	for _, test := range tests {
+		got, err := parseTLSVersion(test.version)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("parseTLSVersion(%q) = %d, %v, want %d", test.version, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName = "github.com/drouian-m/ice-flow-limiter"
+
+	defaultTracingEndpoint    = "localhost:4318"
+	defaultTracingServiceName = "ice-flow-limiter"
+
+	tracingShutdownTimeout = 5 * time.Second
+)
+
+type TracingConfiguration struct {
+	Enabled bool `yaml:"enabled"`
+	// host:port of the OTLP/HTTP collector
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	ServiceName string  `yaml:"serviceName"`
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+func validateTracing(config TracingConfiguration) []string {
+	var problems []string
+	if config.SampleRatio < 0 || config.SampleRatio > 1 {
+		problems = append(problems, fmt.Sprintf("tracing.sampleRatio must be between 0 and 1, got %v", config.SampleRatio))
+	}
+	return problems
+}
+
+// NewTracerProvider exports the spans of the gateway to the OTLP collector, it returns nil when tracing is disabled.
+// The W3C trace context of the incoming requests is continued and propagated to the backends.
+func NewTracerProvider(ctx context.Context, config TracingConfiguration) (*sdktrace.TracerProvider, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = defaultTracingEndpoint
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if config.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("tracing exporter: %w", err)
+	}
+	return newTracerProvider(config, exporter), nil
+}
+
+func newTracerProvider(config TracingConfiguration, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = defaultTracingServiceName
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(valueOrDefault(config.SampleRatio, 1)))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName), semconv.ServiceVersion(version))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logrus.Errorf("Tracing error %v", err)
+	}))
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider
+}
+
+// TracingHandler records a span for each request of the route, tracer is nil when tracing is disabled
+func TracingHandler(tracer trace.Tracer, item GatewayItem, next http.Handler) http.Handler {
+	if tracer == nil {
+		return next
+	}
+
+	name := item.Frontend
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethod(r.Method),
+				semconv.HTTPRoute(item.Frontend),
+				semconv.HTTPTarget(r.URL.RequestURI()),
+				semconv.HTTPClientIP(clientIP(r)),
+				attribute.String("route.label", item.Label),
+			),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPStatusCode(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// injectTraceContext sets the trace context headers of the request span on the request sent to the backend
+func injectTraceContext(req *http.Request) {
+	otel.GetTextMapPropagator().Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+}
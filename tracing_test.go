@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := newTracerProvider(TracingConfiguration{Enabled: true}, exporter)
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	})
+
+	traceparents := make(chan string, 3)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("Traceparent")
+		if r.URL.Path == "/tweets/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+tracing:
+  enabled: true
+routes:
+  - frontend: "/tweets/"
+    backend: "%s/tweets/"
+    label: "tweets"
+`, backend.URL))
+
+	get(t, gateway.URL+"/tweets/1", nil)
+	get(t, gateway.URL+"/tweets/fail", nil)
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	get(t, gateway.URL+"/tweets/2", http.Header{"Traceparent": {parent}})
+	if err := provider.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want one per request", len(spans))
+	}
+	for index, want := range []struct {
+		target string
+		status int64
+	}{{"/tweets/1", 200}, {"/tweets/fail", 503}, {"/tweets/2", 200}} {
+		span := spans[index]
+		if span.Name != "GET /tweets/" || span.SpanKind != trace.SpanKindServer {
+			t.Errorf("span %d: got %q of kind %v, want the server span GET /tweets/", index, span.Name, span.SpanKind)
+		}
+		attributes := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes {
+			attributes[kv.Key] = kv.Value
+		}
+		if attributes["http.method"].AsString() != "GET" || attributes["http.route"].AsString() != "/tweets/" ||
+			attributes["http.target"].AsString() != want.target || attributes["route.label"].AsString() != "tweets" ||
+			attributes["http.status_code"].AsInt64() != want.status {
+			t.Errorf("span %d: unexpected attributes %v", index, span.Attributes)
+		}
+		if failed := span.Status.Code == codes.Error; failed != (want.status >= 500) {
+			t.Errorf("span %d: status %v for a %d response", index, span.Status, want.status)
+		}
+
+		// The backend continues the trace of the span
+		traceparent := <-traceparents
+		if wantHeader := fmt.Sprintf("00-%s-%s-01", span.SpanContext.TraceID(), span.SpanContext.SpanID()); traceparent != wantHeader {
+			t.Errorf("span %d: the backend got the traceparent %q, want %q", index, traceparent, wantHeader)
+		}
+	}
+
+	if got := spans[2].SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("the span did not continue the incoming trace, got the trace %s", got)
+	}
+	if got := spans[2].Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("the span parent is %s, want the incoming span", got)
+	}
+}
+
+func TestTracingValidation(t *testing.T) {
+	err := validateTestConfig(t, `
+tracing:
+  enabled: true
+  sampleRatio: 1.5
+routes:
+  - frontend: "/tweets"
+    backend: "http://localhost:8888"
+`)
+	assertProblems(t, err, "tracing.sampleRatio must be between 0 and 1, got 1.5")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 32
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultDialTimeout         = 30 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+type TransportConfiguration struct {
+	MaxConnsPerHost     int           `yaml:"maxConnsPerHost"`
+	MaxIdleConns        int           `yaml:"maxIdleConns"`
+	MaxIdleConnsPerHost int           `yaml:"maxIdleConnsPerHost"`
+	IdleConnTimeout     time.Duration `yaml:"idleConnTimeout"`
+	DialTimeout         time.Duration `yaml:"dialTimeout"`
+	KeepAlive           time.Duration `yaml:"keepAlive"`
+	TLSHandshakeTimeout time.Duration `yaml:"tlsHandshakeTimeout"`
+}
+
+func valueOrDefault[T int | float64 | time.Duration](value T, defaultValue T) T {
+	if value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+func newTransport(config TransportConfiguration) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   valueOrDefault(config.DialTimeout, defaultDialTimeout),
+		KeepAlive: valueOrDefault(config.KeepAlive, defaultKeepAlive),
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+		MaxIdleConns:          valueOrDefault(config.MaxIdleConns, defaultMaxIdleConns),
+		MaxIdleConnsPerHost:   valueOrDefault(config.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost),
+		IdleConnTimeout:       valueOrDefault(config.IdleConnTimeout, defaultIdleConnTimeout),
+		TLSHandshakeTimeout:   valueOrDefault(config.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+func NewHTTPClient(config TransportConfiguration) *http.Client {
+	return &http.Client{Transport: newTransport(config)}
+}
+
+// withDefaults returns the route transport configuration, the parameters it omits are those of the global one
+func (config TransportConfiguration) withDefaults(global TransportConfiguration) TransportConfiguration {
+	return TransportConfiguration{
+		MaxConnsPerHost:     valueOrDefault(config.MaxConnsPerHost, global.MaxConnsPerHost),
+		MaxIdleConns:        valueOrDefault(config.MaxIdleConns, global.MaxIdleConns),
+		MaxIdleConnsPerHost: valueOrDefault(config.MaxIdleConnsPerHost, global.MaxIdleConnsPerHost),
+		IdleConnTimeout:     valueOrDefault(config.IdleConnTimeout, global.IdleConnTimeout),
+		DialTimeout:         valueOrDefault(config.DialTimeout, global.DialTimeout),
+		KeepAlive:           valueOrDefault(config.KeepAlive, global.KeepAlive),
+		TLSHandshakeTimeout: valueOrDefault(config.TLSHandshakeTimeout, global.TLSHandshakeTimeout),
+	}
+}
+
+// newRouteTransport returns the connection pool of a route, isolated from the shared one.
+// Its connections are closed once the context is canceled.
+func newRouteTransport(ctx context.Context, config TransportConfiguration) *http.Transport {
+	transport := newTransport(config)
+	go func() {
+		<-ctx.Done()
+		transport.CloseIdleConnections()
+	}()
+	return transport
+}
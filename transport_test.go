@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingBackend counts the connections opened to it
+func countingBackend(t testing.TB, handler http.HandlerFunc) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	connections := &atomic.Int32{}
+	backend := httptest.NewUnstartedServer(handler)
+	backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	backend.Start()
+	t.Cleanup(backend.Close)
+	return backend, connections
+}
+
+func TestSharedClientReusesConnections(t *testing.T) {
+	backend, connections := countingBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/a"
+    backend: "%s/a"
+    label: "a"
+  - frontend: "/b"
+    backend: "%s/b"
+    label: "b"
+`, backend.URL, backend.URL))
+
+	for index := 0; index < 10; index++ {
+		for _, path := range []string{"/a", "/b"} {
+			if resp, _ := get(t, gateway.URL+path, nil); resp.StatusCode != http.StatusOK {
+				t.Fatalf("GET %s: got %d", path, resp.StatusCode)
+			}
+		}
+	}
+	if got := connections.Load(); got != 1 {
+		t.Errorf("the backend got %d connections, want 1 reused by the routes", got)
+	}
+}
+
+func BenchmarkProxy(b *testing.B) {
+	backend, _ := countingBackend(b, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	config := Configuration{Routes: []GatewayItem{{Frontend: "/a", Backend: backend.URL, Label: "a"}}}
+	store, err := NewStore(config.Store)
+	if err != nil {
+		b.Fatal(err)
+	}
+	handler, err := buildHandler(context.Background(), config, store, NewHTTPClient(config.Transport), NewRegistry(), &drainState{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for index := 0; index < b.N; index++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a", nil))
+		if rec.Code != http.StatusOK {
+			b.Fatalf("got %d", rec.Code)
+		}
+	}
+}
+
+// BenchmarkClient compares the shared client of the gateway to a client created for each request
+func BenchmarkClient(b *testing.B) {
+	backend, _ := countingBackend(b, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	send := func(b *testing.B, client *http.Client) {
+		resp, err := client.Get(backend.URL)
+		if err != nil {
+			b.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	b.Run("shared", func(b *testing.B) {
+		client := NewHTTPClient(TransportConfiguration{})
+		b.ReportAllocs()
+		for index := 0; index < b.N; index++ {
+			send(b, client)
+		}
+	})
+	b.Run("per request", func(b *testing.B) {
+		b.ReportAllocs()
+		for index := 0; index < b.N; index++ {
+			client := NewHTTPClient(TransportConfiguration{})
+			send(b, client)
+			client.CloseIdleConnections()
+		}
+	})
+}
+
+// silentListener accepts connections and never answers on them
+func silentListener(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns []net.Conn
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		<-done
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	return listener.Addr().String()
+}
+
+func TestDialTimeout(t *testing.T) {
+	// A non-routable address, the SYN is never answered
+	const blackHole = "10.255.255.1:80"
+	transport := newTransport(TransportConfiguration{DialTimeout: 100 * time.Millisecond})
+	start := time.Now()
+	conn, err := transport.DialContext(context.Background(), "tcp", blackHole)
+	if err == nil {
+		conn.Close()
+		t.Skipf("%s is reachable from this network", blackHole)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the dial failed after %v, want about 100ms", elapsed)
+	}
+	if netErr, ok := err.(net.Error); ok && !netErr.Timeout() {
+		t.Skipf("%s is unreachable from this network: %v", blackHole, err)
+	}
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(`
+transport:
+  tlsHandshakeTimeout: 100ms
+routes:
+  - frontend: "/tweets"
+    backend: "https://%s"
+`, silentListener(t)))
+
+	start := time.Now()
+	if resp, _ := get(t, gateway.URL+"/tweets", nil); resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("got %d, want 504", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the handshake was aborted after %v, want about 100ms", elapsed)
+	}
+}
+
+func TestTransportDefaults(t *testing.T) {
+	global := TransportConfiguration{DialTimeout: time.Second, KeepAlive: time.Minute}
+	route := TransportConfiguration{DialTimeout: 2 * time.Second}.withDefaults(global)
+	if route.DialTimeout != 2*time.Second || route.KeepAlive != time.Minute {
+		t.Errorf("withDefaults = %+v, want the route dial timeout and the global keep-alive", route)
+	}
+
+	transport := newTransport(TransportConfiguration{})
+	if transport.TLSHandshakeTimeout != defaultTLSHandshakeTimeout || transport.IdleConnTimeout != defaultIdleConnTimeout {
+		t.Errorf("the transport does not use the default timeouts")
+	}
+}
+
+func TestRouteConnectionPoolIsolation(t *testing.T) {
+	var active atomic.Int32
+	var overlapped atomic.Bool
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	backend, connections := countingBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/noisy" {
+			if active.Add(1) > 1 {
+				overlapped.Store(true)
+			}
+			defer active.Add(-1)
+			started <- struct{}{}
+			<-release
+		}
+		io.WriteString(w, "ok")
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/noisy"
+    backend: "%s/noisy"
+    transport:
+      maxConnsPerHost: 1
+  - frontend: "/quiet"
+    backend: "%[1]s/quiet"
+`, backend.URL))
+
+	done := make(chan struct{}, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			if resp, err := http.Get(gateway.URL + "/noisy"); err == nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			done <- struct{}{}
+		}()
+	}
+	<-started
+	time.Sleep(100 * time.Millisecond)
+
+	// The noisy route waits for its only connection, the other routes keep the shared pool
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(gateway.URL + "/quiet")
+	if err != nil {
+		t.Fatalf("the quiet route is starved by the noisy one: %v", err)
+	}
+	resp.Body.Close()
+	if got := active.Load(); got != 1 {
+		t.Errorf("the noisy route has %d requests at the backend, want 1", got)
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	if overlapped.Load() {
+		t.Errorf("the noisy route sent concurrent requests over its single connection")
+	}
+	if got := connections.Load(); got != 2 {
+		t.Errorf("the backend got %d connections, want one per route", got)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// UpstreamTLSConfiguration is the TLS configuration of the connections to the https backends
+type UpstreamTLSConfiguration struct {
+	CAFile             string `yaml:"caFile"`
+	CertFile           string `yaml:"certFile"`
+	KeyFile            string `yaml:"keyFile"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+func validateUpstreamTLS(config *UpstreamTLSConfiguration) []string {
+	var problems []string
+	if config == nil {
+		return problems
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		problems = append(problems, "upstreamTls requires both certFile and keyFile")
+	}
+	return problems
+}
+
+// tlsConfig loads the CA bundle and the client certificate of the configuration
+func (config UpstreamTLSConfiguration) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CAFile != "" {
+		data, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("upstreamTls caFile: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("upstreamTls caFile %s: no PEM certificate found", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("upstreamTls client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// newUpstreamTLSTransport returns a copy of the shared transport with the TLS configuration of the route.
+// Its connections are closed once the context is canceled.
+func newUpstreamTLSTransport(ctx context.Context, base http.RoundTripper, config UpstreamTLSConfiguration) (http.RoundTripper, error) {
+	transport, ok := base.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("upstreamTls cannot be applied to a %T transport", base)
+	}
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	transport = transport.Clone()
+	transport.TLSClientConfig = tlsConfig
+	go func() {
+		<-ctx.Done()
+		transport.CloseIdleConnections()
+	}()
+	return transport, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tlsBackend starts an https backend and writes its certificate to a CA bundle
+func tlsBackend(t *testing.T, handler http.HandlerFunc) (*httptest.Server, string) {
+	t.Helper()
+	backend := httptest.NewUnstartedServer(handler)
+	backend.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	backend.StartTLS()
+	t.Cleanup(backend.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return backend, caFile
+}
+
+func TestUpstreamTLS(t *testing.T) {
+	backend, caFile := tlsBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+			return
+		}
+		w.Write([]byte("anonymous"))
+	})
+	certFile, keyFile, _ := writeSelfSignedCert(t)
+
+	tests := []struct {
+		name   string
+		config string
+		status int
+		body   string
+	}{
+		{"system roots", ``, http.StatusBadGateway, ""},
+		{"custom CA", fmt.Sprintf(`
+    upstreamTls:
+      caFile: "%s"`, caFile), http.StatusOK, "anonymous"},
+		{"skip verify", `
+    upstreamTls:
+      insecureSkipVerify: true`, http.StatusOK, "anonymous"},
+		{"client certificate", fmt.Sprintf(`
+    upstreamTls:
+      caFile: "%s"
+      certFile: "%s"
+      keyFile: "%s"`, caFile, certFile, keyFile), http.StatusOK, "ice-flow-limiter"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/tweets"
+    backend: "%s"%s
+`, backend.URL, test.config))
+			resp, body := get(t, gateway.URL+"/tweets", nil)
+			if resp.StatusCode != test.status {
+				t.Fatalf("got %d, want %d", resp.StatusCode, test.status)
+			}
+			if test.body != "" && body != test.body {
+				t.Errorf("backend answered %q, want %q", body, test.body)
+			}
+		})
+	}
+}
+
+func TestGlobalUpstreamTLS(t *testing.T) {
+	backend, caFile := tlsBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	gateway := newTestGateway(t, fmt.Sprintf(`
+upstreamTls:
+  caFile: "%s"
+routes:
+  - frontend: "/tweets"
+    backend: "%s"
+`, caFile, backend.URL))
+	if resp, body := get(t, gateway.URL+"/tweets", nil); resp.StatusCode != http.StatusOK || body != "ok" {
+		t.Errorf("got %d %q, want the routes to use the global CA", resp.StatusCode, body)
+	}
+}
+
+func TestUpstreamTLSValidation(t *testing.T) {
+	err := validateTestConfig(t, `
+routes:
+  - frontend: "/tweets"
+    backend: "https://localhost:8443"
+    upstreamTls:
+      certFile: "client.pem"
+`)
+	assertProblems(t, err, "upstreamTls requires both certFile and keyFile")
+
+	if _, err := (UpstreamTLSConfiguration{CAFile: filepath.Join(t.TempDir(), "missing.pem")}).tlsConfig(); err == nil {
+		t.Errorf("loaded a missing CA bundle")
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Set at build time with -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+func versionString() string {
+	return fmt.Sprintf("ice-flow-limiter %s (commit %s, built %s, %s)", version, commit, date, runtime.Version())
+}
+
+// registerBuildInfo exposes the version of the running build as a constant gauge
+func registerBuildInfo(registry *prometheus.Registry) {
+	buildInfo := registerCollector(registry, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ice_flow_limiter_build_info",
+		Help: "The version of the running ice-flow-limiter build, the value is always 1.",
+	}, []string{"version", "commit", "date", "goversion"}))
+	buildInfo.WithLabelValues(version, commit, date, runtime.Version()).Set(1)
+}
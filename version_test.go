@@ -0,0 +1,22 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	defer func(v, c, d string) { version, commit, date = v, c, d }(version, commit, date)
+	version, commit, date = "v1.2.3", "abc1234", "2024-05-01T10:00:00Z"
+
+	want := "ice-flow-limiter v1.2.3 (commit abc1234, built 2024-05-01T10:00:00Z, " + runtime.Version() + ")"
+	if got := versionString(); got != want {
+		t.Errorf("versionString() = %q, want %q", got, want)
+	}
+
+	registry := NewRegistry()
+	labels := map[string]string{"version": "v1.2.3", "commit": "abc1234", "date": "2024-05-01T10:00:00Z", "goversion": runtime.Version()}
+	if got := metricValue(t, registry, "ice_flow_limiter_build_info", labels); got != 1 {
+		t.Errorf("ice_flow_limiter_build_info%v = %v, want 1", labels, got)
+	}
+}
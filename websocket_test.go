@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// upgradeEchoBackend accepts the websocket upgrades and echoes the bytes of the session
+func upgradeEchoBackend(t *testing.T) string {
+	t.Helper()
+	return newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if !isWebSocketRequest(r) {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		buf.Flush()
+		io.Copy(conn, buf)
+	}).URL
+}
+
+// dialUpgrade sends a websocket upgrade request to the gateway and returns the connection with its response
+func dialUpgrade(t *testing.T, gatewayURL string, path string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(gatewayURL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n", path)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, resp
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/ws"
+    backend: "%s"
+    websocket: true
+`, upgradeEchoBackend(t)))
+
+	conn, reader, resp := dialUpgrade(t, gateway.URL, "/ws")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade: got %d, want 101", resp.StatusCode)
+	}
+	for _, message := range []string{"hello\n", "world\n"} {
+		if _, err := io.WriteString(conn, message); err != nil {
+			t.Fatal(err)
+		}
+		echoed, err := reader.ReadString('\n')
+		if err != nil || echoed != message {
+			t.Fatalf("echo: got %q, %v, want %q", echoed, err, message)
+		}
+	}
+}
+
+func TestWebSocketDisabled(t *testing.T) {
+	gateway := newTestGateway(t, fmt.Sprintf(`
+routes:
+  - frontend: "/ws"
+    backend: "%s"
+`, upgradeEchoBackend(t)))
+
+	_, _, resp := dialUpgrade(t, gateway.URL, "/ws")
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("upgrade on a route without websocket: got %d, want the 426 of the backend", resp.StatusCode)
+	}
+}